@@ -1,19 +1,17 @@
 package main
 
 import (
-	"bytes"
-	"io/ioutil"
+	"fmt"
 	"log"
 	"math/rand"
 	"os"
-	"reflect"
-	"strconv"
-	"sync"
+	"regexp"
 	"time"
 
 	"github.com/jessevdk/go-flags"
 
 	"gopkg.in/kothar/go-backblaze.v0"
+	"gopkg.in/kothar/go-backblaze.v0/b2test"
 )
 
 // Options defines command line flags used by this application
@@ -22,9 +20,19 @@ type Options struct {
 	AccountID      string `long:"account" env:"B2_ACCOUNT_ID" description:"The account ID to use"`
 	ApplicationKey string `long:"appKey" env:"B2_APP_KEY" description:"The application key to use"`
 
-	// Bucket
-	Bucket string `short:"b" long:"bucket" description:"The bucket name to use for testing (a random bucket name will be chosen if not specified)"`
-	Debug  bool   `short:"d" long:"debug" description:"Show debug information during test"`
+	// Endpoint
+	Host     string `long:"host" env:"B2_HOST" description:"The API endpoint to test (defaults to the production B2 API)"`
+	Emulator bool   `long:"emulator" description:"Test an in-process emulator instead of a remote endpoint"`
+	S3       bool   `long:"s3" description:"Test file operations through the S3-compatible API"`
+
+	// Scenarios
+	Bucket   string `short:"b" long:"bucket" default:"test-bucket-" description:"The prefix of the bucket names to use for testing (a random bucket is created for each scenario)"`
+	Run      string `long:"run" description:"Only run scenarios with names matching this regular expression"`
+	PartSize int64  `long:"partSize" default:"5000000" description:"The part size to use when testing large files"`
+
+	// Output
+	JUnit string `long:"junit" description:"Write a JUnit XML report to this file"`
+	Debug bool   `short:"d" long:"debug" description:"Show debug information during test"`
 }
 
 var opts = &Options{}
@@ -42,207 +50,145 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create client
-	b2, err := backblaze.NewB2(backblaze.Credentials{
+	selected := scenarios
+	if opts.Run != "" {
+		pattern, err := regexp.Compile(opts.Run)
+		if err != nil {
+			log.Fatalf("Invalid --run pattern: %v", err)
+		}
+		selected = nil
+		for _, s := range scenarios {
+			if pattern.MatchString(s.name) {
+				selected = append(selected, s)
+			}
+		}
+	}
+	if len(selected) == 0 {
+		log.Fatal("No scenarios to run")
+	}
+
+	credentials := backblaze.Credentials{
 		AccountID:      opts.AccountID,
 		ApplicationKey: opts.ApplicationKey,
-	})
-	b2.Debug = opts.Debug
-	check(err)
-
-	b := testBucketCreate(b2)
-
-	// Test basic file operations
-	f, data := testFileUpload(b)
-	testFileDownload(b, f, data)
-	testFileRangeDownload(b, f, data)
-	testFileDelete(b, f)
-
-	// Test file listing calls
-	files := uploadFiles(b)
-	testListFiles(b, files)
-	testListDirectories(b, files)
-	deleteFiles(b, files)
-
-	testBucketDelete(b)
-}
-
-func testBucketCreate(b2 *backblaze.B2) *backblaze.Bucket {
-	// Get Test bucket
-	if opts.Bucket == "" {
-		opts.Bucket = "test-bucket-" + randSeq(10)
 	}
-	log.Printf("Testing with bucket %s", opts.Bucket)
+	host := opts.Host
+	if opts.Emulator {
+		server := b2test.NewUnstartedServer()
+		server.RecommendedPartSize = opts.PartSize
+		server.AbsoluteMinimumPartSize = opts.PartSize
+		server.Start()
+		defer server.Close()
 
-	b, err := b2.Bucket(opts.Bucket)
-	check(err)
-	if b != nil {
-		log.Fatal("Testing bucket already exists")
+		credentials.AccountID = server.AccountID
+		credentials.ApplicationKey = server.ApplicationKey
+		host = server.URL
 	}
 
-	b, err = b2.CreateBucket(opts.Bucket, backblaze.AllPrivate)
-	check(err)
-	log.Print("Bucket created")
-
-	return b
-}
-
-func testBucketDelete(b *backblaze.Bucket) {
-	check(b.Delete())
-	log.Print("Bucket deleted")
-}
-
-func testFileUpload(b *backblaze.Bucket) (*backblaze.File, []byte) {
-	fileData := randBytes(1024 * 1024)
-
-	f, err := b.UploadFile("test_file", nil, bytes.NewBuffer(fileData))
-	check(err)
-
-	log.Print("File uploaded")
-
-	return f, fileData
-}
-
-func testFileDownload(b *backblaze.Bucket, f *backblaze.File, data []byte) {
-	f, reader, err := b.DownloadFileByName(f.Name)
-	check(err)
-
-	body, err := ioutil.ReadAll(reader)
-	check(err)
-
-	if !bytes.Equal(body, data) {
-		log.Fatal("Downloaded file content does not match upload")
+	// Create client
+	b2 := &backblaze.B2{
+		Credentials: credentials,
+		Host:        host,
+		Debug:       opts.Debug,
 	}
-
-	log.Print("File downloaded")
-}
-
-func testFileRangeDownload(b *backblaze.Bucket, f *backblaze.File, data []byte) {
-	f, reader, err := b.DownloadFileRangeByName(f.Name, &backblaze.FileRange{Start: 100, End: 2000})
-	check(err)
-
-	body, err := ioutil.ReadAll(reader)
-	check(err)
-
-	if !bytes.Equal(body, data[100:2000+1]) {
-		log.Fatal("Downloaded file range does not match upload")
+	if opts.S3 {
+		b2.Protocol = backblaze.ProtocolS3
 	}
-
-	log.Print("File range downloaded")
-}
-
-func testFileDelete(b *backblaze.Bucket, f *backblaze.File) {
-	_, err := b.DeleteFileVersion(f.Name, f.ID)
-	check(err)
-
-	log.Print("File deleted")
-}
-
-func uploadFiles(b *backblaze.Bucket) []*backblaze.File {
-	fileData := randBytes(1024)
-
-	files := []*backblaze.File{}
-
-	queue := make(chan int64)
-	var m sync.Mutex
-	var wg sync.WaitGroup
-
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func() {
-			for n := range queue {
-				f, err := b.UploadFile("test/file_"+strconv.FormatInt(n, 10), nil, bytes.NewBuffer(fileData))
-				check(err)
-
-				m.Lock()
-				files = append(files, f)
-				m.Unlock()
-			}
-
-			wg.Done()
-		}()
+	if err := b2.AuthorizeAccount(); err != nil {
+		log.Fatal(err)
 	}
 
-	// Upload files
-	count := 40
-	for i := 1; i <= count; i++ {
-		log.Printf("Uploading file %d/%d...", i, count)
-		queue <- int64(i)
+	start := time.Now()
+	results := make([]result, 0, len(selected))
+	for _, s := range selected {
+		log.Printf("Running %s", s.name)
+		results = append(results, runScenario(b2, s))
 	}
+	elapsed := time.Since(start)
 
-	close(queue)
-	wg.Wait()
-	log.Println("Done.")
+	failed := printReport(os.Stdout, results)
+	if opts.JUnit != "" {
+		if err := writeJUnit(opts.JUnit, results, start, elapsed); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
 
-	return files
+// A test scenario, which is run using its own bucket
+type scenario struct {
+	name string
+	run  func(t *test)
 }
 
-func testListFiles(b *backblaze.Bucket, files []*backblaze.File) {
+// The outcome of running a scenario
+type result struct {
+	name    string
+	elapsed time.Duration
+	failure string // Empty if the scenario passed
+	cleanup string // Any error removing the scenario's files and bucket
+}
 
-	// List bucket content
-	log.Println("Listing bucket contents")
-	bulkResponse, err := b.ListFileNames("", 500)
-	check(err)
-	if len(bulkResponse.Files) != len(files) {
-		log.Fatalf("Expected listing to return %d files but found %d", len(files), len(bulkResponse.Files))
-	}
+// The state of a running scenario
+type test struct {
+	name   string
+	b2     *backblaze.B2
+	bucket *backblaze.Bucket
+}
 
-	// Test paging
-	log.Println("Paging bucket contents")
-	pagedFiles := []backblaze.FileStatus{}
-	cursor := ""
-	for {
-		r, err := b.ListFileNames(cursor, 10)
-		check(err)
+// A failure reported by a scenario, which stops it running
+type failure string
 
-		pagedFiles = append(pagedFiles, r.Files...)
+// Runs a scenario in a new bucket, deleting the bucket afterwards
+func runScenario(b2 *backblaze.B2, s scenario) result {
+	start := time.Now()
+	t := &test{name: s.name, b2: b2}
 
-		if r.NextFileName == "" {
-			break
+	res := result{name: s.name}
+	res.failure = t.protect(func() {
+		t.bucket = t.createBucket(opts.Bucket + s.name + "-" + randSeq(8))
+		s.run(t)
+	})
+	if t.bucket != nil {
+		res.cleanup = t.protect(t.deleteBucket)
+	}
+	res.elapsed = time.Since(start)
+	return res
+}
+
+// Runs fn, returning the message of any failure
+func (t *test) protect(fn func()) (message string) {
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+		case failure:
+			message = string(r)
+		default:
+			message = fmt.Sprintf("panic: %v", r)
 		}
+	}()
 
-		cursor = r.NextFileName
-	}
-
-	if !reflect.DeepEqual(bulkResponse.Files, pagedFiles) {
-		log.Fatalf("Result of paged directory listing does not match bulk listing")
-	}
+	fn()
+	return ""
 }
 
-func testListDirectories(b *backblaze.Bucket, files []*backblaze.File) {
-	// List root directory
-	log.Println("Listing root directory contents")
-	bulkResponse, err := b.ListFileNamesWithPrefix("", 500, "", "/")
-	check(err)
-	if len(bulkResponse.Files) != 1 {
-		log.Fatalf("Expected listing to return 1 directory but found %d", len(bulkResponse.Files))
+// Stops the scenario if err is not nil
+func (t *test) check(err error) {
+	if err == nil {
+		return
 	}
 
-	// List subdirectory
-	log.Println("Listing subdirectory contents")
-	bulkResponse, err = b.ListFileNamesWithPrefix("", 500, "test/", "/")
-	check(err)
-	if len(bulkResponse.Files) != len(files) {
-		log.Fatalf("Expected listing to return %d files but found %d", len(files), len(bulkResponse.Files))
-	}
+	panic(failure(err.Error()))
 }
 
-func deleteFiles(b *backblaze.Bucket, files []*backblaze.File) {
-	// Delete files
-	log.Printf("Deleting %d files...", len(files))
-	for _, f := range files {
-		_, err := b.DeleteFileVersion(f.Name, f.ID)
-		check(err)
-	}
-	log.Println("Done.")
+// Stops the scenario with a failure message
+func (t *test) fatalf(format string, args ...interface{}) {
+	panic(failure(fmt.Sprintf(format, args...)))
 }
 
-func check(err error) {
-	if err == nil {
-		return
-	}
-
-	log.Fatal(err)
+func (t *test) logf(format string, args ...interface{}) {
+	log.Printf(t.name+": "+format, args...)
 }
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Prints a pass/fail line for each result, followed by a summary. Returns the
+// number of scenarios which failed.
+func printReport(w io.Writer, results []result) int {
+	failed := 0
+	for _, r := range results {
+		status := "PASS"
+		if r.failed() {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "%s  %-12s %.2fs\n", status, r.name, r.elapsed.Seconds())
+		if r.failure != "" {
+			fmt.Fprintf(w, "      %s\n", r.failure)
+		}
+		if r.cleanup != "" {
+			fmt.Fprintf(w, "      cleanup: %s\n", r.cleanup)
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d failed\n", len(results)-failed, failed)
+	return failed
+}
+
+func (r result) failed() bool {
+	return r.failure != "" || r.cleanup != ""
+}
+
+// JUnit XML report format
+type junitSuite struct {
+	XMLName   xml.Name    `xml:"testsuite"`
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Writes the results as a JUnit XML report
+func writeJUnit(path string, results []result, start time.Time, elapsed time.Duration) error {
+	suite := junitSuite{
+		Name:      "apitest",
+		Tests:     len(results),
+		Time:      seconds(elapsed),
+		Timestamp: start.UTC().Format("2006-01-02T15:04:05"),
+	}
+	for _, r := range results {
+		c := junitCase{
+			Name:      r.name,
+			ClassName: "apitest",
+			Time:      seconds(r.elapsed),
+		}
+		if r.failed() {
+			suite.Failures++
+			message := r.failure
+			if message == "" {
+				message = "cleanup: " + r.cleanup
+			}
+			c.Failure = &junitFailure{Message: message, Text: message}
+			if r.failure != "" && r.cleanup != "" {
+				c.Failure.Text += "\ncleanup: " + r.cleanup
+			}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(xml.Header); err != nil {
+		f.Close()
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
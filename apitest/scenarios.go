@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"sync"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// The scenarios run by the suite, in order
+var scenarios = []scenario{
+	{"buckets", testBuckets},
+	{"files", testFiles},
+	{"ranges", testRanges},
+	{"listing", testListing},
+	{"versions", testVersions},
+	{"hide", testHide},
+	{"copy", testCopy},
+	{"large-file", testLargeFile},
+}
+
+func (t *test) createBucket(name string) *backblaze.Bucket {
+	t.logf("Creating bucket %s", name)
+
+	b, err := t.b2.Bucket(name)
+	t.check(err)
+	if b != nil {
+		t.fatalf("Testing bucket %s already exists", name)
+	}
+
+	b, err = t.b2.CreateBucket(name, backblaze.AllPrivate)
+	t.check(err)
+	return b
+}
+
+// Deletes all file versions in the test bucket, then the bucket itself
+func (t *test) deleteBucket() {
+	cursor, cursorID := "", ""
+	for {
+		r, err := t.bucket.ListFileVersions(cursor, cursorID, 1000)
+		t.check(err)
+
+		for _, f := range r.Files {
+			_, err := t.bucket.DeleteFileVersion(f.Name, f.ID)
+			t.check(err)
+		}
+
+		if r.NextFileName == "" {
+			break
+		}
+		cursor, cursorID = r.NextFileName, r.NextFileID
+	}
+
+	t.check(t.bucket.Delete())
+	t.logf("Bucket deleted")
+}
+
+func (t *test) upload(name string, meta map[string]string, data []byte) *backblaze.File {
+	f, err := t.bucket.UploadFile(name, meta, bytes.NewReader(data))
+	t.check(err)
+	return f
+}
+
+// Reads a download, returning its content
+func (t *test) download(f *backblaze.File, reader io.ReadCloser, err error) ([]byte, *backblaze.File) {
+	t.check(err)
+	defer reader.Close()
+
+	body, err := ioutil.ReadAll(reader)
+	t.check(err)
+	return body, f
+}
+
+func (t *test) expectContent(what string, body, data []byte) {
+	if !bytes.Equal(body, data) {
+		t.fatalf("%s does not match upload (expected %d bytes, saw %d)", what, len(data), len(body))
+	}
+}
+
+// Returns all versions of a file, newest first
+func (t *test) versions(name string) []backblaze.FileStatus {
+	r, err := t.bucket.ListFileVersionsWithPrefix("", "", 1000, name, "")
+	t.check(err)
+
+	var versions []backblaze.FileStatus
+	for _, f := range r.Files {
+		if f.Name == name {
+			versions = append(versions, f)
+		}
+	}
+	return versions
+}
+
+func testBuckets(t *test) {
+	buckets, err := t.b2.ListBuckets()
+	t.check(err)
+	found := false
+	for _, b := range buckets {
+		if b.ID == t.bucket.ID {
+			found = true
+		}
+	}
+	if !found {
+		t.fatalf("Bucket %s was not listed", t.bucket.Name)
+	}
+
+	b, err := t.b2.Bucket(t.bucket.Name)
+	t.check(err)
+	if b == nil || b.ID != t.bucket.ID {
+		t.fatalf("Bucket %s was not found by name", t.bucket.Name)
+	}
+
+	if _, err := t.b2.CreateBucket(t.bucket.Name, backblaze.AllPrivate); !errors.Is(err, backblaze.ErrDuplicateBucketName) {
+		t.fatalf("Expected a duplicate bucket name error, saw %v", err)
+	}
+
+	t.upload("test_file", nil, []byte("test"))
+	if err := t.bucket.Delete(); !errors.Is(err, backblaze.ErrBucketNotEmpty) {
+		t.fatalf("Expected a bucket not empty error, saw %v", err)
+	}
+}
+
+func testFiles(t *test) {
+	meta := map[string]string{"src_last_modified_millis": "1234567890000"}
+	data := randBytes(1024 * 1024)
+	f := t.upload("test_file", meta, data)
+	t.logf("File uploaded")
+
+	if f.ContentLength != int64(len(data)) {
+		t.fatalf("Expected uploaded length %d, saw %d", len(data), f.ContentLength)
+	}
+
+	body, downloaded := t.download(t.bucket.DownloadFileByName(f.Name))
+	t.expectContent("Downloaded file content", body, data)
+	if downloaded.ID != f.ID || downloaded.FileInfo["src_last_modified_millis"] != meta["src_last_modified_millis"] {
+		t.fatalf("Downloaded file %s has info %v", downloaded.ID, downloaded.FileInfo)
+	}
+
+	body, _ = t.download(t.b2.DownloadFileByID(f.ID))
+	t.expectContent("File downloaded by ID", body, data)
+	t.logf("File downloaded")
+
+	info, err := t.bucket.GetFileInfo(f.ID)
+	t.check(err)
+	if info.Name != f.Name || info.ContentSha1 != f.ContentSha1 || info.FileInfo["src_last_modified_millis"] != meta["src_last_modified_millis"] {
+		t.fatalf("Unexpected file info %+v", info)
+	}
+
+	_, err = t.bucket.DeleteFileVersion(f.Name, f.ID)
+	t.check(err)
+	if _, _, err := t.bucket.DownloadFileByName(f.Name); !errors.Is(err, backblaze.ErrNotFound) {
+		t.fatalf("Expected deleted file to be not found, saw %v", err)
+	}
+	t.logf("File deleted")
+}
+
+func testRanges(t *test) {
+	data := randBytes(64 * 1024)
+	f := t.upload("test_file", nil, data)
+
+	ranges := []backblaze.FileRange{
+		{Start: 0, End: 0},
+		{Start: 100, End: 2000},
+		{Start: int64(len(data)) - 1, End: int64(len(data)) - 1},
+		{Start: 1000, End: int64(len(data)) - 1},
+	}
+	for _, r := range ranges {
+		r := r
+		expected := data[r.Start : r.End+1]
+
+		body, _ := t.download(t.bucket.DownloadFileRangeByName(f.Name, &r))
+		t.expectContent("Range "+strconv.FormatInt(r.Start, 10)+"-"+strconv.FormatInt(r.End, 10), body, expected)
+
+		body, _ = t.download(t.b2.DownloadFileRangeByID(f.ID, &r))
+		t.expectContent("Range by ID "+strconv.FormatInt(r.Start, 10)+"-"+strconv.FormatInt(r.End, 10), body, expected)
+	}
+	t.logf("File ranges downloaded")
+}
+
+func testListing(t *test) {
+	fileData := randBytes(1024)
+
+	files := []*backblaze.File{}
+
+	queue := make(chan int64)
+	errs := make(chan error, 1)
+	var m sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			for n := range queue {
+				f, err := t.bucket.UploadFile("test/file_"+strconv.FormatInt(n, 10), nil, bytes.NewReader(fileData))
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					continue
+				}
+
+				m.Lock()
+				files = append(files, f)
+				m.Unlock()
+			}
+
+			wg.Done()
+		}()
+	}
+
+	// Upload files
+	count := 40
+	for i := 1; i <= count; i++ {
+		queue <- int64(i)
+	}
+
+	close(queue)
+	wg.Wait()
+	select {
+	case err := <-errs:
+		t.check(err)
+	default:
+	}
+	t.logf("Uploaded %d files", count)
+
+	// List bucket content
+	bulkResponse, err := t.bucket.ListFileNames("", 500)
+	t.check(err)
+	if len(bulkResponse.Files) != len(files) {
+		t.fatalf("Expected listing to return %d files but found %d", len(files), len(bulkResponse.Files))
+	}
+
+	// Test paging
+	pagedFiles := []backblaze.FileStatus{}
+	cursor := ""
+	for {
+		r, err := t.bucket.ListFileNames(cursor, 10)
+		t.check(err)
+
+		pagedFiles = append(pagedFiles, r.Files...)
+
+		if r.NextFileName == "" {
+			break
+		}
+
+		cursor = r.NextFileName
+	}
+
+	if !reflect.DeepEqual(bulkResponse.Files, pagedFiles) {
+		t.fatalf("Result of paged directory listing does not match bulk listing")
+	}
+
+	// List root directory
+	bulkResponse, err = t.bucket.ListFileNamesWithPrefix("", 500, "", "/")
+	t.check(err)
+	if len(bulkResponse.Files) != 1 || bulkResponse.Files[0].Name != "test/" {
+		t.fatalf("Expected listing to return 1 directory but found %d entries", len(bulkResponse.Files))
+	}
+
+	// List subdirectory
+	bulkResponse, err = t.bucket.ListFileNamesWithPrefix("", 500, "test/", "/")
+	t.check(err)
+	if len(bulkResponse.Files) != len(files) {
+		t.fatalf("Expected listing to return %d files but found %d", len(files), len(bulkResponse.Files))
+	}
+}
+
+func testVersions(t *test) {
+	var uploaded []*backblaze.File
+	var contents [][]byte
+	for i := 0; i < 3; i++ {
+		data := randBytes(1024)
+		uploaded = append(uploaded, t.upload("test_file", nil, data))
+		contents = append(contents, data)
+	}
+
+	// Versions are listed newest first
+	versions := t.versions("test_file")
+	if len(versions) != len(uploaded) {
+		t.fatalf("Expected %d versions, found %d", len(uploaded), len(versions))
+	}
+	for i, v := range versions {
+		if expected := uploaded[len(uploaded)-1-i]; v.ID != expected.ID {
+			t.fatalf("Expected version %d to be %s, saw %s", i, expected.ID, v.ID)
+		}
+	}
+
+	// Page through versions one at a time
+	cursor, cursorID := "", ""
+	for i := range uploaded {
+		r, err := t.bucket.ListFileVersions(cursor, cursorID, 1)
+		t.check(err)
+		if len(r.Files) != 1 || r.Files[0].ID != versions[i].ID {
+			t.fatalf("Expected page %d to contain version %s", i, versions[i].ID)
+		}
+		cursor, cursorID = r.NextFileName, r.NextFileID
+	}
+	if cursor != "" {
+		t.fatalf("Expected no more versions after the last page, saw %s %s", cursor, cursorID)
+	}
+
+	// Old versions can be downloaded by ID
+	body, _ := t.download(t.b2.DownloadFileByID(uploaded[0].ID))
+	t.expectContent("Oldest version", body, contents[0])
+
+	// Deleting the newest version makes the previous one current
+	_, err := t.bucket.DeleteFileVersion("test_file", uploaded[2].ID)
+	t.check(err)
+	body, f := t.download(t.bucket.DownloadFileByName("test_file"))
+	t.expectContent("Previous version", body, contents[1])
+	if f.ID != uploaded[1].ID {
+		t.fatalf("Expected previous version %s to be current, saw %s", uploaded[1].ID, f.ID)
+	}
+}
+
+func testHide(t *test) {
+	data := randBytes(1024)
+	f := t.upload("test_file", nil, data)
+
+	hidden, err := t.bucket.HideFile(f.Name)
+	t.check(err)
+	if hidden.Action != backblaze.Hide {
+		t.fatalf("Expected hide action, saw %s", hidden.Action)
+	}
+
+	if _, _, err := t.bucket.DownloadFileByName(f.Name); !errors.Is(err, backblaze.ErrNotFound) {
+		t.fatalf("Expected hidden file to be not found, saw %v", err)
+	}
+	r, err := t.bucket.ListFileNames("", 100)
+	t.check(err)
+	if len(r.Files) != 0 {
+		t.fatalf("Expected hidden file not to be listed, saw %d files", len(r.Files))
+	}
+
+	// The hidden version is still available
+	versions := t.versions(f.Name)
+	if len(versions) != 2 || versions[0].Action != backblaze.Hide || versions[1].ID != f.ID {
+		t.fatalf("Expected a hide marker and the uploaded version, saw %+v", versions)
+	}
+	body, _ := t.download(t.b2.DownloadFileByID(f.ID))
+	t.expectContent("Hidden file downloaded by ID", body, data)
+
+	// Removing the hide marker restores the file
+	_, err = t.bucket.DeleteFileVersion(hidden.Name, hidden.ID)
+	t.check(err)
+	body, _ = t.download(t.bucket.DownloadFileByName(f.Name))
+	t.expectContent("Restored file", body, data)
+}
+
+func testCopy(t *test) {
+	meta := map[string]string{"src_last_modified_millis": "1234567890000"}
+	data := randBytes(1024)
+	f, err := t.bucket.UploadTypedFile("source", "application/x-test", meta, bytes.NewReader(data))
+	t.check(err)
+	t.upload("source", nil, randBytes(1024))
+
+	// Copy the older version, keeping its metadata
+	copied, err := t.bucket.CopyFile(f.ID, "copy", "", backblaze.FileMetaDirectiveCopy)
+	t.check(err)
+	if copied.ID == f.ID || copied.Name != "copy" {
+		t.fatalf("Unexpected copy %s %s", copied.ID, copied.Name)
+	}
+	if copied.ContentType != "application/x-test" || copied.FileInfo["src_last_modified_millis"] != meta["src_last_modified_millis"] {
+		t.fatalf("Metadata was not copied: %s %v", copied.ContentType, copied.FileInfo)
+	}
+
+	body, _ := t.download(t.bucket.DownloadFileByName("copy"))
+	t.expectContent("Copied file", body, data)
+
+	if versions := t.versions("source"); len(versions) != 2 {
+		t.fatalf("Expected the source file to be unchanged, saw %d versions", len(versions))
+	}
+}
+
+func testLargeFile(t *test) {
+	data := randBytes(int(opts.PartSize*2 + opts.PartSize/2))
+	f, err := t.bucket.UploadLargeFileOptions("large_file", "application/octet-stream", nil, bytes.NewReader(data), int64(len(data)), opts.PartSize, 3)
+	t.check(err)
+	t.logf("Large file uploaded")
+
+	if f.ContentLength != int64(len(data)) {
+		t.fatalf("Expected large file length %d, saw %d", len(data), f.ContentLength)
+	}
+
+	body, _ := t.download(t.bucket.DownloadFileByName(f.Name))
+	t.expectContent("Large file", body, data)
+
+	// A range spanning the boundary between parts
+	r := &backblaze.FileRange{Start: opts.PartSize - 10, End: opts.PartSize + 9}
+	body, _ = t.download(t.b2.DownloadFileRangeByID(f.ID, r))
+	t.expectContent("Large file range", body, data[r.Start:r.End+1])
+
+	reader, err := t.b2.ReadaheadFile(f)
+	t.check(err)
+	body, _ = t.download(f, reader, nil)
+	t.expectContent("Large file read ahead", body, data)
+}
@@ -2,17 +2,49 @@
 
 package backblaze
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // B2Error encapsulates an error message returned by the B2 API.
 //
 // Failures to connect to the B2 servers, and networking problems in general can cause errors
+// Use errors.Is to test for particular kinds of failure, such as ErrNotFound.
 type B2Error struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
 	Status  int    `json:"status"`
+
+	// The context of the request which failed
+	API       string `json:"-"` // The API called, e.g. "b2_upload_file"
+	FileName  string `json:"-"` // The file being accessed, if known
+	FileID    string `json:"-"` // The ID of the file being accessed, if known
+	RequestID string `json:"-"` // The ID assigned to the request by the service, if returned
 }
 
 func (e B2Error) Error() string {
-	return e.Code + ": " + e.Message
+	msg := e.Code + ": " + e.Message
+	if e.API != "" {
+		msg = e.API + ": " + msg
+	}
+
+	var context []string
+	if e.FileName != "" {
+		context = append(context, "file "+strconv.Quote(e.FileName))
+	}
+	if e.FileID != "" {
+		context = append(context, "file ID "+e.FileID)
+	}
+	if e.RequestID != "" {
+		context = append(context, "request ID "+e.RequestID)
+	}
+	if len(context) > 0 {
+		msg += " (" + strings.Join(context, ", ") + ")"
+	}
+	return msg
 }
 
 // IsFatal returns true if this error represents
@@ -38,10 +70,13 @@ func (e *B2Error) IsFatal() bool {
 }
 
 type authorizeAccountResponse struct {
-	AccountID          string `json:"accountId"`
-	APIEndpoint        string `json:"apiUrl"`
-	AuthorizationToken string `json:"authorizationToken"`
-	DownloadURL        string `json:"downloadUrl"`
+	AccountID               string `json:"accountId"`
+	APIEndpoint             string `json:"apiUrl"`
+	AuthorizationToken      string `json:"authorizationToken"`
+	DownloadURL             string `json:"downloadUrl"`
+	RecommendedPartSize     int64  `json:"recommendedPartSize"`
+	AbsoluteMinimumPartSize int64  `json:"absoluteMinimumPartSize"`
+	S3APIURL                string `json:"s3ApiUrl"`
 }
 
 type accountRequest struct {
@@ -113,12 +148,12 @@ type deleteBucketRequest struct {
 
 // updateBucketRequest describes the request parameters that may be provided to the b2_update_bucket API endpoint
 type updateBucketRequest struct {
-	AccountID      string            `json:"accountId"`                // The account that the bucket is in
-	BucketID       string            `json:"bucketId"`                 // The unique ID of the bucket
-	BucketType     BucketType        `json:"bucketType,omitempty"`     // If not specified, setting will remain unchanged
-	BucketInfo     map[string]string `json:"bucketInfo,omitempty"`     // If not specified, setting will remain unchanged
-	LifecycleRules []LifecycleRule   `json:"lifecycleRules,omitempty"` // If not specified, setting will remain unchanged
-	IfRevisionIs   int               `json:"ifRevisionIs,omitempty"`   // When set, the update will only happen if the revision number stored in the B2 service matches the one passed in
+	AccountID      string             `json:"accountId"`                // The account that the bucket is in
+	BucketID       string             `json:"bucketId"`                 // The unique ID of the bucket
+	BucketType     BucketType         `json:"bucketType,omitempty"`     // If not specified, setting will remain unchanged
+	BucketInfo     *map[string]string `json:"bucketInfo,omitempty"`     // If not specified, setting will remain unchanged
+	LifecycleRules *[]LifecycleRule   `json:"lifecycleRules,omitempty"` // If not specified, setting will remain unchanged
+	IfRevisionIs   int                `json:"ifRevisionIs,omitempty"`   // When set, the update will only happen if the revision number stored in the B2 service matches the one passed in
 }
 
 type getUploadURLResponse struct {
@@ -127,6 +162,36 @@ type getUploadURLResponse struct {
 	AuthorizationToken string `json:"authorizationToken"`
 }
 
+type startLargeFileRequest struct {
+	BucketID    string            `json:"bucketId"`
+	FileName    string            `json:"fileName"`
+	ContentType string            `json:"contentType"`
+	FileInfo    map[string]string `json:"fileInfo,omitempty"`
+}
+
+type getUploadPartURLResponse struct {
+	FileID             string `json:"fileId"`
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+type uploadPartResponse struct {
+	FileID        string `json:"fileId"`
+	PartNumber    int    `json:"partNumber"`
+	ContentLength int64  `json:"contentLength"`
+	ContentSha1   string `json:"contentSha1"`
+}
+
+type finishLargeFileRequest struct {
+	ID            string   `json:"fileId"`
+	PartSha1Array []string `json:"partSha1Array"`
+}
+
+type listBucketsRequest struct {
+	AccountID string `json:"accountId"`
+	BucketID  string `json:"bucketId,omitempty"`
+}
+
 type listBucketsResponse struct {
 	Buckets []*BucketInfo `json:"buckets"`
 }
@@ -153,6 +218,35 @@ type File struct {
 	Action          FileAction        `json:"action"`
 	Size            int               `json:"size"` // Deprecated - same as ContentSha1
 	UploadTimestamp int64             `json:"uploadTimestamp"`
+
+	// Set for downloads from the Content-Range response header: the part of
+	// the file which was returned, and the total size of the file
+	downloadRange *FileRange
+	downloadSize  int64
+}
+
+// SHA1 returns the SHA1 hash of the file's content, or an empty string if it
+// is not known. Large files only have a hash if it was recorded in their
+// large_file_sha1 file info when they were uploaded.
+func (f *File) SHA1() string {
+	sha1 := strings.TrimPrefix(f.ContentSha1, "unverified:")
+	if sha1 == "" || sha1 == "none" {
+		sha1 = f.FileInfo["large_file_sha1"]
+	}
+	return sha1
+}
+
+// ModTime returns the modification time recorded in the file's
+// src_last_modified_millis file info when it was uploaded, or its upload time
+// if none was recorded. The zero time is returned if neither is known.
+func (f *File) ModTime() time.Time {
+	if millis, err := strconv.ParseInt(f.FileInfo["src_last_modified_millis"], 10, 64); err == nil {
+		return time.Unix(0, millis*int64(time.Millisecond))
+	}
+	if f.UploadTimestamp > 0 {
+		return time.Unix(0, f.UploadTimestamp*int64(time.Millisecond))
+	}
+	return time.Time{}
 }
 
 // FileRange describes a range of bytes in a file by its 0-based start and end position (inclusive)
@@ -161,6 +255,14 @@ type FileRange struct {
 	End   int64
 }
 
+// Returns the Range request header for the range, or an empty string for a nil range
+func (r *FileRange) header() string {
+	if r == nil {
+		return ""
+	}
+	return fmt.Sprintf("bytes=%d-%d", r.Start, r.End)
+}
+
 type listFilesRequest struct {
 	BucketID      string `json:"bucketId"`
 	StartFileName string `json:"startFileName"`
@@ -180,6 +282,8 @@ type listFileVersionsRequest struct {
 	StartFileName string `json:"startFileName,omitempty"`
 	StartFileID   string `json:"startFileId,omitempty"`
 	MaxFileCount  int    `json:"maxFileCount,omitempty"`
+	Prefix        string `json:"prefix,omitempty"`
+	Delimiter     string `json:"delimiter,omitempty"`
 }
 
 // ListFileVersionsResponse lists a page of file versions stored in a B2 bucket
@@ -216,9 +320,14 @@ type FileAction string
 // Hiding a file makes it look like the file has been deleted, without
 // removing any of the history. It adds a new version of the file that is a
 // marker saying the file is no longer there.
+//
+// Listings may also include large files which have been started but not
+// finished, and folders when a delimiter is used.
 const (
 	Upload FileAction = "upload"
 	Hide   FileAction = "hide"
+	Start  FileAction = "start"
+	Folder FileAction = "folder"
 )
 
 // FileStatus is now identical to File in repsonses from ListFileNames and ListFileVersions
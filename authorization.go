@@ -0,0 +1,64 @@
+package backblaze
+
+import (
+	"time"
+)
+
+// Authorization tokens returned by b2_authorize_account are valid for 24 hours
+const tokenLifetime = 24 * time.Hour
+
+// DefaultTokenRefreshMargin is how long before an authorization token expires
+// that the client will request a new one, unless B2.TokenRefreshMargin is set.
+const DefaultTokenRefreshMargin = time.Hour
+
+// How long to wait before retrying a background refresh which failed
+const refreshRetryInterval = time.Minute
+
+// AuthorizationReason describes why the client authorized the account
+type AuthorizationReason string
+
+// Reasons for authorizing the account
+const (
+	AuthorizationRequested   AuthorizationReason = "requested"   // AuthorizeAccount was called
+	AuthorizationInitial     AuthorizationReason = "initial"     // The client had not yet been authorized
+	AuthorizationInvalidated AuthorizationReason = "invalidated" // The token was rejected by the service
+	AuthorizationExpired     AuthorizationReason = "expired"     // The token reached the end of its lifetime
+	AuthorizationRefresh     AuthorizationReason = "refresh"     // The token was close to expiry and was refreshed in the background
+	AuthorizationStored      AuthorizationReason = "stored"      // An unexpired token was loaded from the TokenStore
+)
+
+// AuthorizationEvent describes an authorization of the account by the client
+type AuthorizationEvent struct {
+	Reason AuthorizationReason
+
+	// When the new token was issued, and when it will expire
+	Issued  time.Time
+	Expires time.Time
+
+	// Set if the authorization failed
+	Err error
+}
+
+// Returns the time at which the authorization token expires
+func (a *authorizationState) expires() time.Time {
+	return a.issued.Add(tokenLifetime)
+}
+
+// Returns the margin before expiry at which tokens should be refreshed
+func (c *B2) refreshMargin() time.Duration {
+	switch {
+	case c.TokenRefreshMargin > 0:
+		return c.TokenRefreshMargin
+	case c.TokenRefreshMargin < 0:
+		return 0
+	default:
+		return DefaultTokenRefreshMargin
+	}
+}
+
+func (c *B2) now() time.Time {
+	if c.clock != nil {
+		return c.clock()
+	}
+	return time.Now()
+}
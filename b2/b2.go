@@ -22,6 +22,10 @@ type Options struct {
 
 	Debug   bool `short:"d" long:"debug" description:"Debug API requests"`
 	Verbose bool `short:"v" long:"verbose" description:"Display verbose output"`
+	NoCache bool `long:"noCache" env:"B2_NO_CACHE" description:"Don't reuse the account authorization saved by a previous run"`
+
+	FailFast bool `long:"fail-fast" description:"Stop processing files after the first failure"`
+	JSON     bool `long:"json" description:"Output one JSON object per line for each file, bucket or result, instead of text and progress bars"`
 }
 
 var opts = &Options{}
@@ -38,11 +42,27 @@ func main() {
 
 // Client obtains an instance of the B2 client
 func Client() (*backblaze.B2, error) {
-	c, err := backblaze.NewB2(backblaze.Credentials{
+	creds := backblaze.Credentials{
 		AccountID:      opts.AccountID,
 		KeyID:          opts.ApplicationID,
 		ApplicationKey: opts.ApplicationKey,
-	})
+	}
+
+	var store backblaze.TokenStore
+	if !opts.NoCache {
+		// Run without a cache if the cache directory can't be determined
+		if fileStore, err := backblaze.NewFileTokenStore(); err == nil {
+			store = fileStore
+		}
+	}
+
+	var c *backblaze.B2
+	var err error
+	if store != nil {
+		c, err = backblaze.NewB2WithTokenStore(creds, store)
+	} else {
+		c, err = backblaze.NewB2(creds)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// BucketCommand groups the bucket management commands
+type BucketCommand struct{}
+
+// BucketInfo is a command
+type BucketInfo struct{}
+
+// BucketUpdate is a command
+type BucketUpdate struct {
+	Type       string   `long:"type" choice:"allPublic" choice:"allPrivate" description:"Change whether files in the bucket are public"`
+	Info       []string `long:"info" description:"Set a bucket info value, as key=value"`
+	RemoveInfo []string `long:"removeInfo" description:"Remove a bucket info value"`
+}
+
+// LifecycleCommand groups the lifecycle rule commands
+type LifecycleCommand struct{}
+
+// LifecycleList is a command
+type LifecycleList struct{}
+
+// LifecycleAdd is a command
+type LifecycleAdd struct {
+	Prefix     string `long:"prefix" description:"Apply the rule to files whose names start with this prefix"`
+	HideDays   int    `long:"hideDays" description:"Hide files this many days after they are uploaded"`
+	DeleteDays int    `long:"deleteDays" description:"Delete files this many days after they are hidden"`
+}
+
+// LifecycleRemove is a command
+type LifecycleRemove struct {
+	Prefix string `long:"prefix" description:"Remove the rule for files whose names start with this prefix"`
+}
+
+func init() {
+	bucket, err := parser.AddCommand("bucket", "Manage a bucket",
+		"Shows or changes the settings of the bucket specified with -b. "+
+			"Changes are retried if the bucket is modified by another client at the same time.",
+		&BucketCommand{})
+	if err != nil {
+		panic(err)
+	}
+	bucket.AddCommand("info", "Show bucket settings", "", &BucketInfo{})
+	bucket.AddCommand("update", "Change bucket settings", "", &BucketUpdate{})
+
+	lifecycle, err := bucket.AddCommand("lifecycle", "Manage lifecycle rules",
+		"Lifecycle rules hide and delete old versions of the files whose names start with a prefix.",
+		&LifecycleCommand{})
+	if err != nil {
+		panic(err)
+	}
+	lifecycle.AddCommand("list", "List lifecycle rules", "", &LifecycleList{})
+	lifecycle.AddCommand("add", "Add or replace the lifecycle rule for a prefix", "", &LifecycleAdd{})
+	lifecycle.AddCommand("remove", "Remove the lifecycle rule for a prefix", "", &LifecycleRemove{})
+}
+
+// Execute the bucket info command
+func (o *BucketInfo) Execute(args []string) error {
+	bucket, err := openBucket()
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON {
+		printJSON(bucket.BucketInfo)
+		return nil
+	}
+
+	fmt.Printf("%-10s %s\n", "Name:", bucket.Name)
+	fmt.Printf("%-10s %s\n", "ID:", bucket.ID)
+	fmt.Printf("%-10s %s\n", "Type:", bucket.BucketType)
+	fmt.Printf("%-10s %d\n", "Revision:", bucket.Revision)
+
+	if len(bucket.Info) > 0 {
+		fmt.Println("Info:")
+		var keys []string
+		for key := range bucket.Info {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("  %s=%s\n", key, bucket.Info[key])
+		}
+	}
+
+	if len(bucket.LifecycleRules) > 0 {
+		fmt.Println("Lifecycle rules:")
+		printRules(bucket.LifecycleRules)
+	}
+	return nil
+}
+
+// Execute the bucket update command
+func (o *BucketUpdate) Execute(args []string) error {
+	if o.Type == "" && len(o.Info) == 0 && len(o.RemoveInfo) == 0 {
+		return errors.New("Specify --type, --info or --removeInfo")
+	}
+
+	set := make(map[string]string)
+	for _, value := range o.Info {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("Invalid bucket info %q, expected key=value", value)
+		}
+		set[parts[0]] = parts[1]
+	}
+
+	bucket, err := openBucket()
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Modify(func(info *backblaze.BucketInfo) error {
+		if o.Type != "" {
+			info.BucketType = backblaze.BucketType(o.Type)
+		}
+		if info.Info == nil {
+			info.Info = make(map[string]string)
+		}
+		for key, value := range set {
+			info.Info[key] = value
+		}
+		for _, key := range o.RemoveInfo {
+			delete(info.Info, key)
+		}
+		return nil
+	})
+	return updated(bucket, err)
+}
+
+// Execute the bucket lifecycle list command
+func (o *LifecycleList) Execute(args []string) error {
+	bucket, err := openBucket()
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON {
+		for _, rule := range bucket.LifecycleRules {
+			printJSON(rule)
+		}
+		return nil
+	}
+	printRules(bucket.LifecycleRules)
+	return nil
+}
+
+// Execute the bucket lifecycle add command
+func (o *LifecycleAdd) Execute(args []string) error {
+	if o.HideDays < 0 || o.DeleteDays < 0 {
+		return errors.New("--hideDays and --deleteDays must not be negative")
+	}
+	if o.HideDays == 0 && o.DeleteDays == 0 {
+		return errors.New("Specify --hideDays or --deleteDays")
+	}
+
+	bucket, err := openBucket()
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Modify(func(info *backblaze.BucketInfo) error {
+		rules := []backblaze.LifecycleRule{{
+			FileNamePrefix:            o.Prefix,
+			DaysFromUploadingToHiding: o.HideDays,
+			DaysFromHidingToDeleting:  o.DeleteDays,
+		}}
+		for _, rule := range info.LifecycleRules {
+			if rule.FileNamePrefix != o.Prefix {
+				rules = append(rules, rule)
+			}
+		}
+		sort.Slice(rules, func(i, j int) bool { return rules[i].FileNamePrefix < rules[j].FileNamePrefix })
+
+		info.LifecycleRules = rules
+		return nil
+	})
+	return updated(bucket, err)
+}
+
+// Execute the bucket lifecycle remove command
+func (o *LifecycleRemove) Execute(args []string) error {
+	bucket, err := openBucket()
+	if err != nil {
+		return err
+	}
+
+	err = bucket.Modify(func(info *backblaze.BucketInfo) error {
+		var rules []backblaze.LifecycleRule
+		for _, rule := range info.LifecycleRules {
+			if rule.FileNamePrefix != o.Prefix {
+				rules = append(rules, rule)
+			}
+		}
+		if len(rules) == len(info.LifecycleRules) {
+			return fmt.Errorf("No lifecycle rule for prefix %q", o.Prefix)
+		}
+
+		info.LifecycleRules = rules
+		return nil
+	})
+	return updated(bucket, err)
+}
+
+// openBucket looks up the bucket specified on the command line
+func openBucket() (*backblaze.Bucket, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("No bucket specified")
+	}
+
+	client, err := Client()
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, err := client.Bucket(opts.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if bucket == nil {
+		return nil, errors.New("Bucket not found: " + opts.Bucket)
+	}
+	return bucket, nil
+}
+
+// updated reports the result of updating a bucket
+func updated(bucket *backblaze.Bucket, err error) error {
+	if errors.Is(err, backblaze.ErrConflict) {
+		return fmt.Errorf("Bucket %s is being modified by another client, try again later", bucket.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.JSON {
+		printJSON(bucket.BucketInfo)
+	} else {
+		fmt.Println("Updated bucket:", bucket.Name)
+	}
+	return nil
+}
+
+func printRules(rules []backblaze.LifecycleRule) {
+	fmt.Printf("  %-30s %10s %12s\n", "Prefix", "Hide days", "Delete days")
+	for _, rule := range rules {
+		fmt.Printf("  %-30q %10d %12d\n", rule.FileNamePrefix, rule.DaysFromUploadingToHiding, rule.DaysFromHidingToDeleting)
+	}
+}
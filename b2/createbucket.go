@@ -32,7 +32,11 @@ func (o *CreateBucket) Execute(args []string) error {
 		return err
 	}
 
-	fmt.Println("Created bucket:", bucket.Name)
+	if opts.JSON {
+		printJSON(bucket.BucketInfo)
+	} else {
+		fmt.Println("Created bucket:", bucket.Name)
+	}
 
 	return nil
 }
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"gopkg.in/kothar/go-backblaze.v0"
 )
 
 // Delete is a command
@@ -15,7 +17,8 @@ type Delete struct {
 
 func init() {
 	parser.AddCommand("delete", "Delete a file",
-		"Specify just a filename to hide the file from listings. Specifiy a version id as fileName:versionId to permanently delete a file version.",
+		"Specify just a filename to hide the file from listings. Specifiy a version id as fileName:versionId to permanently delete a file version.\n\n"+
+			"If the text after the last colon is not a valid version id, it is treated as part of the filename, and the command fails unless a file with that name exists.",
 		&Delete{})
 }
 
@@ -37,16 +40,30 @@ func (o *Delete) Execute(args []string) error {
 	for _, file := range args {
 		// TODO handle wildcards
 
-		if opts.Verbose {
+		if opts.Verbose && !opts.JSON {
 			fmt.Println(file)
 		}
 
-		parts := strings.SplitN(file, ":", 2)
-		if len(parts) > 1 {
-			bucket.DeleteFileVersion(parts[0], parts[1])
+		name, id := splitVersion(file)
+		if id == "" && strings.Contains(file, ":") {
+			if err := checkFileExists(bucket, file); err != nil {
+				return err
+			}
+		}
+
+		if id != "" {
+			status, err := bucket.DeleteFileVersion(name, id)
+			if err != nil {
+				return err
+			}
+			printStatus(status)
 		} else {
 			if o.Hide {
-				bucket.HideFile(file)
+				status, err := bucket.HideFile(file)
+				if err != nil {
+					return err
+				}
+				printStatus(status)
 			} else {
 				// Get most recent versions
 				count := 1
@@ -65,13 +82,15 @@ func (o *Delete) Execute(args []string) error {
 						break
 					}
 
-					if opts.Verbose && o.All {
+					if opts.Verbose && o.All && !opts.JSON {
 						fmt.Printf("  %s %v\n", f.ID, time.Unix(f.UploadTimestamp/1000, f.UploadTimestamp%1000))
 					}
 
-					if _, err := bucket.DeleteFileVersion(file, f.ID); err != nil {
+					status, err := bucket.DeleteFileVersion(file, f.ID)
+					if err != nil {
 						return err
 					}
+					printStatus(status)
 					count++
 				}
 				if count == 0 {
@@ -83,3 +102,23 @@ func (o *Delete) Execute(args []string) error {
 
 	return nil
 }
+
+// checkFileExists returns an error if no version of a file exists, so that a
+// mistyped version ID doesn't hide or delete a file with a different name
+func checkFileExists(bucket *backblaze.Bucket, name string) error {
+	versions, err := bucket.ListFileVersions(name, "", 1)
+	if err != nil {
+		return err
+	}
+	if len(versions.Files) == 0 || versions.Files[0].Name != name {
+		return fmt.Errorf("File not found: %s (the text after the last colon is not a valid version id)", name)
+	}
+	return nil
+}
+
+// printStatus prints the file affected by a deletion when JSON output is enabled
+func printStatus(status *backblaze.FileStatus) {
+	if opts.JSON {
+		printJSON(statusFile(status))
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/kothar/go-backblaze.v0/b2test"
+)
+
+func TestCheckFileExists(T *testing.T) {
+	server := b2test.NewServer()
+	defer server.Close()
+	bucket := server.NewBucket(T, "delete-bucket")
+
+	for _, name := range []string{"a.txt", "backup:2024", "hidden:old"} {
+		if _, err := bucket.UploadFile(name, nil, strings.NewReader(name)); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if _, err := bucket.HideFile("hidden:old"); err != nil {
+		T.Fatal(err)
+	}
+
+	// Names with colons which aren't followed by a version ID must exist, so
+	// that a mistyped ID doesn't delete the latest version of another file
+	for name, exists := range map[string]bool{
+		"backup:2024":  true,
+		"hidden:old":   true,
+		"a.txt:4_zbad": false,
+		"backup:":      false,
+	} {
+		if err := checkFileExists(bucket, name); (err == nil) != exists {
+			T.Errorf("Expected %s to exist %v, saw %v", name, exists, err)
+		}
+	}
+}
@@ -35,7 +35,11 @@ func (o *DeleteBucket) Execute(args []string) error {
 		return err
 	}
 
-	fmt.Println("Deleted bucket:", bucket.Name)
+	if opts.JSON {
+		printJSON(bucket.BucketInfo)
+	} else {
+		fmt.Println("Deleted bucket:", bucket.Name)
+	}
 
 	return nil
 }
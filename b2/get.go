@@ -8,36 +8,53 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
-	"sync"
+	"regexp"
+	"strings"
 	"time"
 
-	"github.com/dustin/go-humanize"
-	"github.com/gosuri/uiprogress"
-	"github.com/gosuri/uiprogress/util/strutil"
-
 	"gopkg.in/kothar/go-backblaze.v0"
 )
 
-// TODO support subdirectories
-// TODO support version id downloads
-
 // Get is a command
 type Get struct {
 	Threads     int    `short:"j" long:"threads" default:"5" description:"Maximum simultaneous downloads to process"`
 	Output      string `short:"o" long:"output" default:"." description:"Output file name or directory"`
 	Discard     bool   `long:"discard" description:"Discard downloaded data"`
 	NoReadahead bool   `long:"noreadahead" description:"Disable parallel readahead for large files"`
+	Recursive   bool   `short:"r" long:"recursive" description:"Download all files whose names start with each argument"`
+	Force       bool   `long:"force" description:"Download files even if an identical local copy exists"`
+	At          string `long:"at" description:"Download the versions of files which were current at a time, given as RFC 3339 or YYYY-MM-DD [HH:MM:SS] in local time"`
 }
 
 func init() {
 	parser.AddCommand("get", "Download a file",
-		"Downloads one or more files to the current directory. Specify the bucket with -b, and the filenames to download as extra arguments.",
+		"Downloads one or more files to the current directory. Specify the bucket with -b, and the filenames to download as extra arguments.\n\n"+
+			"Filenames may contain the wildcards *, ? and [...], which do not match /. With -r, all files starting with each argument are downloaded. "+
+			"Directories in file names are recreated under the output directory.\n\n"+
+			"Specify a version of a file as fileName:versionId, or use --at to download the versions which were current at a point in time.",
 		&Get{})
 }
 
+// A file to be downloaded
+type getTask struct {
+	name string
+	id   string          // Set to download a specific version of the file
+	file *backblaze.File // Set if the file was found by listing the bucket
+	err  error           // Set if the file could not be found
+}
+
 // Execute the get command
 func (o *Get) Execute(args []string) error {
+	var at time.Time
+	if o.At != "" {
+		var err error
+		if at, err = parseTime(o.At); err != nil {
+			return err
+		}
+	}
+
 	client, err := Client()
 	if err != nil {
 		return err
@@ -51,9 +68,52 @@ func (o *Get) Execute(args []string) error {
 		return errors.New("Bucket not found: " + opts.Bucket)
 	}
 
-	uiprogress.Start()
-	tasks := make(chan string, o.Threads)
-	group := sync.WaitGroup{}
+	// Expand prefixes and wildcards
+	var files []getTask
+	multiple := len(args) > 1
+	for _, arg := range args {
+		if name, id := splitVersion(arg); id != "" {
+			files = append(files, getTask{name: name, id: id})
+			continue
+		}
+
+		switch {
+		case o.Recursive:
+			multiple = true
+			listed, err := listFilesAt(bucket, arg, at)
+			if err != nil {
+				return err
+			}
+			files = append(files, listed...)
+		case isPattern(arg):
+			multiple = true
+			matched, err := matchFiles(bucket, arg, at)
+			if err != nil {
+				return err
+			}
+			if len(matched) == 0 {
+				files = append(files, getTask{name: arg, err: errors.New("No files match " + arg)})
+			}
+			files = append(files, matched...)
+		case !at.IsZero():
+			listed, err := listFilesAt(bucket, arg, at)
+			if err != nil {
+				return err
+			}
+			found := false
+			for _, task := range listed {
+				if task.name == arg {
+					files = append(files, task)
+					found = true
+				}
+			}
+			if !found {
+				files = append(files, getTask{name: arg, err: fmt.Errorf("No version of %s existed at %v", arg, at)})
+			}
+		default:
+			files = append(files, getTask{name: arg})
+		}
+	}
 
 	outDir := "."
 	outName := ""
@@ -62,7 +122,7 @@ func (o *Get) Execute(args []string) error {
 	if err == nil {
 		if info.IsDir() {
 			outDir = o.Output
-		} else if len(args) > 1 {
+		} else if multiple {
 			return errors.New("Single (existing) output file specified for multiple targets: " + o.Output)
 		} else {
 			outName = o.Output
@@ -73,7 +133,7 @@ func (o *Get) Execute(args []string) error {
 		if os.IsNotExist(err) || !info.IsDir() {
 			return errors.New("Directory does not exist: " + parent)
 		}
-		if len(args) > 1 {
+		if multiple {
 			outDir = o.Output
 		} else {
 			outName = o.Output
@@ -82,74 +142,230 @@ func (o *Get) Execute(args []string) error {
 		return err
 	}
 
-	// Create workers
-	for i := 0; i < o.Threads; i++ {
-		group.Add(1)
-		go func() {
-			for file := range tasks {
+	tasks := make([]task, len(files))
+	for i, file := range files {
+		file := file
+		tasks[i] = task{
+			name: file.name,
+			run:  func() (*backblaze.File, error) { return o.get(client, bucket, file, outDir, outName) },
+		}
+	}
 
-				var (
-					fileInfo *backblaze.File
-					reader   io.ReadCloser
-					err      error
-				)
+	startProgress()
+	return reportResults(runTasks(tasks, o.Threads))
+}
 
-				if o.NoReadahead {
-					fileInfo, reader, err = bucket.DownloadFileByName(file)
-				} else {
-					fileInfo, reader, err = bucket.ReadaheadFileByName(file)
-				}
-				if err != nil {
-					fmt.Println(err)
-					// TODO terminate on errors
-				}
+// Downloads a single file
+func (o *Get) get(client *backblaze.B2, bucket *backblaze.Bucket, task getTask, outDir, outName string) (*backblaze.File, error) {
+	if task.err != nil {
+		return nil, task.err
+	}
 
-				name := file
-				if outName != "" {
-					name = outName
-				}
-				path := filepath.Join(outDir, name)
-				err = download(fileInfo, reader, path, o)
-				if err != nil {
-					fmt.Println(err)
-					// TODO remove file if partially downloaded?
-				}
+	file := task.name
 
-				// TODO handle termination on error
-			}
-			group.Done()
-		}()
+	path := outName
+	if path == "" {
+		var err error
+		if path, err = localPath(outDir, file); err != nil {
+			return nil, err
+		}
+	}
 
+	if !o.Discard && !o.Force {
+		same, err := sameLocalFile(bucket, task, path)
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			return task.file, errSkipped
+		}
 	}
 
-	for _, file := range args {
-		// TODO handle wildcards
+	var (
+		fileInfo *backblaze.File
+		reader   io.ReadCloser
+		err      error
+	)
+
+	progress := progressBar(file)
+	switch {
+	case task.id != "" && task.file != nil && !o.NoReadahead:
+		fileInfo = task.file
+		reader, err = client.ReadaheadFileWithProgress(task.file, progress)
+	case task.id != "":
+		fileInfo, reader, err = client.DownloadFileRangeByIDWithProgress(task.id, nil, progress)
+	case o.NoReadahead:
+		fileInfo, reader, err = bucket.DownloadFileRangeByNameWithProgress(file, nil, progress)
+	default:
+		fileInfo, reader, err = bucket.ReadaheadFileByNameWithProgress(file, progress)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		tasks <- file
+	err = download(fileInfo, reader, path, o.Discard)
+	if err != nil && !o.Discard {
+		// Don't leave a partially downloaded file
+		os.Remove(path)
 	}
-	close(tasks)
+	return fileInfo, err
+}
 
-	group.Wait()
+// The format of B2 file IDs, such as
+// 4_z27c88f1d182b150646ff0b16_f1004ba650fe24e6b_d20150809_m012853_c100_v0009990_t0000
+var fileIDPattern = regexp.MustCompile(`^[0-9]+_z[0-9a-fA-F]+_f[0-9a-zA-Z_]+$`)
 
-	return nil
+// splitVersion splits an argument given as fileName:versionId. The ID is
+// only split off if it looks like a file ID, so that file names containing
+// colons can still be given alone.
+func splitVersion(arg string) (name, id string) {
+	if i := strings.LastIndex(arg, ":"); i >= 0 && fileIDPattern.MatchString(arg[i+1:]) {
+		return arg[:i], arg[i+1:]
+	}
+	return arg, ""
 }
 
-type progressWriter struct {
-	bar *uiprogress.Bar
-	w   io.Writer
+// isPattern returns true if a file name contains wildcards
+func isPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
 }
 
-func (p *progressWriter) Write(b []byte) (int, error) {
-	written, err := p.w.Write(b)
-	p.bar.Set(p.bar.Current() + written)
-	return written, err
+// matchFiles returns the files in the bucket matching a pattern, listing
+// only the files which share the pattern's literal prefix
+func matchFiles(bucket *backblaze.Bucket, pattern string, at time.Time) ([]getTask, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Invalid pattern %q: %v", pattern, err)
+	}
+
+	prefix := pattern[:strings.IndexAny(pattern, "*?[")]
+	listed, err := listFilesAt(bucket, prefix, at)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []getTask
+	for _, task := range listed {
+		if ok, _ := path.Match(pattern, task.name); ok {
+			matched = append(matched, task)
+		}
+	}
+	return matched, nil
 }
 
-func download(fileInfo *backblaze.File, reader io.ReadCloser, path string, o *Get) error {
+// listFilesAt returns the files with a prefix. If at is set, the versions
+// which were current at that time are returned.
+func listFilesAt(bucket *backblaze.Bucket, prefix string, at time.Time) ([]getTask, error) {
+	var tasks []getTask
+	if at.IsZero() {
+		listed, err := listFiles(bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for i := range listed {
+			tasks = append(tasks, getTask{name: listed[i].Name, file: &listed[i].File})
+		}
+		return tasks, nil
+	}
+
+	versions, err := listVersions(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// Versions are listed newest first, so the first version of each file
+	// uploaded before the time was current at that time
+	atMillis := timeMillis(at)
+	var found string
+	for i := range versions {
+		file := &versions[i].File
+		if file.Name == found || file.UploadTimestamp > atMillis {
+			continue
+		}
+		switch file.Action {
+		case backblaze.Upload:
+			tasks = append(tasks, getTask{name: file.Name, id: file.ID, file: file})
+			found = file.Name
+		case backblaze.Hide:
+			// The file was hidden at the time
+			found = file.Name
+		}
+	}
+	return tasks, nil
+}
+
+// parseTime parses a time given on the command line
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Unrecognised time %q, expected RFC 3339 or YYYY-MM-DD [HH:MM:SS]", value)
+}
+
+// sameLocalFile returns true if a local file exists with the same contents
+// as the file to be downloaded
+func sameLocalFile(bucket *backblaze.Bucket, task getTask, path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false, nil
+	}
+
+	file := task.file
+	if file == nil && task.id != "" {
+		// Look up the version, only if a local copy exists
+		if file, err = bucket.GetFileInfo(task.id); err != nil {
+			return false, err
+		}
+	}
+	if file == nil {
+		// Look up the file, only if a local copy exists
+		response, err := bucket.ListFileNamesWithPrefix(task.name, 1, task.name, "")
+		if err != nil {
+			return false, err
+		}
+		if len(response.Files) == 0 || response.Files[0].Name != task.name {
+			return false, nil
+		}
+		file = &response.Files[0].File
+	}
+
+	remoteSHA1 := file.SHA1()
+	if remoteSHA1 == "" || info.Size() != file.ContentLength {
+		return false, nil
+	}
+
+	localSHA1, err := hashFile(path)
+	if err != nil {
+		return false, err
+	}
+	return localSHA1 == remoteSHA1, nil
+}
+
+// localPath returns the path of a downloaded file below a directory. File
+// names come from the bucket, so names which are absolute or would leave the
+// directory are rejected.
+func localPath(dir, name string) (string, error) {
+	native := filepath.FromSlash(name)
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(native) || filepath.VolumeName(native) != "" {
+		return "", fmt.Errorf("Refusing to download %q outside %s", name, dir)
+	}
+	path := filepath.Join(dir, native)
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("Refusing to download %q outside %s", name, dir)
+	}
+	return path, nil
+}
+
+func download(fileInfo *backblaze.File, reader io.ReadCloser, path string, discard bool) error {
 	defer reader.Close()
 
 	var writer = ioutil.Discard
-	if !o.Discard {
+	if !discard {
 		err := os.MkdirAll(filepath.Dir(path), 0777)
 		if err != nil {
 			return err
@@ -163,35 +379,6 @@ func download(fileInfo *backblaze.File, reader io.ReadCloser, path string, o *Ge
 		writer = file
 	}
 
-	if opts.Verbose {
-		bar := uiprogress.AddBar(int(fileInfo.ContentLength))
-
-		if fileInfo.ContentLength > 1024*100 {
-			start := time.Now()
-			elapsed := time.Duration(1)
-			count := 0
-			bar.AppendFunc(func(b *uiprogress.Bar) string {
-				count++
-				if count < 2 {
-					return ""
-				}
-
-				// elapsed := b.TimeElapsed()
-				if b.Current() < b.Total {
-					elapsed = time.Now().Sub(start)
-				}
-				speed := uint64(float64(b.Current()) / elapsed.Seconds())
-				return humanize.IBytes(speed) + "/sec"
-			})
-		}
-		bar.AppendCompleted()
-		bar.PrependFunc(func(b *uiprogress.Bar) string { return fmt.Sprintf("%10s", humanize.IBytes(uint64(b.Total))) })
-		bar.PrependFunc(func(b *uiprogress.Bar) string { return strutil.Resize(fileInfo.Name, 50) })
-		bar.Width = 20
-
-		writer = &progressWriter{bar, writer}
-	}
-
 	sha := sha1.New()
 	tee := io.MultiWriter(sha, writer)
 
@@ -202,8 +389,8 @@ func download(fileInfo *backblaze.File, reader io.ReadCloser, path string, o *Ge
 
 	// Check SHA
 	sha1Hash := hex.EncodeToString(sha.Sum(nil))
-	if sha1Hash != fileInfo.ContentSha1 {
-		return errors.New("Downloaded data does not match SHA1 hash")
+	if expected := fileInfo.SHA1(); expected != "" && sha1Hash != expected {
+		return fmt.Errorf("Downloaded data does not match SHA1 hash: %w", backblaze.ErrChecksumMismatch)
 	}
 
 	return nil
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalPath(T *testing.T) {
+	dir := filepath.Join("out", "dir")
+	valid := map[string]string{
+		"a.txt":         filepath.Join(dir, "a.txt"),
+		"docs/a.txt":    filepath.Join(dir, "docs", "a.txt"),
+		"docs/../a.txt": filepath.Join(dir, "a.txt"),
+		"..a/b":         filepath.Join(dir, "..a", "b"),
+	}
+	for name, expected := range valid {
+		if path, err := localPath(dir, name); err != nil || path != expected {
+			T.Errorf("Expected %s for %q, saw %s %v", expected, name, path, err)
+		}
+	}
+
+	for _, name := range []string{"../a.txt", "../../.bashrc", "docs/../../a.txt", "/etc/passwd", "..", "", "."} {
+		if path, err := localPath(dir, name); err == nil {
+			T.Errorf("Expected %q to be rejected, saw %s", name, path)
+		}
+	}
+}
+
+func TestSplitVersion(T *testing.T) {
+	id := "4_z27c88f1d182b150646ff0b16_f1004ba650fe24e6b_d20150809_m012853_c100_v0009990_t0000"
+	cases := []struct {
+		arg, name, id string
+	}{
+		{"a.txt", "a.txt", ""},
+		{"a.txt:" + id, "a.txt", id},
+		{"dir/a:b.txt:" + id, "dir/a:b.txt", id},
+		{"backup-2024-01-01T10:00.tar", "backup-2024-01-01T10:00.tar", ""},
+		{"a.txt:", "a.txt:", ""},
+		{"a.txt:4_zbad", "a.txt:4_zbad", ""},
+	}
+	for _, c := range cases {
+		if name, id := splitVersion(c.arg); name != c.name || id != c.id {
+			T.Errorf("Expected %q and %q for %q, saw %q and %q", c.name, c.id, c.arg, name, id)
+		}
+	}
+}
@@ -3,20 +3,41 @@ package main
 import (
 	"errors"
 	"fmt"
-	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"gopkg.in/kothar/go-backblaze.v0"
 )
 
 // List is a command
 type List struct {
-	ListVersions bool `short:"a" long:"allVersions" description:"List all versions of files"`
+	ListVersions bool   `short:"a" long:"allVersions" description:"List all versions of files"`
+	Long         bool   `short:"l" long:"long" description:"Show the action, upload time, size, content type, SHA1 and ID of each file"`
+	Folders      bool   `short:"f" long:"folders" description:"List only the files and folders directly below the prefix"`
+	Delimiter    string `long:"delimiter" description:"Character separating folders in file names, implies --folders (default: /)"`
 }
 
 func init() {
-	parser.AddCommand("list", "List files in a bucket", "", &List{})
+	parser.AddCommand("list", "List files in a bucket",
+		"Lists the files in a bucket, optionally starting with a prefix: b2 list [prefix]\n\n"+
+			"With --folders, files in folders below the prefix are not listed, and the folders are shown with a trailing delimiter instead.",
+		&List{})
 }
 
 // Execute the list command
 func (o *List) Execute(args []string) error {
+	if len(args) > 1 {
+		return errors.New("Specify at most one prefix")
+	}
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+	delimiter := o.Delimiter
+	if delimiter == "" && o.Folders {
+		delimiter = "/"
+	}
+
 	client, err := Client()
 	if err != nil {
 		return err
@@ -30,39 +51,64 @@ func (o *List) Execute(args []string) error {
 		return errors.New("Bucket not found: " + opts.Bucket)
 	}
 
-	if o.ListVersions {
-		response, err := bucket.ListFileVersions("", "", 100)
-		if err != nil {
-			return err
-		}
+	if opts.Verbose && !opts.JSON {
+		fmt.Printf("Contents of %s/%s\n", opts.Bucket, prefix)
+	}
 
-		if opts.Verbose {
-			fmt.Printf("Contents of %s/\n", opts.Bucket)
-			for _, file := range response.Files {
-				fmt.Printf("%s\n%10d %s %-20s\n\n", file.ID, file.Size, time.Unix(file.UploadTimestamp/1000, file.UploadTimestamp%1000), file.Name)
+	if o.ListVersions {
+		startName, startID := "", ""
+		for {
+			response, err := bucket.ListFileVersionsWithPrefix(startName, startID, 1000, prefix, delimiter)
+			if err != nil {
+				return err
 			}
-		} else {
-			for _, file := range response.Files {
-				fmt.Println(file.Name + ":" + file.ID)
+			o.printFiles(response.Files)
+
+			if response.NextFileName == "" {
+				return nil
 			}
+			startName, startID = response.NextFileName, response.NextFileID
 		}
-	} else {
-		response, err := bucket.ListFileNames("", 100)
+	}
+
+	startName := ""
+	for {
+		response, err := bucket.ListFileNamesWithPrefix(startName, 1000, prefix, delimiter)
 		if err != nil {
 			return err
 		}
+		o.printFiles(response.Files)
 
-		if opts.Verbose {
-			fmt.Printf("Contents of %s/\n", opts.Bucket)
-			for _, file := range response.Files {
-				fmt.Printf("%10d %s %-20s\n", file.Size, time.Unix(file.UploadTimestamp/1000, file.UploadTimestamp%1000), file.Name)
-			}
-		} else {
-			for _, file := range response.Files {
-				fmt.Println(file.Name)
-			}
+		if response.NextFileName == "" {
+			return nil
 		}
+		startName = response.NextFileName
 	}
+}
+
+// Prints a page of listed files
+func (o *List) printFiles(files []backblaze.FileStatus) {
+	for i := range files {
+		file := &files[i].File
 
-	return nil
+		switch {
+		case opts.JSON:
+			printJSON(file)
+		case (o.Long || opts.Verbose) && file.Action == backblaze.Folder:
+			fmt.Printf("%-6s %19s %8s %-24s %-40s %-40s %s\n", file.Action, "", "", "", "", "", file.Name)
+		case o.Long || opts.Verbose:
+			fmt.Printf("%-6s %19s %8s %-24s %-40s %-40s %s\n",
+				file.Action,
+				millisTime(file.UploadTimestamp).Format("2006-01-02 15:04:05"),
+				humanize.Bytes(uint64(file.ContentLength)),
+				file.ContentType,
+				file.SHA1(),
+				file.ID,
+				file.Name)
+		case o.ListVersions && file.Action != backblaze.Folder:
+			fmt.Println(file.Name + ":" + file.ID)
+		default:
+			fmt.Println(file.Name)
+		}
+	}
 }
@@ -24,7 +24,11 @@ func (o *ListBuckets) Execute(args []string) error {
 		return err
 	}
 
-	if opts.Verbose {
+	if opts.JSON {
+		for _, bucket := range response {
+			printJSON(bucket.BucketInfo)
+		}
+	} else if opts.Verbose {
 		fmt.Printf("%-30s%-35s%-15s\n", "Name", "Id", "Type")
 		for _, bucket := range response {
 			fmt.Printf("%-30s%-35s%-15s\n", bucket.Name, bucket.ID, bucket.BucketType)
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// Serialises JSON output from concurrent tasks
+var jsonMutex sync.Mutex
+
+// printJSON writes a value to standard output as a single line of JSON
+func printJSON(v interface{}) {
+	jsonMutex.Lock()
+	defer jsonMutex.Unlock()
+
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// statusFile returns the file described by a FileStatus, which may be nil
+func statusFile(status *backblaze.FileStatus) *backblaze.File {
+	if status == nil {
+		return nil
+	}
+	return &status.File
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/gosuri/uiprogress"
+	"github.com/gosuri/uiprogress/util/strutil"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// Set once progress bars are being displayed
+var progressStarted bool
+
+// startProgress starts displaying progress bars, unless JSON output is enabled
+func startProgress() {
+	if !opts.JSON {
+		uiprogress.Start()
+		progressStarted = true
+	}
+}
+
+// stopProgress stops displaying progress bars, if they were started
+func stopProgress() {
+	if progressStarted {
+		uiprogress.Stop()
+		progressStarted = false
+	}
+}
+
+// progressBar returns a function which displays the progress of a transfer.
+// The bar is created on the first update, once the size of the transfer is known.
+// If verbose output is disabled, or JSON output is enabled, nil is returned.
+func progressBar(name string) backblaze.ProgressFunc {
+	if !opts.Verbose || opts.JSON {
+		return nil
+	}
+
+	var bar *uiprogress.Bar
+	return func(p backblaze.Progress) {
+		if bar == nil {
+			bar = addBar(name, p.Total)
+		}
+		bar.Set(int(p.Transferred))
+	}
+}
+
+func addBar(name string, total int64) *uiprogress.Bar {
+	bar := uiprogress.AddBar(int(total))
+	// TODO Stop bar refresh when complete
+
+	if total > 1024*100 {
+		start := time.Now()
+		elapsed := time.Duration(1)
+		count := 0
+		bar.AppendFunc(func(b *uiprogress.Bar) string {
+			count++
+			if count < 2 {
+				return ""
+			}
+
+			// elapsed := b.TimeElapsed()
+			if b.Current() < b.Total {
+				elapsed = time.Now().Sub(start)
+			}
+			speed := uint64(float64(b.Current()) / elapsed.Seconds())
+			return humanize.IBytes(speed) + "/sec"
+		})
+	}
+	bar.AppendCompleted()
+	bar.PrependFunc(func(b *uiprogress.Bar) string { return fmt.Sprintf("%10s", humanize.IBytes(uint64(b.Total))) })
+	bar.PrependFunc(func(b *uiprogress.Bar) string { return strutil.Resize(name, 50) })
+	bar.Width = 20
+
+	return bar
+}
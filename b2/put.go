@@ -3,36 +3,55 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
-	"sync"
-	"time"
+	"strconv"
 
 	"github.com/dustin/go-humanize"
-	"github.com/gosuri/uiprogress"
-	"github.com/gosuri/uiprogress/util/strutil"
 
 	"gopkg.in/kothar/go-backblaze.v0"
 )
 
-// TODO support directories
 // TODO support replacing all previous versions
 
 // Put is a command
 type Put struct {
 	Threads int               `short:"j" long:"threads" default:"5" description:"Maximum simultaneous uploads to process"`
 	Meta    map[string]string `long:"meta" description:"Assign metadata to uploaded files"`
+
+	Recursive      bool     `short:"r" long:"recursive" description:"Upload the contents of a directory. Specify the directory and an optional prefix for the uploaded file names"`
+	Include        []string `long:"include" description:"Only upload files in the directory matching a glob pattern"`
+	Exclude        []string `long:"exclude" description:"Don't upload files in the directory matching a glob pattern"`
+	FollowSymlinks bool     `long:"followSymlinks" description:"Follow symbolic links in the directory instead of skipping them"`
+
+	uploadOptions
+}
+
+// uploadOptions configure how files are uploaded
+type uploadOptions struct {
+	LargeFileSize string `long:"largeFileSize" default:"200MB" description:"Upload files of at least this size in parts"`
+	PartSize      string `long:"partSize" description:"Size of each part of a large file (defaults to the size recommended by B2)"`
+	PartThreads   int    `long:"partThreads" default:"4" description:"Maximum simultaneous part uploads for each large file"`
 }
 
 func init() {
 	parser.AddCommand("put", "Store a file",
-		"Uploads one or more files. Specify the bucket with -b, and the filenames to upload as extra arguments.",
+		"Uploads one or more files. Specify the bucket with -b, and the filenames to upload as extra arguments.\n\n"+
+			"With -r, uploads the files in a directory: b2 put -r localdir [remote/prefix]",
 		&Put{})
 }
 
 // Execute the put command
 func (o *Put) Execute(args []string) error {
+	if o.Recursive && (len(args) < 1 || len(args) > 2) {
+		return errors.New("Specify a directory to upload, and optionally a prefix for file names")
+	}
+
+	uploader, err := o.uploader()
+	if err != nil {
+		return err
+	}
+
 	client, err := Client()
 	if err != nil {
 		return err
@@ -46,102 +65,113 @@ func (o *Put) Execute(args []string) error {
 		return errors.New("Bucket not found: " + opts.Bucket)
 	}
 
-	uiprogress.Start()
-	tasks := make(chan string, o.Threads)
-	group := sync.WaitGroup{}
-
-	// Create workers
-	for i := 0; i < o.Threads; i++ {
-		group.Add(1)
-		go func() {
-			for file := range tasks {
-				_, err := upload(bucket, file, o.Meta)
-				if err != nil {
-					fmt.Println(err)
-				}
-
-				// TODO handle termination on error
-			}
-			group.Done()
-		}()
+	var tasks []task
+	add := func(upload uploadTask) {
+		tasks = append(tasks, task{
+			name: upload.path,
+			run: func() (*backblaze.File, error) {
+				return uploader.upload(bucket, upload, o.Meta)
+			},
+		})
 	}
 
-	for _, file := range args {
-		tasks <- file
-	}
-	close(tasks)
+	if o.Recursive {
+		prefix := ""
+		if len(args) > 1 {
+			prefix = args[1]
+		}
+		filter := fileFilter{Include: o.Include, Exclude: o.Exclude}
 
-	group.Wait()
+		err = walkDir(args[0], o.FollowSymlinks, func(file localFile) error {
+			if filter.Match(file.Name) {
+				add(uploadTask{path: file.Path, name: joinName(prefix, file.Name), info: file.Info})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, file := range args {
+			info, err := os.Stat(file)
+			if err != nil {
+				tasks = append(tasks, task{name: file, run: func() (*backblaze.File, error) { return nil, err }})
+				continue
+			}
+			add(uploadTask{path: file, name: filepath.Base(file), info: info})
+		}
+	}
 
-	return nil
+	startProgress()
+	return reportResults(runTasks(tasks, o.Threads))
 }
 
-type progressReader struct {
-	bar *uiprogress.Bar
-	r   io.ReadSeeker
+// A local file to be uploaded
+type uploadTask struct {
+	path string
+	name string
+	info os.FileInfo
 }
 
-func (p *progressReader) Read(b []byte) (int, error) {
-	read, err := p.r.Read(b)
-	p.bar.Set(p.bar.Current() + read)
-	return read, err
+// uploader uploads files, using the large file API for files above a threshold
+type uploader struct {
+	largeFileSize int64
+	partSize      int64
+	partThreads   int
 }
 
-func (p *progressReader) Seek(offset int64, whence int) (int64, error) {
-	switch whence {
-	case 0:
-		p.bar.Set(int(offset))
-	case 1:
-		p.bar.Set(p.bar.Current() + int(offset))
-	case 2:
-		p.bar.Set(p.bar.Total - int(offset))
+func (o *uploadOptions) uploader() (*uploader, error) {
+	largeFileSize, err := humanize.ParseBytes(o.LargeFileSize)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid large file size: %v", err)
+	}
+	var partSize uint64
+	if o.PartSize != "" {
+		if partSize, err = humanize.ParseBytes(o.PartSize); err != nil {
+			return nil, fmt.Errorf("Invalid part size: %v", err)
+		}
 	}
-	return p.r.Seek(offset, whence)
+
+	return &uploader{
+		largeFileSize: int64(largeFileSize),
+		partSize:      int64(partSize),
+		partThreads:   o.PartThreads,
+	}, nil
 }
 
-func upload(bucket *backblaze.Bucket, file string, meta map[string]string) (*backblaze.File, error) {
+// upload stores a file, recording its modification time in the file info
+func (u *uploader) upload(bucket *backblaze.Bucket, file uploadTask, meta map[string]string) (*backblaze.File, error) {
 
-	stat, err := os.Stat(file)
-	if err != nil {
-		return nil, err
+	fileMeta := map[string]string{
+		lastModifiedKey: strconv.FormatInt(timeMillis(file.info.ModTime()), 10),
+	}
+	for k, v := range meta {
+		fileMeta[k] = v
 	}
 
-	reader, err := os.Open(file)
+	reader, err := os.Open(file.path)
 	if err != nil {
 		return nil, err
 	}
 	defer reader.Close()
 
-	var r io.Reader = reader
-	if opts.Verbose {
-		bar := uiprogress.AddBar(int(stat.Size()))
-		// TODO Stop bar refresh when complete
-
-		if stat.Size() > 1024*100 {
-			start := time.Now()
-			elapsed := time.Duration(1)
-			count := 0
-			bar.AppendFunc(func(b *uiprogress.Bar) string {
-				count++
-				if count < 2 {
-					return ""
-				}
-
-				// elapsed := b.TimeElapsed()
-				if b.Current() < b.Total {
-					elapsed = time.Now().Sub(start)
-				}
-				speed := uint64(float64(b.Current()) / elapsed.Seconds())
-				return humanize.IBytes(speed) + "/sec"
-			})
+	progress := progressBar(file.path)
+	if size := file.info.Size(); size >= u.largeFileSize {
+		// B2 only records the hashes of the parts of large files, so the hash
+		// of the whole file is stored for comparisons with local files
+		sha1, err := hashFile(file.path)
+		if err != nil {
+			return nil, err
 		}
-		bar.AppendCompleted()
-		bar.PrependFunc(func(b *uiprogress.Bar) string { return fmt.Sprintf("%10s", humanize.IBytes(uint64(b.Total))) })
-		bar.PrependFunc(func(b *uiprogress.Bar) string { return strutil.Resize(file, 50) })
-		bar.Width = 20
+		fileMeta[largeFileSHA1Key] = sha1
 
-		r = &progressReader{bar, reader}
-	}
+		uploaded, err := bucket.UploadLargeFileOptionsWithProgress(file.name, "b2/x-auto", fileMeta, reader, size, u.partSize, u.partThreads, progress)
+		if !errors.Is(err, backblaze.ErrLargeFileTooSmall) {
+			return uploaded, err
+		}
 
-	return bucket.UploadFile(filepath.Base(file), meta, r)
+		// Files which can't be split into parts are uploaded whole
+		delete(fileMeta, largeFileSHA1Key)
+	}
+	return bucket.UploadTypedFileWithProgress(file.name, "b2/x-auto", fileMeta, reader, progress)
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/kothar/go-backblaze.v0/b2test"
+)
+
+func TestUploadLargeFileSHA1(T *testing.T) {
+	server := b2test.NewUnstartedServer()
+	server.AbsoluteMinimumPartSize = 5
+	server.Start()
+	defer server.Close()
+	bucket := server.NewBucket(T, "put-bucket")
+
+	dir, err := ioutil.TempDir("", "b2put")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u := &uploader{largeFileSize: 5, partSize: 5, partThreads: 2}
+	for name, content := range map[string][]byte{
+		"large.bin": bytes.Repeat([]byte("0123456789"), 3),
+		"small.bin": []byte("01234"),
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			T.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			T.Fatal(err)
+		}
+		expected, err := hashFile(path)
+		if err != nil {
+			T.Fatal(err)
+		}
+
+		// Large files record their hash in the file info, and files too small
+		// to split into parts are hashed by B2
+		if _, err := u.upload(bucket, uploadTask{path: path, name: name, info: info}, nil); err != nil {
+			T.Fatal(err)
+		}
+		files, err := listFiles(bucket, name)
+		if err != nil || len(files) != 1 {
+			T.Fatalf("Expected to list %s, saw %v %v", name, files, err)
+		}
+		if sha1 := files[0].SHA1(); sha1 != expected {
+			T.Errorf("Expected SHA1 %s for %s, saw %q", expected, name, sha1)
+		}
+		if _, ok := files[0].FileInfo[largeFileSHA1Key]; ok != (name == "large.bin") {
+			T.Errorf("Unexpected file info for %s %v", name, files[0].FileInfo)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// File info key used to store the modification time of uploaded files, in
+// milliseconds since the epoch
+const lastModifiedKey = "src_last_modified_millis"
+
+// File info key used to store the SHA1 hash of large files
+const largeFileSHA1Key = "large_file_sha1"
+
+// listFiles returns the latest version of each file with a prefix, ordered by name
+func listFiles(bucket *backblaze.Bucket, prefix string) ([]backblaze.FileStatus, error) {
+	var files []backblaze.FileStatus
+	startName := ""
+	for {
+		response, err := bucket.ListFileNamesWithPrefix(startName, 1000, prefix, "")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, response.Files...)
+
+		if response.NextFileName == "" {
+			return files, nil
+		}
+		startName = response.NextFileName
+	}
+}
+
+// listVersions returns all versions of the files with a prefix, ordered by
+// name and then from newest to oldest
+func listVersions(bucket *backblaze.Bucket, prefix string) ([]backblaze.FileStatus, error) {
+	var files []backblaze.FileStatus
+	startName, startID := "", ""
+	for {
+		response, err := bucket.ListFileVersionsWithPrefix(startName, startID, 1000, prefix, "")
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, response.Files...)
+
+		if response.NextFileName == "" {
+			return files, nil
+		}
+		startName, startID = response.NextFileName, response.NextFileID
+	}
+}
+
+func millisTime(millis int64) time.Time {
+	return time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond))
+}
+
+func timeMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// Serve is a command
+type Serve struct {
+	Listen       string `short:"l" long:"listen" default:"localhost:8080" description:"The address to listen on"`
+	Prefix       string `long:"prefix" description:"Only serve files whose names start with this prefix, which is removed from their paths"`
+	CacheControl string `long:"cacheControl" description:"The Cache-Control header to send for files without b2-cache-control file info"`
+	NoListing    bool   `long:"noListing" description:"Don't list the contents of folders"`
+}
+
+func init() {
+	parser.AddCommand("serve", "Serve the files in a bucket over HTTP",
+		"Serves the files in the bucket specified with -b over HTTP, without authentication. Request paths are mapped to file names, "+
+			"and paths ending in / list the files in a folder.\n\n"+
+			"Range requests and conditional requests using ETags and modification times are supported.",
+		&Serve{})
+}
+
+// Execute the serve command
+func (o *Serve) Execute(args []string) error {
+	client, err := Client()
+	if err != nil {
+		return err
+	}
+
+	bucket, err := client.Bucket(opts.Bucket)
+	if err != nil {
+		return err
+	}
+	if bucket == nil {
+		return errors.New("Bucket not found: " + opts.Bucket)
+	}
+
+	server := backblaze.NewFileServer(bucket)
+	server.Prefix = o.Prefix
+	server.CacheControl = o.CacheControl
+	server.NoListing = o.NoListing
+
+	log.Printf("Serving bucket %s on http://%s/", bucket.Name, o.Listen)
+	return http.ListenAndServe(o.Listen, server)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// Prefix identifying the bucket side of a sync
+const bucketPathPrefix = "b2:"
+
+// Sync is a command
+type Sync struct {
+	Threads  int  `short:"j" long:"threads" default:"5" description:"Maximum simultaneous transfers to process"`
+	DryRun   bool `long:"dry-run" description:"Display the changes which would be made, without making them"`
+	Delete   bool `long:"delete" description:"Remove files from the destination which are not in the source"`
+	KeepDays int  `long:"keep-days" description:"When syncing to a bucket, hide files which are not in the source, and delete versions which were replaced or hidden more than this many days ago"`
+	SHA1     bool `long:"sha1" description:"Compare the SHA1 hash of files with the same size, instead of their modification times"`
+
+	Include        []string `long:"include" description:"Only sync files matching a glob pattern"`
+	Exclude        []string `long:"exclude" description:"Don't sync files matching a glob pattern"`
+	FollowSymlinks bool     `long:"followSymlinks" description:"Follow symbolic links in the local directory instead of skipping them"`
+
+	uploadOptions
+}
+
+func init() {
+	parser.AddCommand("sync", "Synchronise a directory and a bucket",
+		"Copies files which are missing or different from a source to a destination. One of the source and destination is a local directory, "+
+			"and the other is a prefix in the bucket specified with -b, written as "+bucketPathPrefix+"prefix.\n\n"+
+			"Files are compared by size and modification time. Use --delete or --keep-days to remove files which are not in the source.",
+		&Sync{})
+}
+
+// A change to be made to the destination
+type syncAction struct {
+	op     string // upload, download, hide, delete or remove
+	name   string // Name relative to the root of the sync
+	local  *localFile
+	remote *backblaze.File
+}
+
+func (a syncAction) String() string {
+	switch a.op {
+	case "delete":
+		return fmt.Sprintf("%s %s (%s)", a.op, a.remote.Name, a.remote.ID)
+	case "hide":
+		return fmt.Sprintf("%s %s", a.op, a.remote.Name)
+	default:
+		return fmt.Sprintf("%s %s", a.op, a.name)
+	}
+}
+
+// Execute the sync command
+func (o *Sync) Execute(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Specify a source and destination")
+	}
+
+	var localDir, prefix string
+	upload := strings.HasPrefix(args[1], bucketPathPrefix)
+	switch {
+	case upload && !strings.HasPrefix(args[0], bucketPathPrefix):
+		localDir, prefix = args[0], strings.TrimPrefix(args[1], bucketPathPrefix)
+	case !upload && strings.HasPrefix(args[0], bucketPathPrefix):
+		localDir, prefix = args[1], strings.TrimPrefix(args[0], bucketPathPrefix)
+	default:
+		return errors.New("One of the source and destination must be a local directory, and the other a bucket path starting with " + bucketPathPrefix)
+	}
+	if o.KeepDays < 0 {
+		return errors.New("--keep-days must not be negative")
+	}
+	if o.KeepDays > 0 && !upload {
+		return errors.New("--keep-days can only be used when syncing to a bucket")
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	uploader, err := o.uploader()
+	if err != nil {
+		return err
+	}
+
+	client, err := Client()
+	if err != nil {
+		return err
+	}
+
+	bucket, err := client.Bucket(opts.Bucket)
+	if err != nil {
+		return err
+	}
+	if bucket == nil {
+		return errors.New("Bucket not found: " + opts.Bucket)
+	}
+
+	filter := fileFilter{Include: o.Include, Exclude: o.Exclude}
+
+	// Collect local files
+	localFiles := make(map[string]*localFile)
+	err = walkDir(localDir, o.FollowSymlinks, func(file localFile) error {
+		if filter.Match(file.Name) {
+			localFiles[file.Name] = &file
+		}
+		return nil
+	})
+	if err != nil && !(os.IsNotExist(err) && !upload) {
+		return err
+	}
+
+	// Collect remote file versions, newest first
+	versions, err := listVersions(bucket, prefix)
+	if err != nil {
+		return err
+	}
+	remoteFiles := make(map[string][]*backblaze.File)
+	for i := range versions {
+		file := &versions[i].File
+		name := strings.TrimPrefix(file.Name, prefix)
+		if file.Action == backblaze.Start || file.Action == backblaze.Folder || !filter.Match(name) {
+			continue
+		}
+		remoteFiles[name] = append(remoteFiles[name], file)
+	}
+
+	var actions []syncAction
+	if upload {
+		actions, err = o.uploadActions(localFiles, remoteFiles)
+	} else {
+		actions, err = o.downloadActions(localFiles, remoteFiles)
+	}
+	if err != nil {
+		return err
+	}
+
+	if o.DryRun {
+		for _, action := range actions {
+			if opts.JSON {
+				printJSON(jsonResult{Name: action.String(), Status: "planned", File: action.remote})
+			} else {
+				fmt.Println(action)
+			}
+		}
+		return nil
+	}
+
+	tasks := make([]task, len(actions))
+	for i, action := range actions {
+		action := action
+		tasks[i] = task{
+			name: action.String(),
+			run: func() (*backblaze.File, error) {
+				return applyAction(client, bucket, uploader, localDir, prefix, action)
+			},
+		}
+	}
+
+	startProgress()
+	return reportResults(runTasks(tasks, o.Threads))
+}
+
+// Makes a change to the destination of a sync between a local directory and
+// a prefix in a bucket
+func applyAction(client *backblaze.B2, bucket *backblaze.Bucket, uploader *uploader, localDir, prefix string, action syncAction) (*backblaze.File, error) {
+	switch action.op {
+	case "upload":
+		file := uploadTask{path: action.local.Path, name: prefix + action.name, info: action.local.Info}
+		return uploader.upload(bucket, file, nil)
+	case "download":
+		path, err := localPath(localDir, action.name)
+		if err != nil {
+			return action.remote, err
+		}
+		return action.remote, syncDownload(client, action.remote, path)
+	case "hide":
+		status, err := bucket.HideFile(action.remote.Name)
+		return statusFile(status), err
+	case "delete":
+		status, err := bucket.DeleteFileVersion(action.remote.Name, action.remote.ID)
+		return statusFile(status), err
+	case "remove":
+		return nil, os.Remove(action.local.Path)
+	}
+	return nil, nil
+}
+
+// Determines the changes needed to make the bucket match the local directory
+func (o *Sync) uploadActions(localFiles map[string]*localFile, remoteFiles map[string][]*backblaze.File) ([]syncAction, error) {
+	var actions []syncAction
+	cutoff := timeMillis(time.Now().AddDate(0, 0, -o.KeepDays))
+
+	for _, name := range sortedNames(localFiles, remoteFiles) {
+		local := localFiles[name]
+		versions := remoteFiles[name]
+
+		var current *backblaze.File
+		if len(versions) > 0 && versions[0].Action == backblaze.Upload {
+			current = versions[0]
+		}
+
+		switch {
+		case local != nil:
+			same, err := o.sameFile(local, current)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				actions = append(actions, syncAction{op: "upload", name: name, local: local})
+			}
+		case current != nil && o.Delete:
+			for _, version := range versions {
+				actions = append(actions, syncAction{op: "delete", name: name, remote: version})
+			}
+			continue
+		case current != nil && o.KeepDays > 0:
+			actions = append(actions, syncAction{op: "hide", name: name, remote: current})
+		}
+
+		// Delete versions which were replaced or hidden before the cutoff
+		if o.KeepDays > 0 {
+			expired := 0
+			for i := 1; i < len(versions); i++ {
+				if versions[i-1].UploadTimestamp < cutoff {
+					actions = append(actions, syncAction{op: "delete", name: name, remote: versions[i]})
+					expired++
+				}
+			}
+
+			// Remove hide markers once all the versions they hide have been deleted
+			if local == nil && current == nil && len(versions) > 0 && expired == len(versions)-1 && versions[0].UploadTimestamp < cutoff {
+				actions = append(actions, syncAction{op: "delete", name: name, remote: versions[0]})
+			}
+		}
+	}
+	return actions, nil
+}
+
+// Determines the changes needed to make the local directory match the bucket
+func (o *Sync) downloadActions(localFiles map[string]*localFile, remoteFiles map[string][]*backblaze.File) ([]syncAction, error) {
+	var actions []syncAction
+
+	for _, name := range sortedNames(localFiles, remoteFiles) {
+		local := localFiles[name]
+		versions := remoteFiles[name]
+
+		var current *backblaze.File
+		if len(versions) > 0 && versions[0].Action == backblaze.Upload {
+			current = versions[0]
+		}
+
+		switch {
+		case current != nil:
+			same, err := o.sameFile(local, current)
+			if err != nil {
+				return nil, err
+			}
+			if !same {
+				actions = append(actions, syncAction{op: "download", name: name, remote: current})
+			}
+		case local != nil && o.Delete:
+			actions = append(actions, syncAction{op: "remove", name: name, local: local})
+		}
+	}
+	return actions, nil
+}
+
+// Compares a local and remote file
+func (o *Sync) sameFile(local *localFile, remote *backblaze.File) (bool, error) {
+	if local == nil || remote == nil || local.Info.Size() != remote.ContentLength {
+		return false, nil
+	}
+
+	if o.SHA1 {
+		if remoteSHA1 := remote.SHA1(); remoteSHA1 != "" {
+			localSHA1, err := hashFile(local.Path)
+			if err != nil {
+				return false, err
+			}
+			return localSHA1 == remoteSHA1, nil
+		}
+	}
+
+	return timeMillis(local.Info.ModTime()) == timeMillis(remote.ModTime()), nil
+}
+
+// Returns the names present in either set of files, in order
+func sortedNames(localFiles map[string]*localFile, remoteFiles map[string][]*backblaze.File) []string {
+	var names []string
+	for name := range localFiles {
+		names = append(names, name)
+	}
+	for name := range remoteFiles {
+		if _, ok := localFiles[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Downloads a file, setting its modification time to the time recorded when
+// it was uploaded
+func syncDownload(client *backblaze.B2, file *backblaze.File, path string) error {
+	reader, err := client.ReadaheadFileWithProgress(file, progressBar(file.Name))
+	if err != nil {
+		return err
+	}
+
+	if err := download(file, reader, path, false); err != nil {
+		return err
+	}
+
+	modTime := file.ModTime()
+	return os.Chtimes(path, modTime, modTime)
+}
+
+// Returns the SHA1 hash of a local file
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha1.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
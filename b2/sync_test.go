@@ -0,0 +1,214 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// Creates local files with the given contents and modification time
+func writeLocalFiles(T *testing.T, dir string, modified time.Time, contents map[string]string) map[string]*localFile {
+	for name, content := range contents {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			T.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			T.Fatal(err)
+		}
+	}
+	files := make(map[string]*localFile)
+	err := walkDir(dir, false, func(file localFile) error {
+		files[file.Name] = &file
+		return nil
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	return files
+}
+
+// Returns a remote file version uploaded at a time
+func remoteFile(id, name string, action backblaze.FileAction, size int64, uploaded, modified time.Time) *backblaze.File {
+	return &backblaze.File{
+		ID:              id,
+		Name:            name,
+		Action:          action,
+		ContentLength:   size,
+		UploadTimestamp: timeMillis(uploaded),
+		FileInfo:        map[string]string{lastModifiedKey: strconv.FormatInt(timeMillis(modified), 10)},
+	}
+}
+
+func TestSameFile(T *testing.T) {
+	dir, err := ioutil.TempDir("", "b2sync")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	local := writeLocalFiles(T, dir, modified, map[string]string{"a.txt": "content"})["a.txt"]
+	sha1, err := hashFile(local.Path)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	withHash := func(hash string, info bool) *backblaze.File {
+		file := remoteFile("id", "a.txt", backblaze.Upload, 7, modified, modified.Add(time.Second))
+		if info {
+			file.ContentSha1 = "none"
+			file.FileInfo[largeFileSHA1Key] = hash
+		} else {
+			file.ContentSha1 = hash
+		}
+		return file
+	}
+
+	tests := []struct {
+		name   string
+		sha1   bool
+		remote *backblaze.File
+		same   bool
+	}{
+		{"missing", false, nil, false},
+		{"same time", false, remoteFile("id", "a.txt", backblaze.Upload, 7, modified, modified), true},
+		{"different size", false, remoteFile("id", "a.txt", backblaze.Upload, 8, modified, modified), false},
+		{"different time", false, remoteFile("id", "a.txt", backblaze.Upload, 7, modified, modified.Add(time.Second)), false},
+		{"upload time", false, &backblaze.File{ContentLength: 7, UploadTimestamp: timeMillis(modified)}, true},
+		{"same hash", true, withHash(sha1, false), true},
+		{"unverified hash", true, withHash("unverified:"+sha1, false), true},
+		{"large file hash", true, withHash(sha1, true), true},
+		{"different hash", true, withHash("0000000000000000000000000000000000000000", false), false},
+		{"no hash", true, remoteFile("id", "a.txt", backblaze.Upload, 7, modified, modified), true},
+	}
+	for _, test := range tests {
+		o := &Sync{SHA1: test.sha1}
+		if same, err := o.sameFile(local, test.remote); err != nil || same != test.same {
+			T.Errorf("Expected %s to be same %v, saw %v %v", test.name, test.same, same, err)
+		}
+	}
+}
+
+func TestSyncActions(T *testing.T) {
+	dir, err := ioutil.TempDir("", "b2sync")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	old := now.AddDate(0, 0, -60)
+	recent := now.AddDate(0, 0, -1)
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	local := writeLocalFiles(T, dir, modified, map[string]string{
+		"changed":  "content",
+		"kept":     "content",
+		"new":      "content",
+		"replaced": "content",
+		"same":     "content",
+	})
+
+	// Versions of each remote file, newest first
+	remote := map[string][]*backblaze.File{
+		"changed": {remoteFile("changed-1", "changed", backblaze.Upload, 7, old, modified.Add(-time.Second))},
+		"gone": {
+			remoteFile("gone-2", "gone", backblaze.Upload, 7, recent, modified),
+			remoteFile("gone-1", "gone", backblaze.Upload, 7, old, modified),
+		},
+		"hidden": {
+			remoteFile("hidden-2", "hidden", backblaze.Hide, 0, old, modified),
+			remoteFile("hidden-1", "hidden", backblaze.Upload, 7, old, modified),
+		},
+		"kept": {
+			remoteFile("kept-2", "kept", backblaze.Upload, 7, recent, modified),
+			remoteFile("kept-1", "kept", backblaze.Upload, 7, old, modified),
+		},
+		"replaced": {
+			remoteFile("replaced-2", "replaced", backblaze.Upload, 7, old, modified),
+			remoteFile("replaced-1", "replaced", backblaze.Upload, 7, old, modified),
+		},
+		"same": {remoteFile("same-1", "same", backblaze.Upload, 7, old, modified)},
+	}
+
+	uploads := []struct {
+		sync    Sync
+		actions []string
+	}{
+		{Sync{}, []string{"upload changed", "upload new"}},
+		{Sync{Delete: true}, []string{
+			"upload changed",
+			"delete gone (gone-2)",
+			"delete gone (gone-1)",
+			"upload new",
+		}},
+		{Sync{KeepDays: 30}, []string{
+			"upload changed",
+			"hide gone",
+			"delete hidden (hidden-1)",
+			"delete hidden (hidden-2)",
+			"upload new",
+			"delete replaced (replaced-1)",
+		}},
+	}
+	for _, test := range uploads {
+		actions, err := test.sync.uploadActions(local, remote)
+		if err != nil {
+			T.Fatal(err)
+		}
+		if names := actionNames(actions); !reflect.DeepEqual(names, test.actions) {
+			T.Errorf("Expected upload actions %q with %+v, saw %q", test.actions, test.sync, names)
+		}
+	}
+
+	downloads := []struct {
+		sync    Sync
+		actions []string
+	}{
+		{Sync{}, []string{"download changed", "download gone"}},
+		{Sync{Delete: true}, []string{"download changed", "download gone", "remove new"}},
+	}
+	for _, test := range downloads {
+		actions, err := test.sync.downloadActions(local, remote)
+		if err != nil {
+			T.Fatal(err)
+		}
+		if names := actionNames(actions); !reflect.DeepEqual(names, test.actions) {
+			T.Errorf("Expected download actions %q with %+v, saw %q", test.actions, test.sync, names)
+		}
+	}
+}
+
+func actionNames(actions []syncAction) []string {
+	names := make([]string, len(actions))
+	for i, action := range actions {
+		names[i] = action.String()
+	}
+	return names
+}
+
+func TestSyncDownloadOutsideDirectory(T *testing.T) {
+	parent, err := ioutil.TempDir("", "b2sync")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(parent)
+	dir := filepath.Join(parent, "dir")
+
+	// The download fails before the file is requested
+	remote := &backblaze.File{ID: "escape-1", Name: "../escape.txt", Action: backblaze.Upload}
+	action := syncAction{op: "download", name: remote.Name, remote: remote}
+	if _, err := applyAction(nil, nil, nil, dir, "", action); err == nil {
+		T.Errorf("Expected download outside the directory to fail")
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escape.txt")); !os.IsNotExist(err) {
+		T.Errorf("Expected no file outside the directory, saw %v", err)
+	}
+}
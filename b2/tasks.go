@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// errSkipped is returned by a task which had nothing to do
+var errSkipped = errors.New("skipped")
+
+// Outcomes of a task
+const (
+	taskDone      = "done"
+	taskSkipped   = "skipped"
+	taskFailed    = "failed"
+	taskCancelled = "cancelled"
+)
+
+// task is an operation on a single file, run by a worker. The task may
+// return the file it created or accessed.
+type task struct {
+	name string
+	run  func() (*backblaze.File, error)
+}
+
+// taskResult records the outcome of a task
+type taskResult struct {
+	Name   string
+	Status string
+	Err    error
+	File   *backblaze.File
+}
+
+// jsonResult is the JSON output for a taskResult
+type jsonResult struct {
+	Name   string          `json:"name"`
+	Status string          `json:"status"`
+	Error  string          `json:"error,omitempty"`
+	File   *backblaze.File `json:"file,omitempty"`
+}
+
+// runTasks runs tasks on a pool of workers, returning the result of each
+// task in the order they were given. If --fail-fast is set, tasks which have
+// not started when a task fails are cancelled.
+func runTasks(tasks []task, threads int) []taskResult {
+	if threads < 1 {
+		threads = 1
+	}
+
+	results := make([]taskResult, len(tasks))
+	indexes := make(chan int, threads)
+	group := sync.WaitGroup{}
+
+	var mutex sync.Mutex
+	failed := false
+
+	// Create workers
+	for i := 0; i < threads; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for i := range indexes {
+				t := tasks[i]
+				result := taskResult{Name: t.name, Status: taskDone}
+
+				mutex.Lock()
+				cancelled := failed && opts.FailFast
+				mutex.Unlock()
+
+				var err error
+				if cancelled {
+					result.Status = taskCancelled
+				} else if result.File, err = t.run(); err == errSkipped {
+					result.Status = taskSkipped
+				} else if err != nil {
+					result.Status = taskFailed
+					result.Err = err
+
+					mutex.Lock()
+					failed = true
+					mutex.Unlock()
+				}
+				results[i] = result
+			}
+		}()
+	}
+
+	for i := range tasks {
+		indexes <- i
+	}
+	close(indexes)
+
+	group.Wait()
+	return results
+}
+
+// reportResults prints a summary of the results of a command's tasks, and
+// returns an error if any of them failed. Failed and cancelled tasks are
+// always listed; all tasks are listed with verbose or JSON output.
+func reportResults(results []taskResult) error {
+	stopProgress()
+
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+
+		switch {
+		case opts.JSON:
+			output := jsonResult{Name: result.Name, Status: result.Status, File: result.File}
+			if result.Err != nil {
+				output.Error = result.Err.Error()
+			}
+			printJSON(output)
+		case result.Err != nil:
+			fmt.Printf("%-9s %s: %v\n", result.Status, result.Name, result.Err)
+		case result.Status == taskCancelled || opts.Verbose:
+			fmt.Printf("%-9s %s\n", result.Status, result.Name)
+		}
+	}
+
+	failed := counts[taskFailed] + counts[taskCancelled]
+	if (failed > 0 || opts.Verbose) && !opts.JSON {
+		fmt.Printf("%d done, %d skipped, %d failed, %d cancelled\n",
+			counts[taskDone], counts[taskSkipped], counts[taskFailed], counts[taskCancelled])
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d operations failed or were cancelled", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// localFile is a regular file found while walking a local directory
+type localFile struct {
+	Path string // Path of the file on disk
+	Name string // Slash separated path of the file relative to the directory
+	Info os.FileInfo
+}
+
+// walkDir calls fn for each regular file in a directory tree, in lexical
+// order. Symbolic links are followed if follow is true, and skipped otherwise.
+// Directories reached through more than one link are only walked once.
+func walkDir(root string, follow bool, fn func(file localFile) error) error {
+	visited := make(map[string]bool)
+
+	var walk func(dir, rel string) error
+	walk = func(dir, rel string) error {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if visited[real] {
+			return nil
+		}
+		visited[real] = true
+
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range entries {
+			filePath := filepath.Join(dir, info.Name())
+			name := info.Name()
+			if rel != "" {
+				name = rel + "/" + name
+			}
+
+			if info.Mode()&os.ModeSymlink != 0 {
+				if !follow {
+					continue
+				}
+				if info, err = os.Stat(filePath); err != nil {
+					return err
+				}
+			}
+
+			switch {
+			case info.IsDir():
+				err = walk(filePath, name)
+			case info.Mode().IsRegular():
+				err = fn(localFile{Path: filePath, Name: name, Info: info})
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(root, "")
+}
+
+// fileFilter selects files using glob patterns matched against their
+// relative names. Patterns without a slash match the name of the file or
+// any of its parent directories, other patterns match from the root.
+type fileFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Match returns true if a file should be included
+func (f fileFilter) Match(name string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, name) {
+		return false
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		for target := name; target != "." && target != "/"; target = path.Dir(target) {
+			candidate := target
+			if !strings.Contains(pattern, "/") {
+				candidate = path.Base(target)
+			}
+			if ok, _ := path.Match(pattern, candidate); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// joinName adds a prefix to a slash separated file name
+func joinName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + name
+}
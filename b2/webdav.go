@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/webdav"
+
+	"gopkg.in/kothar/go-backblaze.v0/b2webdav"
+)
+
+// WebDAV is a command
+type WebDAV struct {
+	Listen string `short:"l" long:"listen" default:"localhost:8080" description:"The address to listen on"`
+	Prefix string `long:"prefix" description:"Only expose files in this folder of the bucket"`
+}
+
+func init() {
+	parser.AddCommand("webdav", "Serve a bucket over WebDAV",
+		"Serves the bucket specified with -b over WebDAV, without authentication, so that it can be mounted by desktop clients.\n\n"+
+			"Folders are emulated using the prefixes of file names. Files are uploaded when they are closed, "+
+			"and deleting or renaming a file hides its previous name.",
+		&WebDAV{})
+}
+
+// Execute the webdav command
+func (o *WebDAV) Execute(args []string) error {
+	client, err := Client()
+	if err != nil {
+		return err
+	}
+
+	bucket, err := client.Bucket(opts.Bucket)
+	if err != nil {
+		return err
+	}
+	if bucket == nil {
+		return errors.New("Bucket not found: " + opts.Bucket)
+	}
+
+	fs := b2webdav.NewFileSystem(bucket)
+	if o.Prefix != "" {
+		fs.Prefix = strings.TrimSuffix(o.Prefix, "/") + "/"
+	}
+	handler := &webdav.Handler{
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && opts.Verbose {
+				log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+
+	log.Printf("Serving bucket %s over WebDAV on http://%s/", bucket.Name, o.Listen)
+	return http.ListenAndServe(o.Listen, handler)
+}
@@ -0,0 +1,478 @@
+// Package b2crypt encrypts the files stored in a bucket on the client, so
+// that their content can't be read by Backblaze or anyone else with access to
+// the bucket.
+//
+//	bucket, _ := b2crypt.NewBucket(b2bucket, key)
+//	bucket.EncryptNames = true
+//
+//	file, _ := bucket.UploadFile("notes.txt", nil, reader)
+//	file, reader, _ := bucket.DownloadFileByName("notes.txt")
+//
+// Each file is encrypted with its own random data key using AES-256-GCM, in
+// chunks of 64KiB so that files can be streamed, and ranges can be downloaded
+// without the rest of the file. Chunks are numbered and the last is marked, so
+// reordering, truncating or extending the content causes decryption to fail.
+// The data key is stored in the file info, encrypted with a key derived from
+// the master key.
+//
+// If EncryptNames is set, each folder and file name in a path is encrypted
+// deterministically, so that files can still be found by name and listed by
+// folder. Equal names have equal encrypted names, and the length of names and
+// the folder structure are not hidden. Content types, file info and the
+// sizes of files are stored unencrypted.
+//
+// The content of a file is not bound to its name. Anyone with write access to
+// the bucket can copy or rename a file, or upload its content and file info
+// under another name, and it will decrypt as the file with the new name.
+// Applications which need to detect this should store the name in the file
+// content.
+package b2crypt // import "gopkg.in/kothar/go-backblaze.v0/b2crypt"
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// Errors which may be matched against errors returned by a Bucket using errors.Is
+var (
+	// Returned when a file has no encrypted data key
+	ErrNotEncrypted = errors.New("file is not encrypted")
+
+	// Returned when the content or data key of a file can't be decrypted,
+	// because the master key is wrong or the file has been modified
+	ErrDecryptionFailed = errors.New("decryption failed")
+)
+
+// The file info keys used to store the encryption parameters of a file
+const (
+	keyInfo   = "b2crypt_key"
+	nonceInfo = "b2crypt_nonce"
+)
+
+var _ backblaze.Storage = (*Bucket)(nil)
+
+// Bucket encrypts the files uploaded to a bucket, and decrypts the files
+// downloaded from it. All files accessed through a Bucket are expected to
+// have been encrypted with the same key.
+type Bucket struct {
+	// Encrypt the names of files, as well as their content. This must be set
+	// the same way each time a bucket is used.
+	EncryptNames bool
+
+	bucket  *backblaze.Bucket
+	keyWrap cipher.AEAD
+	nameKey cipher.Block
+	nameMAC []byte
+}
+
+// NewBucket returns a Bucket which encrypts files stored in a bucket using a
+// 32 byte master key
+func NewBucket(bucket *backblaze.Bucket, key []byte) (*Bucket, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, not %d", len(key))
+	}
+	keyWrap, err := newGCM(deriveKey(key, "key wrap"))
+	if err != nil {
+		return nil, err
+	}
+	nameKey, err := aes.NewCipher(deriveKey(key, "name encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return &Bucket{
+		bucket:  bucket,
+		keyWrap: keyWrap,
+		nameKey: nameKey,
+		nameMAC: deriveKey(key, "name authentication"),
+	}, nil
+}
+
+// Creates a data key for a new file, and the file info which stores it
+func (b *Bucket) newFileCipher(meta map[string]string) (*contentCipher, map[string]string, error) {
+	key := make([]byte, 32)
+	prefix := make([]byte, noncePrefixSize)
+	wrapNonce := make([]byte, b.keyWrap.NonceSize())
+	for _, buf := range [][]byte{key, prefix, wrapNonce} {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The nonce prefix is authenticated with the data key, so that it can't
+	// be replaced
+	wrapped := b.keyWrap.Seal(wrapNonce, wrapNonce, key, prefix)
+
+	info := make(map[string]string, len(meta)+2)
+	for k, v := range meta {
+		info[k] = v
+	}
+	info[keyInfo] = base64.RawURLEncoding.EncodeToString(wrapped)
+	info[nonceInfo] = base64.RawURLEncoding.EncodeToString(prefix)
+	return &contentCipher{aead, prefix}, info, nil
+}
+
+// Decrypts the data key of a file
+func (b *Bucket) fileCipher(file *backblaze.File) (*contentCipher, error) {
+	encodedKey, ok := file.FileInfo[keyInfo]
+	if !ok {
+		return nil, fmt.Errorf("file %q: %w", file.Name, ErrNotEncrypted)
+	}
+	wrapped, err := base64.RawURLEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("file %q has an invalid data key: %w", file.Name, ErrDecryptionFailed)
+	}
+	prefix, err := base64.RawURLEncoding.DecodeString(file.FileInfo[nonceInfo])
+	if err != nil || len(prefix) != noncePrefixSize {
+		return nil, fmt.Errorf("file %q has an invalid nonce: %w", file.Name, ErrDecryptionFailed)
+	}
+
+	nonceSize := b.keyWrap.NonceSize()
+	if len(wrapped) < nonceSize {
+		return nil, fmt.Errorf("file %q has an invalid data key: %w", file.Name, ErrDecryptionFailed)
+	}
+	key, err := b.keyWrap.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], prefix)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the data key of file %q: %w", file.Name, ErrDecryptionFailed)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &contentCipher{aead, prefix}, nil
+}
+
+// Returns a copy of a file as it was uploaded, with its name and size
+// decrypted and without the encryption parameters in its file info
+func (b *Bucket) decryptFile(file *backblaze.File) (*backblaze.File, error) {
+	name, err := b.decryptName(file.Name)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := *file
+	decrypted.Name = name
+	// Downloaded files have no action
+	if file.Action == backblaze.Upload || file.Action == "" {
+		decrypted.ContentLength = plaintextSize(file.ContentLength)
+	}
+	if file.FileInfo != nil {
+		decrypted.FileInfo = make(map[string]string, len(file.FileInfo))
+		for k, v := range file.FileInfo {
+			if k != keyInfo && k != nonceInfo {
+				decrypted.FileInfo[k] = v
+			}
+		}
+	}
+	return &decrypted, nil
+}
+
+// Encrypts each folder and file name in a path if EncryptNames is set.
+//
+// Names are encrypted with AES-CTR, using the start of an HMAC of the name as
+// the IV, which also authenticates the name when it is decrypted.
+func (b *Bucket) encryptName(name string) string {
+	if !b.EncryptNames {
+		return name
+	}
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		mac := hmac.New(sha256.New, b.nameMAC)
+		mac.Write([]byte(part))
+		iv := mac.Sum(nil)[:aes.BlockSize]
+
+		encrypted := make([]byte, aes.BlockSize+len(part))
+		copy(encrypted, iv)
+		cipher.NewCTR(b.nameKey, iv).XORKeyStream(encrypted[aes.BlockSize:], []byte(part))
+		parts[i] = base64.RawURLEncoding.EncodeToString(encrypted)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Decrypts a path encrypted by encryptName
+func (b *Bucket) decryptName(name string) (string, error) {
+	if !b.EncryptNames {
+		return name, nil
+	}
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		encrypted, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil || len(encrypted) < aes.BlockSize {
+			return "", fmt.Errorf("file name %q is not encrypted: %w", name, ErrDecryptionFailed)
+		}
+		iv := encrypted[:aes.BlockSize]
+		decrypted := make([]byte, len(encrypted)-aes.BlockSize)
+		cipher.NewCTR(b.nameKey, iv).XORKeyStream(decrypted, encrypted[aes.BlockSize:])
+
+		mac := hmac.New(sha256.New, b.nameMAC)
+		mac.Write(decrypted)
+		if !hmac.Equal(mac.Sum(nil)[:aes.BlockSize], iv) {
+			return "", fmt.Errorf("unable to decrypt file name %q: %w", name, ErrDecryptionFailed)
+		}
+		parts[i] = string(decrypted)
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// Chooses a content type from a file name if it is not given. B2 would
+// choose it from the encrypted name.
+func (b *Bucket) contentType(value, name string) string {
+	if value != "b2/x-auto" && value != "" {
+		return value
+	}
+	if !b.EncryptNames {
+		return "b2/x-auto"
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name))); err == nil {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// UploadFile encrypts and uploads a file, choosing its content type from its
+// name
+func (b *Bucket) UploadFile(name string, meta map[string]string, file io.Reader) (*backblaze.File, error) {
+	return b.UploadTypedFile(name, "b2/x-auto", meta, file)
+}
+
+// UploadTypedFile encrypts and uploads a file. If the file is an io.ReaderAt
+// and io.Seeker, such as an *os.File, it is encrypted as it is uploaded.
+// Otherwise the encrypted content is buffered in memory, as by
+// backblaze.Bucket.UploadTypedFile.
+func (b *Bucket) UploadTypedFile(name, contentType string, meta map[string]string, file io.Reader) (*backblaze.File, error) {
+	c, info, err := b.newFileCipher(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	var content io.Reader = newEncryptReader(c, file)
+	if r, ok := file.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		start, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		size := end - start
+		section := io.NewSectionReader(r, start, size)
+		content = io.NewSectionReader(newEncryptReaderAt(c, section, size), 0, encryptedSize(size))
+	}
+
+	uploaded, err := b.bucket.UploadTypedFile(b.encryptName(name), b.contentType(contentType, name), info, content)
+	if err != nil {
+		return nil, err
+	}
+	return b.decryptFile(uploaded)
+}
+
+// UploadLargeFile encrypts and uploads a file in parts, using
+// backblaze.Bucket.UploadLargeFile
+func (b *Bucket) UploadLargeFile(name, contentType string, meta map[string]string, file io.ReaderAt, size int64) (*backblaze.File, error) {
+	c, info, err := b.newFileCipher(meta)
+	if err != nil {
+		return nil, err
+	}
+	if size/chunkSize >= 1<<32 {
+		return nil, fmt.Errorf("file %q is too large to encrypt", name)
+	}
+
+	content := newEncryptReaderAt(c, file, size)
+	uploaded, err := b.bucket.UploadLargeFile(b.encryptName(name), b.contentType(contentType, name), info, content, encryptedSize(size))
+	if err != nil {
+		return nil, err
+	}
+	return b.decryptFile(uploaded)
+}
+
+// DownloadFileByName downloads and decrypts a file. The content is
+// authenticated as it is read, and reading fails with an error matching
+// ErrDecryptionFailed if it has been modified.
+func (b *Bucket) DownloadFileByName(name string) (*backblaze.File, io.ReadCloser, error) {
+	return b.GetRange(context.Background(), name, nil)
+}
+
+// DownloadFileRangeByName downloads and decrypts part of a file. Only the
+// chunks containing the range are downloaded, and they are authenticated as
+// they are read, including whether the last of them ends the file. The
+// ContentLength of the file is the length of the range returned.
+func (b *Bucket) DownloadFileRangeByName(name string, fileRange *backblaze.FileRange) (*backblaze.File, io.ReadCloser, error) {
+	return b.GetRange(context.Background(), name, fileRange)
+}
+
+// HideFile hides a file, as by backblaze.Bucket.HideFile
+func (b *Bucket) HideFile(name string) (*backblaze.FileStatus, error) {
+	status, err := b.bucket.HideFile(b.encryptName(name))
+	if err != nil {
+		return nil, err
+	}
+	file, err := b.decryptFile(&status.File)
+	if err != nil {
+		return nil, err
+	}
+	return &backblaze.FileStatus{File: *file}, nil
+}
+
+// Put encrypts and uploads a file using UploadTypedFile
+func (b *Bucket) Put(ctx context.Context, name, contentType string, meta map[string]string, content io.Reader) (*backblaze.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return b.UploadTypedFile(name, contentType, meta, content)
+}
+
+// Get downloads and decrypts a file
+func (b *Bucket) Get(ctx context.Context, name string) (*backblaze.File, io.ReadCloser, error) {
+	return b.GetRange(ctx, name, nil)
+}
+
+// GetRange downloads and decrypts part of a file, as DownloadFileRangeByName
+func (b *Bucket) GetRange(ctx context.Context, name string, fileRange *backblaze.FileRange) (*backblaze.File, io.ReadCloser, error) {
+	// Download the whole chunks containing the range, and one more byte. The
+	// last chunk is final if the byte after it is not returned.
+	var first, chunksLength int64
+	var encryptedRange *backblaze.FileRange
+	if fileRange != nil {
+		if fileRange.Start < 0 || fileRange.End < fileRange.Start {
+			return nil, nil, fmt.Errorf("invalid range %d-%d: %w", fileRange.Start, fileRange.End, backblaze.ErrBadRequest)
+		}
+		end := fileRange.End
+		if max := int64(chunkSize)<<32 - 1; end > max {
+			end = max
+		}
+		first = fileRange.Start / chunkSize
+		chunksLength = (end/chunkSize + 1 - first) * encryptedChunkSize
+		encryptedRange = &backblaze.FileRange{
+			Start: first * encryptedChunkSize,
+			End:   first*encryptedChunkSize + chunksLength,
+		}
+	}
+
+	file, body, err := b.bucket.GetRange(ctx, b.encryptName(name), encryptedRange)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := b.fileCipher(file)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	decrypted, err := b.decryptFile(file)
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	if fileRange == nil {
+		return decrypted, newDecryptReader(c, body, 0, false, 0, decrypted.ContentLength), nil
+	}
+
+	available := decrypted.ContentLength
+	if file.ContentLength > chunksLength {
+		available = chunksLength / encryptedChunkSize * chunkSize
+	}
+	end := first*chunkSize + available
+	if fileRange.End+1 < end {
+		end = fileRange.End + 1
+	}
+	if end <= fileRange.Start {
+		body.Close()
+		return nil, nil, fmt.Errorf("range %d-%d of file %q: %w", fileRange.Start, fileRange.End, name, backblaze.ErrRangeNotSatisfiable)
+	}
+	decrypted.ContentLength = end - fileRange.Start
+	skip := fileRange.Start - first*chunkSize
+	return decrypted, newDecryptReader(c, body, first, true, skip, decrypted.ContentLength), nil
+}
+
+// Stat returns a file without its content
+func (b *Bucket) Stat(ctx context.Context, name string) (*backblaze.File, error) {
+	file, err := b.bucket.Stat(ctx, b.encryptName(name))
+	if err != nil {
+		return nil, err
+	}
+	return b.decryptFile(file)
+}
+
+// List lists the current versions of files, as backblaze.Bucket.List.
+//
+// If EncryptNames is set, only the folders in the prefix can be encrypted, so
+// all files in the folder containing the prefix are listed and then filtered
+// and sorted by their decrypted names before fn is called. Only a delimiter
+// of "/" is supported, and files whose names can't be decrypted are skipped.
+func (b *Bucket) List(ctx context.Context, prefix, delimiter string, fn func(file *backblaze.File) error) error {
+	if !b.EncryptNames {
+		return b.bucket.List(ctx, prefix, delimiter, func(file *backblaze.File) error {
+			decrypted, err := b.decryptFile(file)
+			if err != nil {
+				return err
+			}
+			return fn(decrypted)
+		})
+	}
+
+	if delimiter != "" && delimiter != "/" {
+		return fmt.Errorf("delimiter %q can't be used with encrypted names: %w", delimiter, backblaze.ErrBadRequest)
+	}
+	folder := prefix[:strings.LastIndex(prefix, "/")+1]
+	var files []*backblaze.File
+	err := b.bucket.List(ctx, b.encryptName(folder), delimiter, func(file *backblaze.File) error {
+		decrypted, err := b.decryptFile(file)
+		if err == nil && strings.HasPrefix(decrypted.Name, prefix) {
+			files = append(files, decrypted)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	for _, file := range files {
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes every version of a file, as backblaze.Bucket.Remove
+func (b *Bucket) Remove(ctx context.Context, name string) error {
+	return b.bucket.Remove(ctx, b.encryptName(name))
+}
+
+// Copy copies a file to a new name without decrypting it. The copy can be
+// decrypted, since the data key is kept in its file info.
+func (b *Bucket) Copy(ctx context.Context, source, destination string) (*backblaze.File, error) {
+	file, err := b.bucket.Copy(ctx, b.encryptName(source), b.encryptName(destination))
+	if err != nil {
+		return nil, err
+	}
+	return b.decryptFile(file)
+}
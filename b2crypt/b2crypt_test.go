@@ -0,0 +1,293 @@
+package b2crypt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+	"gopkg.in/kothar/go-backblaze.v0/b2test"
+)
+
+var testKey = bytes.Repeat([]byte{42}, 32)
+
+func newBucket(T *testing.T, protocol backblaze.Protocol) (*backblaze.Bucket, func()) {
+	server := b2test.NewUnstartedServer()
+	server.AbsoluteMinimumPartSize = 5
+	server.Start()
+
+	client := server.NewClient()
+	client.Protocol = protocol
+	return b2test.CreateBucket(T, client, "crypt-bucket"), server.Close
+}
+
+func TestBucket(T *testing.T) {
+	for _, protocol := range []backblaze.Protocol{backblaze.ProtocolNative, backblaze.ProtocolS3} {
+		raw, closeServer := newBucket(T, protocol)
+		defer closeServer()
+
+		bucket, err := NewBucket(raw, testKey)
+		if err != nil {
+			T.Fatal(err)
+		}
+		read := b2test.ReadDownload(T)
+
+		content := make([]byte, 3*chunkSize+5)
+		rand.New(rand.NewSource(1)).Read(content)
+
+		// Sizes around chunk boundaries, uploaded from seekable and streamed readers
+		for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, len(content)} {
+			var reader io.Reader = bytes.NewReader(content[:size])
+			if size%2 == 1 {
+				reader = ioutil.NopCloser(reader)
+			}
+			meta := map[string]string{"owner": "test"}
+			uploaded, err := bucket.UploadFile("file.txt", meta, reader)
+			if err != nil {
+				T.Fatal(err)
+			}
+			if uploaded.Name != "file.txt" || uploaded.ContentLength != int64(size) || !strings.HasPrefix(uploaded.ContentType, "text/plain") {
+				T.Errorf("Unexpected uploaded file %+v", uploaded)
+			}
+
+			file, data := read(bucket.DownloadFileByName("file.txt"))
+			if !bytes.Equal(data, content[:size]) || file.ContentLength != int64(size) {
+				T.Errorf("Unexpected content of %d bytes, saw %d bytes with length %d", size, len(data), file.ContentLength)
+			}
+			if protocol == backblaze.ProtocolNative && (len(file.FileInfo) != 1 || file.FileInfo["owner"] != "test") {
+				T.Errorf("Unexpected file info %v", file.FileInfo)
+			}
+		}
+
+		// Ranges within and across chunks
+		ranges := []backblaze.FileRange{
+			{Start: 0, End: 0},
+			{Start: 10, End: 20},
+			{Start: chunkSize - 2, End: chunkSize + 2},
+			{Start: chunkSize, End: 2*chunkSize - 1},
+			{Start: 5, End: 3*chunkSize + 1},
+			{Start: 3 * chunkSize, End: 1 << 40},
+		}
+		for _, r := range ranges {
+			r := r
+			end := r.End + 1
+			if end > int64(len(content)) {
+				end = int64(len(content))
+			}
+			file, data := read(bucket.DownloadFileRangeByName("file.txt", &r))
+			if !bytes.Equal(data, content[r.Start:end]) || file.ContentLength != end-r.Start {
+				T.Errorf("Unexpected content for range %d-%d, saw %d bytes with length %d", r.Start, r.End, len(data), file.ContentLength)
+			}
+		}
+		if _, _, err := bucket.DownloadFileRangeByName("file.txt", &backblaze.FileRange{Start: int64(len(content)), End: 1 << 40}); !errors.Is(err, backblaze.ErrRangeNotSatisfiable) {
+			T.Errorf("Expected unsatisfiable range, saw %v", err)
+		}
+
+		// The stored content is encrypted, and can't be decrypted with another key
+		_, stored := read(raw.DownloadFileByName("file.txt"))
+		if int64(len(stored)) != encryptedSize(int64(len(content))) || bytes.Contains(stored, content[:64]) {
+			T.Errorf("Expected stored content to be encrypted")
+		}
+		other, err := NewBucket(raw, bytes.Repeat([]byte{1}, 32))
+		if err != nil {
+			T.Fatal(err)
+		}
+		if _, _, err := other.DownloadFileByName("file.txt"); !errors.Is(err, ErrDecryptionFailed) {
+			T.Errorf("Expected decryption with the wrong key to fail, saw %v", err)
+		}
+
+		// Large files are encrypted in parts
+		large, err := bucket.UploadLargeFile("large.bin", "", nil, bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			T.Fatal(err)
+		}
+		if large.ContentLength != int64(len(content)) {
+			T.Errorf("Unexpected large file length %d", large.ContentLength)
+		}
+		if _, data := read(bucket.DownloadFileByName("large.bin")); !bytes.Equal(data, content) {
+			T.Errorf("Unexpected large file content")
+		}
+	}
+}
+
+func TestTampering(T *testing.T) {
+	raw, closeServer := newBucket(T, backblaze.ProtocolNative)
+	defer closeServer()
+
+	bucket, err := NewBucket(raw, testKey)
+	if err != nil {
+		T.Fatal(err)
+	}
+	read := b2test.ReadDownload(T)
+	content := bytes.Repeat([]byte("0123456789abcdef"), chunkSize/8)
+	if _, err := bucket.UploadFile("file", nil, bytes.NewReader(content)); err != nil {
+		T.Fatal(err)
+	}
+	stored, err := raw.Stat(context.Background(), "file")
+	if err != nil {
+		T.Fatal(err)
+	}
+	_, encrypted := read(raw.DownloadFileByName("file"))
+
+	// Replace the stored content, keeping the data key
+	tamper := func(name string, modified []byte) {
+		T.Helper()
+		if _, err := raw.UploadTypedFile(name, stored.ContentType, stored.FileInfo, bytes.NewReader(modified)); err != nil {
+			T.Fatal(err)
+		}
+	}
+	flipped := append([]byte(nil), encrypted...)
+	flipped[100] ^= 1
+	tamper("flipped", flipped)
+	tamper("truncated", encrypted[:encryptedChunkSize])
+	tamper("empty", nil)
+	tamper("swapped", append(append([]byte(nil), encrypted[encryptedChunkSize:]...), encrypted[:encryptedChunkSize]...))
+	tamper("extended", append(append([]byte(nil), encrypted...), encrypted[:encryptedChunkSize]...))
+
+	for _, name := range []string{"flipped", "truncated", "empty", "swapped", "extended"} {
+		_, reader, err := bucket.DownloadFileByName(name)
+		if err == nil {
+			_, err = ioutil.ReadAll(reader)
+			reader.Close()
+		}
+		if !errors.Is(err, ErrDecryptionFailed) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			T.Errorf("Expected %s content to fail to decrypt, saw %v", name, err)
+		}
+	}
+
+	// Ranges of unmodified chunks can still be read
+	file, data := read(bucket.DownloadFileRangeByName("flipped", &backblaze.FileRange{Start: chunkSize, End: chunkSize + 9}))
+	if string(data) != "0123456789" || file.ContentLength != 10 {
+		T.Errorf("Unexpected range of modified file %q", data)
+	}
+
+	// Ranges ending at the last chunk fail if the file was truncated or extended
+	file, data = read(bucket.DownloadFileRangeByName("file", &backblaze.FileRange{Start: chunkSize, End: 2*chunkSize - 1}))
+	if !bytes.Equal(data, content[chunkSize:]) || file.ContentLength != chunkSize {
+		T.Errorf("Unexpected final range of %d bytes", len(data))
+	}
+	ranges := map[string]backblaze.FileRange{
+		"truncated": {Start: 0, End: 9},
+		"extended":  {Start: chunkSize, End: 2*chunkSize - 1},
+	}
+	for name, r := range ranges {
+		r := r
+		_, reader, err := bucket.DownloadFileRangeByName(name, &r)
+		if err == nil {
+			_, err = ioutil.ReadAll(reader)
+			reader.Close()
+		}
+		if !errors.Is(err, ErrDecryptionFailed) {
+			T.Errorf("Expected range of %s content to fail to decrypt, saw %v", name, err)
+		}
+	}
+
+	// Content isn't bound to its name, so a file copied to another name in
+	// the bucket decrypts under the new name
+	if _, err := raw.Copy(context.Background(), "file", "copied"); err != nil {
+		T.Fatal(err)
+	}
+	if _, data := read(bucket.DownloadFileByName("copied")); !bytes.Equal(data, content) {
+		T.Errorf("Expected copied file to decrypt, saw %d bytes", len(data))
+	}
+
+	if _, err := raw.UploadFile("plain", nil, strings.NewReader("plain")); err != nil {
+		T.Fatal(err)
+	}
+	if _, _, err := bucket.DownloadFileByName("plain"); !errors.Is(err, ErrNotEncrypted) {
+		T.Errorf("Expected unencrypted file to be rejected, saw %v", err)
+	}
+}
+
+func TestEncryptedNames(T *testing.T) {
+	for _, protocol := range []backblaze.Protocol{backblaze.ProtocolNative, backblaze.ProtocolS3} {
+		raw, closeServer := newBucket(T, protocol)
+		defer closeServer()
+
+		bucket, err := NewBucket(raw, testKey)
+		if err != nil {
+			T.Fatal(err)
+		}
+		read := b2test.ReadDownload(T)
+		bucket.EncryptNames = true
+		ctx := context.Background()
+
+		for _, name := range []string{"docs/a.txt", "docs/about.html", "docs/b.txt", "docs/sub/c.txt", "top.txt"} {
+			if _, err := bucket.Put(ctx, name, "", nil, strings.NewReader(name)); err != nil {
+				T.Fatal(err)
+			}
+		}
+		if _, err := raw.UploadFile("docs/unencrypted", nil, strings.NewReader("")); err != nil {
+			T.Fatal(err)
+		}
+
+		list := func(prefix, delimiter string) string {
+			T.Helper()
+			var names []string
+			err := bucket.List(ctx, prefix, delimiter, func(file *backblaze.File) error {
+				names = append(names, file.Name)
+				return nil
+			})
+			if err != nil {
+				T.Fatal(err)
+			}
+			return strings.Join(names, " ")
+		}
+		if listed := list("", "/"); listed != "docs/ top.txt" {
+			T.Errorf("Unexpected root listing %s", listed)
+		}
+		if listed := list("docs/", "/"); listed != "docs/a.txt docs/about.html docs/b.txt docs/sub/" {
+			T.Errorf("Unexpected folder listing %s", listed)
+		}
+		if listed := list("docs/a", ""); listed != "docs/a.txt docs/about.html" {
+			T.Errorf("Unexpected prefix listing %s", listed)
+		}
+		if listed := list("", ""); listed != "docs/a.txt docs/about.html docs/b.txt docs/sub/c.txt top.txt" {
+			T.Errorf("Unexpected listing %s", listed)
+		}
+
+		file, err := bucket.Stat(ctx, "docs/about.html")
+		if err != nil || file.Name != "docs/about.html" || !strings.HasPrefix(file.ContentType, "text/html") || file.ContentLength != 15 {
+			T.Errorf("Unexpected file %+v %v", file, err)
+		}
+		if _, data := read(bucket.Get(ctx, "docs/sub/c.txt")); string(data) != "docs/sub/c.txt" {
+			T.Errorf("Unexpected content %q", data)
+		}
+
+		// Copied files can be decrypted
+		if copied, err := bucket.Copy(ctx, "top.txt", "docs/copy.txt"); err != nil || copied.Name != "docs/copy.txt" {
+			T.Fatalf("Unexpected copy %+v %v", copied, err)
+		}
+		if _, data := read(bucket.Get(ctx, "docs/copy.txt")); string(data) != "top.txt" {
+			T.Errorf("Unexpected copied content %q", data)
+		}
+		if err := bucket.Remove(ctx, "docs/copy.txt"); err != nil {
+			T.Fatal(err)
+		}
+		if _, err := bucket.HideFile("top.txt"); err != nil {
+			T.Fatal(err)
+		}
+		if _, err := bucket.Stat(ctx, "top.txt"); !errors.Is(err, backblaze.ErrNotFound) {
+			T.Errorf("Expected hidden file to be not found, saw %v", err)
+		}
+
+		// Stored names are encrypted, keeping the folder structure
+		response, err := raw.ListFileNames("", 100)
+		if err != nil {
+			T.Fatal(err)
+		}
+		for _, f := range response.Files {
+			if f.Name == "docs/unencrypted" {
+				continue
+			}
+			if strings.Contains(f.Name, "docs") || strings.Contains(f.Name, ".txt") || strings.Count(f.Name, "/") == 0 {
+				T.Errorf("Expected stored name to be encrypted, saw %s", f.Name)
+			}
+		}
+	}
+}
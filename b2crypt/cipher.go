@@ -0,0 +1,302 @@
+package b2crypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+const (
+	// The size of the plaintext in each encrypted chunk, except the last
+	chunkSize = 64 * 1024
+
+	// The size of the authentication tag added to each chunk
+	tagSize = 16
+
+	encryptedChunkSize = chunkSize + tagSize
+
+	// Chunk nonces are the random prefix of a file, a chunk counter and a
+	// flag marking the final chunk
+	noncePrefixSize = 7
+)
+
+// Returns the size of the encrypted content of a file. Every file has at
+// least one chunk, so that truncating it to nothing can be detected.
+func encryptedSize(size int64) int64 {
+	chunks := (size + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return size + chunks*tagSize
+}
+
+// Returns the size of the plaintext in a sequence of encrypted chunks
+func plaintextSize(size int64) int64 {
+	chunks := (size + encryptedChunkSize - 1) / encryptedChunkSize
+	return size - chunks*tagSize
+}
+
+// Derives a key for one purpose from the master key
+func deriveKey(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte("b2crypt " + purpose))
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypts the chunks of one file with its data key
+type contentCipher struct {
+	aead   cipher.AEAD
+	prefix []byte
+}
+
+func (c *contentCipher) nonce(index int64, final bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, c.prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], uint32(index))
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+func (c *contentCipher) seal(dst, plaintext []byte, index int64, final bool) []byte {
+	return c.aead.Seal(dst, c.nonce(index, final), plaintext, nil)
+}
+
+func (c *contentCipher) open(dst, ciphertext []byte, index int64, final bool) ([]byte, error) {
+	plaintext, err := c.aead.Open(dst, c.nonce(index, final), ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d: %w", index, ErrDecryptionFailed)
+	}
+	return plaintext, nil
+}
+
+// Encrypts content as it is read
+type encryptReader struct {
+	cipher *contentCipher
+	src    *bufio.Reader
+	buf    []byte
+	sealed []byte
+	out    []byte
+	index  int64
+	done   bool
+}
+
+func newEncryptReader(c *contentCipher, src io.Reader) *encryptReader {
+	return &encryptReader{
+		cipher: c,
+		src:    bufio.NewReaderSize(src, chunkSize),
+		buf:    make([]byte, chunkSize),
+		sealed: make([]byte, 0, encryptedChunkSize),
+	}
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		// A chunk is final if the content ends at or before its end
+		n, err := io.ReadFull(r.src, r.buf)
+		switch err {
+		case nil:
+			if _, err := r.src.Peek(1); err == io.EOF {
+				r.done = true
+			} else if err != nil {
+				return 0, err
+			}
+		case io.EOF, io.ErrUnexpectedEOF:
+			r.done = true
+		default:
+			return 0, err
+		}
+
+		r.sealed = r.cipher.seal(r.sealed[:0], r.buf[:n], r.index, r.done)
+		r.out = r.sealed
+		r.index++
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// Encrypts content read from any offset of a file of known size, so that
+// large files can be uploaded in parts
+type encryptReaderAt struct {
+	cipher *contentCipher
+	src    io.ReaderAt
+	size   int64
+
+	// The most recently encrypted chunk, which is likely to be read again
+	mutex       sync.Mutex
+	cachedIndex int64
+	cached      []byte
+}
+
+func newEncryptReaderAt(c *contentCipher, src io.ReaderAt, size int64) *encryptReaderAt {
+	return &encryptReaderAt{cipher: c, src: src, size: size, cachedIndex: -1}
+}
+
+// Returns an encrypted chunk
+func (r *encryptReaderAt) chunk(index int64) ([]byte, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if index == r.cachedIndex {
+		return r.cached, nil
+	}
+
+	start := index * chunkSize
+	length := r.size - start
+	if length > chunkSize {
+		length = chunkSize
+	}
+	plaintext := make([]byte, length)
+	if _, err := r.src.ReadAt(plaintext, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	final := start+length >= r.size
+	r.cached = r.cipher.seal(r.cached[:0:0], plaintext, index, final)
+	r.cachedIndex = index
+	return r.cached, nil
+}
+
+func (r *encryptReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	size := encryptedSize(r.size)
+	n := 0
+	for n < len(p) {
+		if off >= size {
+			return n, io.EOF
+		}
+		chunk, err := r.chunk(off / encryptedChunkSize)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], chunk[off%encryptedChunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// Decrypts content as it is read, starting from a chunk of the file. The
+// first skip bytes of plaintext are discarded.
+//
+// A chunk must be marked as final if and only if nothing follows it in the
+// content. If partial is true, the content is a range of the file, and at
+// most remaining bytes are returned. The range should extend at least one byte
+// past the chunks which are needed, so that it ends with a final chunk only
+// if the file does.
+type decryptReader struct {
+	cipher    *contentCipher
+	body      io.ReadCloser
+	src       *bufio.Reader
+	buf       []byte
+	plain     []byte
+	out       []byte
+	index     int64
+	partial   bool
+	skip      int64
+	remaining int64
+	final     bool
+}
+
+func newDecryptReader(c *contentCipher, body io.ReadCloser, index int64, partial bool, skip, remaining int64) *decryptReader {
+	return &decryptReader{
+		cipher:    c,
+		body:      body,
+		src:       bufio.NewReaderSize(body, encryptedChunkSize),
+		buf:       make([]byte, encryptedChunkSize),
+		plain:     make([]byte, 0, chunkSize),
+		index:     index,
+		partial:   partial,
+		skip:      skip,
+		remaining: remaining,
+	}
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if r.partial && r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	for len(r.out) == 0 {
+		if r.final {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.partial && int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	r.remaining -= int64(n)
+	return n, nil
+}
+
+// Decrypts the next chunk
+func (r *decryptReader) next() error {
+	n, err := io.ReadFull(r.src, r.buf)
+	last := false
+	switch err {
+	case nil:
+		if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		// The content ended without a final chunk
+		return fmt.Errorf("chunk %d: %w", r.index, io.ErrUnexpectedEOF)
+	default:
+		return err
+	}
+	if n < tagSize {
+		return fmt.Errorf("chunk %d: %w", r.index, io.ErrUnexpectedEOF)
+	}
+
+	out, err := r.cipher.open(r.plain[:0], r.buf[:n], r.index, last)
+	if err != nil {
+		return err
+	}
+	r.final = last
+	r.index++
+
+	if r.skip > 0 {
+		if r.skip > int64(len(out)) {
+			return fmt.Errorf("range starts after the end of the file: %w", backblaze.ErrRangeNotSatisfiable)
+		}
+		out = out[r.skip:]
+		r.skip = 0
+	}
+	r.out = out
+	return nil
+}
+
+func (r *decryptReader) Close() error {
+	return r.body.Close()
+}
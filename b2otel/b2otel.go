@@ -0,0 +1,153 @@
+// Package b2otel reports the requests made by a B2 client to OpenTelemetry.
+//
+// Each request made by the client is recorded as a span, and counted in the
+// following metrics:
+//
+//	b2.client.requests          number of requests, by API, bucket and status
+//	b2.client.request.duration  request duration in seconds, including reading the response body
+//	b2.client.bytes.sent        bytes sent in request bodies
+//	b2.client.bytes.received    bytes received in response bodies
+//
+// The B2 client does not accept a context, so spans are created as new root
+// spans rather than as children of the caller's span.
+package b2otel // import "gopkg.in/kothar/go-backblaze.v0/b2otel"
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+const instrumentationName = "gopkg.in/kothar/go-backblaze.v0/b2otel"
+
+// Attribute keys recorded on spans and metrics
+const (
+	APIKey    = attribute.Key("b2.api")
+	BucketKey = attribute.Key("b2.bucket_id")
+	StatusKey = attribute.Key("http.response.status_code")
+)
+
+// Hook implements backblaze.RequestHook, creating spans and recording metrics
+// for each request made by a B2 client.
+type Hook struct {
+	tracer trace.Tracer
+
+	requests      metric.Int64Counter
+	duration      metric.Float64Histogram
+	bytesSent     metric.Int64Counter
+	bytesReceived metric.Int64Counter
+
+	// Spans which have been started but not finished, keyed by *backblaze.RequestInfo
+	spans sync.Map
+}
+
+// NewHook creates a hook which reports to the given tracer and meter providers.
+// Either provider may be nil, in which case the global provider is used.
+func NewHook(tp trace.TracerProvider, mp metric.MeterProvider) (*Hook, error) {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+
+	h := &Hook{
+		tracer: tp.Tracer(instrumentationName),
+	}
+
+	meter := mp.Meter(instrumentationName)
+
+	var err error
+	if h.requests, err = meter.Int64Counter("b2.client.requests",
+		metric.WithDescription("Number of requests made to the B2 service"),
+		metric.WithUnit("{request}")); err != nil {
+		return nil, err
+	}
+	if h.duration, err = meter.Float64Histogram("b2.client.request.duration",
+		metric.WithDescription("Duration of requests made to the B2 service"),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if h.bytesSent, err = meter.Int64Counter("b2.client.bytes.sent",
+		metric.WithDescription("Bytes sent to the B2 service"),
+		metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+	if h.bytesReceived, err = meter.Int64Counter("b2.client.bytes.received",
+		metric.WithDescription("Bytes received from the B2 service"),
+		metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Instrument creates a hook using the global providers and installs it on the client
+func Instrument(c *backblaze.B2) error {
+	h, err := NewHook(nil, nil)
+	if err != nil {
+		return err
+	}
+	c.Hook = h
+	return nil
+}
+
+// RequestStarted starts a span for the request
+func (h *Hook) RequestStarted(info *backblaze.RequestInfo) {
+	attrs := []attribute.KeyValue{APIKey.String(info.API)}
+	if info.Bucket != "" {
+		attrs = append(attrs, BucketKey.String(info.Bucket))
+	}
+
+	_, span := h.tracer.Start(context.Background(), info.API,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(info.Start),
+		trace.WithAttributes(attrs...))
+	h.spans.Store(info, span)
+}
+
+// RequestFinished ends the span for the request and records its metrics
+func (h *Hook) RequestFinished(info *backblaze.RequestInfo) {
+	attrs := []attribute.KeyValue{
+		APIKey.String(info.API),
+		StatusKey.Int(info.Status),
+	}
+	if info.Bucket != "" {
+		attrs = append(attrs, BucketKey.String(info.Bucket))
+	}
+
+	ctx := context.Background()
+	set := metric.WithAttributes(attrs...)
+	h.requests.Add(ctx, 1, set)
+	h.duration.Record(ctx, info.Duration.Seconds(), set)
+	h.bytesSent.Add(ctx, info.BytesSent, set)
+	h.bytesReceived.Add(ctx, info.BytesReceived, set)
+
+	s, ok := h.spans.LoadAndDelete(info)
+	if !ok {
+		return
+	}
+	span := s.(trace.Span)
+
+	span.SetAttributes(
+		StatusKey.Int(info.Status),
+		attribute.Int64("b2.bytes_sent", info.BytesSent),
+		attribute.Int64("b2.bytes_received", info.BytesReceived))
+
+	switch {
+	case info.Err != nil:
+		span.RecordError(info.Err)
+		span.SetStatus(codes.Error, info.Err.Error())
+	case info.Status >= 400:
+		span.SetStatus(codes.Error, "")
+	}
+
+	span.End(trace.WithTimestamp(info.Start.Add(info.Duration)))
+}
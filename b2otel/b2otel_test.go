@@ -0,0 +1,87 @@
+package b2otel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+func TestHook(T *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	hook, err := NewHook(tp, mp)
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	start := time.Now()
+	requests := []*backblaze.RequestInfo{
+		{API: "b2_upload_file", Bucket: "bucketid", Status: 200, BytesSent: 100, Start: start, Duration: time.Second},
+		{API: "b2_download_file_by_id", Status: 404, BytesReceived: 50, Start: start, Duration: time.Second},
+		{API: "b2_list_buckets", Start: start, Err: errors.New("connection refused")},
+	}
+	for _, info := range requests {
+		hook.RequestStarted(info)
+		hook.RequestFinished(info)
+	}
+
+	// Check spans
+	spans := exporter.GetSpans()
+	if len(spans) != len(requests) {
+		T.Fatalf("Expected %d spans, saw %d", len(requests), len(spans))
+	}
+	for i, span := range spans {
+		if span.Name != requests[i].API {
+			T.Errorf("Expected span %d to be named %q, saw %q", i, requests[i].API, span.Name)
+		}
+	}
+	if spans[0].Status.Code != codes.Unset {
+		T.Errorf("Expected successful request to leave span status unset, saw %v", spans[0].Status.Code)
+	}
+	if d := spans[0].EndTime.Sub(spans[0].StartTime); d != time.Second {
+		T.Errorf("Expected span duration of 1s, saw %v", d)
+	}
+	if spans[1].Status.Code != codes.Error || spans[2].Status.Code != codes.Error {
+		T.Errorf("Expected failed requests to set error status")
+	}
+
+	// Check metrics
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		T.Fatal(err)
+	}
+
+	totals := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok {
+				for _, dp := range sum.DataPoints {
+					totals[m.Name] += dp.Value
+				}
+			}
+		}
+	}
+
+	expected := map[string]int64{
+		"b2.client.requests":       3,
+		"b2.client.bytes.sent":     100,
+		"b2.client.bytes.received": 50,
+	}
+	for name, value := range expected {
+		if totals[name] != value {
+			T.Errorf("Expected %s to be %d, saw %d", name, value, totals[name])
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package b2storage provides implementations of backblaze.Storage which don't
+// use the network, so that code written against a bucket can be unit tested,
+// or run against local files.
+//
+//	var storage backblaze.Storage = bucket
+//
+//	// In tests
+//	storage = b2storage.NewMemory()
+//
+// Files are listed and returned in the same form as by a bucket. Ranges are
+// limited to the end of the file, and errors for missing files and ranges
+// starting after the end of a file match backblaze.ErrNotFound and
+// backblaze.ErrRangeNotSatisfiable using errors.Is.
+package b2storage // import "gopkg.in/kothar/go-backblaze.v0/b2storage"
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+var (
+	_ backblaze.Storage = (*backblaze.Bucket)(nil)
+	_ backblaze.Storage = (*Memory)(nil)
+	_ backblaze.Storage = (*Local)(nil)
+)
+
+func notFound(name string) error {
+	return fmt.Errorf("file %q: %w", name, backblaze.ErrNotFound)
+}
+
+// Checks that a name can be stored as a file, without empty or relative
+// folder names
+func checkName(name string) error {
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid file name %q: %w", name, backblaze.ErrBadRequest)
+		}
+	}
+	return nil
+}
+
+// Chooses a content type from a file name if it is not given, as B2 does
+// for b2/x-auto
+func autoContentType(value, name string) string {
+	if value != "b2/x-auto" && value != "" {
+		return value
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name))); err == nil {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// Returns the start and end offsets of a range of a file. The range is
+// limited to the end of the file.
+func rangeOf(name string, size int64, fileRange *backblaze.FileRange) (int64, int64, error) {
+	if fileRange == nil {
+		return 0, size, nil
+	}
+	if fileRange.Start < 0 || fileRange.End < fileRange.Start {
+		return 0, 0, fmt.Errorf("file %q: invalid range %d-%d: %w", name, fileRange.Start, fileRange.End, backblaze.ErrBadRequest)
+	}
+	if fileRange.Start >= size {
+		return 0, 0, fmt.Errorf("file %q: range %d-%d: %w", name, fileRange.Start, fileRange.End, backblaze.ErrRangeNotSatisfiable)
+	}
+	end := fileRange.End + 1
+	if end > size {
+		end = size
+	}
+	return fileRange.Start, end, nil
+}
+
+// Lists files with a prefix from a sorted list of names, as B2 does. When a
+// delimiter is given, the files in each folder are replaced by one folder.
+func list(names []string, prefix, delimiter string, stat func(name string) (*backblaze.File, error), fn func(file *backblaze.File) error) error {
+	folder := ""
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				if f := name[:len(prefix)+i+len(delimiter)]; f != folder {
+					folder = f
+					if err := fn(&backblaze.File{Name: folder, Action: backblaze.Folder}); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+
+		file, err := stat(name)
+		if err != nil {
+			return err
+		}
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
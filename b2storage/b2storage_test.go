@@ -0,0 +1,192 @@
+package b2storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+	"gopkg.in/kothar/go-backblaze.v0/b2test"
+)
+
+func TestMemory(T *testing.T) {
+	testStorage(T, NewMemory(), true)
+}
+
+func TestLocal(T *testing.T) {
+	dir, err := ioutil.TempDir("", "b2storage")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testStorage(T, NewLocal(dir), false)
+
+	if _, err := NewLocal(dir).Put(context.Background(), "../escape", "", nil, strings.NewReader("")); !errors.Is(err, backblaze.ErrBadRequest) {
+		T.Errorf("Expected name outside directory to be rejected, saw %v", err)
+	}
+	if entries, err := ioutil.ReadDir(dir); err != nil || len(entries) != 0 {
+		T.Errorf("Expected removed folders and temporary files to be deleted, saw %v", entries)
+	}
+}
+
+func TestBucket(T *testing.T) {
+	for _, protocol := range []backblaze.Protocol{backblaze.ProtocolNative, backblaze.ProtocolS3} {
+		server := b2test.NewServer()
+		defer server.Close()
+
+		client := server.NewClient()
+		client.Protocol = protocol
+		bucket := b2test.CreateBucket(T, client, "storage-bucket")
+		testStorage(T, bucket, true)
+
+		// Hidden files are removed with all of their versions
+		ctx := context.Background()
+		if _, err := bucket.Put(ctx, "hidden", "", nil, strings.NewReader("hidden")); err != nil {
+			T.Fatal(err)
+		}
+		if _, err := bucket.HideFile("hidden"); err != nil {
+			T.Fatal(err)
+		}
+		if err := bucket.Remove(ctx, "hidden"); err != nil {
+			T.Errorf("Expected hidden file to be removed, saw %v", err)
+		}
+		if versions, err := bucket.ListFileVersions("", "", 100); err != nil || len(versions.Files) != 0 {
+			T.Errorf("Expected all versions to be removed, saw %+v %v", versions, err)
+		}
+	}
+}
+
+// Runs the same operations against each implementation of Storage
+func testStorage(T *testing.T, storage backblaze.Storage, keepsInfo bool) {
+	ctx := context.Background()
+
+	read := func(file *backblaze.File, reader io.ReadCloser, err error) (*backblaze.File, string) {
+		T.Helper()
+		if err != nil {
+			T.Fatal(err)
+		}
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			T.Fatal(err)
+		}
+		return file, string(data)
+	}
+	names := func(prefix, delimiter string) []string {
+		T.Helper()
+		var names []string
+		err := storage.List(ctx, prefix, delimiter, func(file *backblaze.File) error {
+			names = append(names, file.Name)
+			return nil
+		})
+		if err != nil {
+			T.Fatal(err)
+		}
+		return names
+	}
+
+	// Store some files, replacing one of them
+	meta := map[string]string{"src_last_modified_millis": "1234"}
+	for _, name := range []string{"a.txt", "dir/b", "dir/sub/c", "dir-d"} {
+		if _, err := storage.Put(ctx, name, "", meta, strings.NewReader("old "+name)); err != nil {
+			T.Fatal(err)
+		}
+	}
+	put, err := storage.Put(ctx, "a.txt", "", meta, bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if put.Name != "a.txt" || put.ContentLength != 11 || !strings.HasPrefix(put.ContentType, "text/plain") {
+		T.Errorf("Unexpected file stored: %+v", put)
+	}
+
+	// Read it back
+	file, data := read(storage.Get(ctx, "a.txt"))
+	if data != "hello world" || file.ID != put.ID || file.ContentLength != 11 {
+		T.Errorf("Unexpected file %+v with content %q", file, data)
+	}
+	if keepsInfo && file.FileInfo["src_last_modified_millis"] != "1234" {
+		T.Errorf("Expected file info to be stored, saw %v", file.FileInfo)
+	}
+	file, data = read(storage.GetRange(ctx, "a.txt", &backblaze.FileRange{Start: 6, End: 100}))
+	if data != "world" || file.ContentLength != 5 {
+		T.Errorf("Unexpected range %q of %+v", data, file)
+	}
+	if _, _, err := storage.GetRange(ctx, "a.txt", &backblaze.FileRange{Start: 11, End: 20}); !errors.Is(err, backblaze.ErrRangeNotSatisfiable) {
+		T.Errorf("Expected range after the end of the file to fail, saw %v", err)
+	}
+	if stat, err := storage.Stat(ctx, "a.txt"); err != nil || stat.ID != put.ID || stat.ContentLength != 11 {
+		T.Errorf("Unexpected stat %+v %v", stat, err)
+	}
+
+	// Missing files
+	if _, err := storage.Stat(ctx, "a"); !errors.Is(err, backblaze.ErrNotFound) {
+		T.Errorf("Expected stat of missing file to fail, saw %v", err)
+	}
+	if _, _, err := storage.Get(ctx, "missing"); !errors.Is(err, backblaze.ErrNotFound) {
+		T.Errorf("Expected get of missing file to fail, saw %v", err)
+	}
+	if _, err := storage.Copy(ctx, "missing", "copy"); !errors.Is(err, backblaze.ErrNotFound) {
+		T.Errorf("Expected copy of missing file to fail, saw %v", err)
+	}
+	if err := storage.Remove(ctx, "dir"); !errors.Is(err, backblaze.ErrNotFound) {
+		T.Errorf("Expected remove of a folder to fail, saw %v", err)
+	}
+
+	// Listing
+	if listed := strings.Join(names("", ""), " "); listed != "a.txt dir-d dir/b dir/sub/c" {
+		T.Errorf("Unexpected listing %s", listed)
+	}
+	if listed := strings.Join(names("", "/"), " "); listed != "a.txt dir-d dir/" {
+		T.Errorf("Unexpected folder listing %s", listed)
+	}
+	if listed := strings.Join(names("dir/", "/"), " "); listed != "dir/b dir/sub/" {
+		T.Errorf("Unexpected subfolder listing %s", listed)
+	}
+	stop := errors.New("stop")
+	count := 0
+	err = storage.List(ctx, "", "", func(file *backblaze.File) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		T.Errorf("Expected listing to stop after an error, saw %v after %d files", err, count)
+	}
+
+	// Copy and remove
+	copied, err := storage.Copy(ctx, "a.txt", "dir/copy.txt")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if copied.Name != "dir/copy.txt" || !strings.HasPrefix(copied.ContentType, "text/plain") {
+		T.Errorf("Unexpected copy %+v", copied)
+	}
+	file, data = read(storage.Get(ctx, "dir/copy.txt"))
+	if data != "hello world" || keepsInfo && file.FileInfo["src_last_modified_millis"] != "1234" {
+		T.Errorf("Unexpected copy %+v with content %q", file, data)
+	}
+	for _, name := range []string{"a.txt", "dir/b", "dir/sub/c", "dir-d", "dir/copy.txt"} {
+		if err := storage.Remove(ctx, name); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if listed := names("", ""); len(listed) != 0 {
+		T.Errorf("Expected all files to be removed, saw %v", listed)
+	}
+
+	// Cancelled contexts
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := storage.Put(cancelled, "cancelled", "", nil, strings.NewReader("")); !errors.Is(err, context.Canceled) {
+		T.Errorf("Expected put to be cancelled, saw %v", err)
+	}
+	if err := storage.List(cancelled, "", "", func(*backblaze.File) error { return nil }); !errors.Is(err, context.Canceled) {
+		T.Errorf("Expected list to be cancelled, saw %v", err)
+	}
+}
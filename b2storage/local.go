@@ -0,0 +1,233 @@
+package b2storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// Local is a Storage which keeps files in a directory on the local
+// filesystem, with folders stored as subdirectories.
+//
+// Only the content of files is stored. Content types are chosen from the
+// extension of file names, file info is discarded, and files are identified
+// by their names.
+type Local struct {
+	dir string
+}
+
+// NewLocal creates a store of the files in a directory. The directory is
+// created when the first file is stored.
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// Returns the path of a file on disk
+func (l *Local) path(name string) string {
+	return filepath.Join(l.dir, filepath.FromSlash(name))
+}
+
+// Returns a file describing a file on disk
+func (l *Local) file(name string, info os.FileInfo) *backblaze.File {
+	return &backblaze.File{
+		ID:              name,
+		Name:            name,
+		ContentLength:   info.Size(),
+		ContentType:     autoContentType("", name),
+		FileInfo:        map[string]string{},
+		Action:          backblaze.Upload,
+		UploadTimestamp: info.ModTime().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+func (l *Local) stat(name string) (os.FileInfo, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(l.path(name))
+	if os.IsNotExist(err) || err == nil && !info.Mode().IsRegular() {
+		return nil, notFound(name)
+	}
+	return info, err
+}
+
+// Put writes a file to a temporary file, which is renamed once it is
+// complete. Directories are created as needed.
+func (l *Local) Put(ctx context.Context, name, contentType string, meta map[string]string, content io.Reader) (*backblaze.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	filePath := l.path(name)
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, err
+	}
+	temp, err := ioutil.TempFile(dir, "."+path.Base(name)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = io.Copy(temp, content)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(temp.Name(), filePath); err != nil {
+		return nil, err
+	}
+	info, err := l.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return l.file(name, info), nil
+}
+
+// Get opens a file
+func (l *Local) Get(ctx context.Context, name string) (*backblaze.File, io.ReadCloser, error) {
+	return l.GetRange(ctx, name, nil)
+}
+
+// GetRange opens a file, positioned at the start of the range
+func (l *Local) GetRange(ctx context.Context, name string, fileRange *backblaze.FileRange) (*backblaze.File, io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := checkName(name); err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(l.path(name))
+	if os.IsNotExist(err) {
+		return nil, nil, notFound(name)
+	} else if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = notFound(name)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	start, end, err := rangeOf(name, info.Size(), fileRange)
+	if err == nil {
+		_, err = f.Seek(start, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	file := l.file(name, info)
+	file.ContentLength = end - start
+	return file, &limitedFile{io.LimitReader(f, end-start), f}, nil
+}
+
+// Reads part of a file
+type limitedFile struct {
+	io.Reader
+	io.Closer
+}
+
+// Stat returns a file
+func (l *Local) Stat(ctx context.Context, name string) (*backblaze.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	info, err := l.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return l.file(name, info), nil
+}
+
+// List walks the directory to find files, then lists them in name order
+func (l *Local) List(ctx context.Context, prefix, delimiter string, fn func(file *backblaze.File) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var names []string
+	infos := make(map[string]os.FileInfo)
+	err := filepath.Walk(l.dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if filePath == l.dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, filePath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		names = append(names, name)
+		infos[name] = info
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Directories are walked in order of their own names, which is not
+	// the order of the names of the files they contain
+	sort.Strings(names)
+
+	return list(names, prefix, delimiter, func(name string) (*backblaze.File, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return l.file(name, infos[name]), nil
+	}, fn)
+}
+
+// Remove deletes a file, and any directories left empty
+func (l *Local) Remove(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := l.stat(name); err != nil {
+		return err
+	}
+	if err := os.Remove(l.path(name)); err != nil {
+		return err
+	}
+
+	// Folders only exist while they contain files
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if os.Remove(l.path(dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Copy copies the content of a file
+func (l *Local) Copy(ctx context.Context, source, destination string) (*backblaze.File, error) {
+	_, reader, err := l.Get(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return l.Put(ctx, destination, "", nil, reader)
+}
@@ -0,0 +1,194 @@
+package b2storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// Memory is a Storage which keeps files in memory. It may be used by
+// multiple goroutines.
+type Memory struct {
+	mutex  sync.Mutex
+	files  map[string]*memoryFile
+	lastID int
+}
+
+type memoryFile struct {
+	file backblaze.File
+	data []byte
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{files: make(map[string]*memoryFile)}
+}
+
+// Returns a copy of a file, which the caller may modify
+func (f *memoryFile) copy() *backblaze.File {
+	file := f.file
+	file.FileInfo = make(map[string]string, len(f.file.FileInfo))
+	for k, v := range f.file.FileInfo {
+		file.FileInfo[k] = v
+	}
+	return &file
+}
+
+func (m *Memory) lookup(name string) (*memoryFile, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	f, ok := m.files[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	return f, nil
+}
+
+// Stores a file, given its content. File info keys are stored in lower
+// case, as they are by B2.
+func (m *Memory) store(name, contentType string, meta map[string]string, data []byte) *backblaze.File {
+	hash := sha1.Sum(data)
+	info := make(map[string]string, len(meta))
+	for k, v := range meta {
+		info[strings.ToLower(k)] = v
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.lastID++
+	f := &memoryFile{
+		file: backblaze.File{
+			ID:              strconv.Itoa(m.lastID),
+			Name:            name,
+			ContentLength:   int64(len(data)),
+			ContentSha1:     hex.EncodeToString(hash[:]),
+			ContentType:     contentType,
+			FileInfo:        info,
+			Action:          backblaze.Upload,
+			UploadTimestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		},
+		data: data,
+	}
+	m.files[name] = f
+	return f.copy()
+}
+
+// Put reads the content of a file into memory and stores it
+func (m *Memory) Put(ctx context.Context, name, contentType string, meta map[string]string, content io.Reader) (*backblaze.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	return m.store(name, autoContentType(contentType, name), meta, data), nil
+}
+
+// Get returns a file and its content
+func (m *Memory) Get(ctx context.Context, name string) (*backblaze.File, io.ReadCloser, error) {
+	return m.GetRange(ctx, name, nil)
+}
+
+// GetRange returns a file and part of its content
+func (m *Memory) GetRange(ctx context.Context, name string, fileRange *backblaze.FileRange) (*backblaze.File, io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	f, err := m.lookup(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	start, end, err := rangeOf(name, int64(len(f.data)), fileRange)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The content of a file is never modified, only replaced
+	file := f.copy()
+	file.ContentLength = end - start
+	return file, ioutil.NopCloser(bytes.NewReader(f.data[start:end])), nil
+}
+
+// Stat returns a file
+func (m *Memory) Stat(ctx context.Context, name string) (*backblaze.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f, err := m.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return f.copy(), nil
+}
+
+// List lists the files stored when it is called. The store may be modified
+// by fn.
+func (m *Memory) List(ctx context.Context, prefix, delimiter string, fn func(file *backblaze.File) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	files := make(map[string]*memoryFile, len(m.files))
+	names := make([]string, 0, len(m.files))
+	for name, f := range m.files {
+		files[name] = f
+		names = append(names, name)
+	}
+	m.mutex.Unlock()
+	sort.Strings(names)
+
+	return list(names, prefix, delimiter, func(name string) (*backblaze.File, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return files[name].copy(), nil
+	}, fn)
+}
+
+// Remove deletes a file
+func (m *Memory) Remove(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.files[name]; !ok {
+		return notFound(name)
+	}
+	delete(m.files, name)
+	return nil
+}
+
+// Copy stores a file with the content, content type and file info of another
+func (m *Memory) Copy(ctx context.Context, source, destination string) (*backblaze.File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := checkName(destination); err != nil {
+		return nil, err
+	}
+	f, err := m.lookup(source)
+	if err != nil {
+		return nil, err
+	}
+	return m.store(destination, f.file.ContentType, f.file.FileInfo, f.data), nil
+}
@@ -0,0 +1,177 @@
+package b2test
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A bucket and the versions of the files stored in it
+type bucket struct {
+	info  bucketInfo
+	names map[string][]*file // Versions of each file name, newest first
+}
+
+// Bucket names must be 6 to 50 letters, digits and hyphens
+var bucketNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]{6,50}$`)
+
+func (s *Server) createBucket(body []byte) (interface{}, *apiError) {
+	request := &createBucketRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	if err := s.checkAccount(request.AccountID); err != nil {
+		return nil, err
+	}
+	if !bucketNamePattern.MatchString(request.BucketName) || strings.HasPrefix(request.BucketName, "b2-") {
+		return nil, badRequest("Invalid bucket name %q", request.BucketName)
+	}
+	if err := checkBucketType(request.BucketType); err != nil {
+		return nil, err
+	}
+	for _, b := range s.buckets {
+		if b.info.BucketName == request.BucketName {
+			return nil, &apiError{http.StatusBadRequest, "duplicate_bucket_name", "Bucket name is already in use"}
+		}
+	}
+
+	b := &bucket{
+		info: bucketInfo{
+			AccountID:      s.AccountID,
+			BucketID:       s.newID(),
+			BucketName:     request.BucketName,
+			BucketType:     request.BucketType,
+			BucketInfo:     request.BucketInfo,
+			LifecycleRules: request.LifecycleRules,
+			Revision:       1,
+		},
+		names: make(map[string][]*file),
+	}
+	s.buckets[b.info.BucketID] = b
+	return b.response(), nil
+}
+
+func (s *Server) deleteBucket(body []byte) (interface{}, *apiError) {
+	request := &bucketRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	if err := s.checkAccount(request.AccountID); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(request.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.names) > 0 {
+		return nil, &apiError{http.StatusBadRequest, "cannot_delete_non_empty_bucket", "Bucket " + b.info.BucketName + " is not empty"}
+	}
+
+	delete(s.buckets, b.info.BucketID)
+	return b.response(), nil
+}
+
+func (s *Server) listBuckets(body []byte) (interface{}, *apiError) {
+	request := &listBucketsRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	if err := s.checkAccount(request.AccountID); err != nil {
+		return nil, err
+	}
+
+	response := &listBucketsResponse{Buckets: []bucketInfo{}}
+	for _, b := range s.buckets {
+		if request.BucketID != "" && b.info.BucketID != request.BucketID {
+			continue
+		}
+		if request.BucketName != "" && b.info.BucketName != request.BucketName {
+			continue
+		}
+		response.Buckets = append(response.Buckets, b.response())
+	}
+	sort.Slice(response.Buckets, func(i, j int) bool {
+		return response.Buckets[i].BucketName < response.Buckets[j].BucketName
+	})
+	return response, nil
+}
+
+func (s *Server) updateBucket(body []byte) (interface{}, *apiError) {
+	request := &updateBucketRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	if err := s.checkAccount(request.AccountID); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(request.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	if request.IfRevisionIs != 0 && request.IfRevisionIs != b.info.Revision {
+		return nil, &apiError{http.StatusConflict, "conflict", "Bucket revision has changed"}
+	}
+
+	if request.BucketType != "" {
+		if err := checkBucketType(request.BucketType); err != nil {
+			return nil, err
+		}
+		b.info.BucketType = request.BucketType
+	}
+	if request.BucketInfo != nil {
+		b.info.BucketInfo = *request.BucketInfo
+	}
+	if request.LifecycleRules != nil {
+		b.info.LifecycleRules = *request.LifecycleRules
+	}
+	b.info.Revision++
+	return b.response(), nil
+}
+
+// Returns the bucket with an ID. Must be called while holding the mutex.
+func (s *Server) bucket(bucketID string) (*bucket, *apiError) {
+	b, ok := s.buckets[bucketID]
+	if !ok {
+		return nil, badRequest("Invalid bucketId: %s", bucketID)
+	}
+	return b, nil
+}
+
+// Returns the bucket with a name. Must be called while holding the mutex.
+func (s *Server) bucketNamed(name string) *bucket {
+	for _, b := range s.buckets {
+		if b.info.BucketName == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// Checks the account ID given in a request
+func (s *Server) checkAccount(accountID string) *apiError {
+	if accountID != s.AccountID {
+		return unauthorized("unauthorized", "Invalid accountId: "+accountID)
+	}
+	return nil
+}
+
+func checkBucketType(bucketType string) *apiError {
+	switch bucketType {
+	case "allPublic", "allPrivate":
+		return nil
+	}
+	return badRequest("Invalid bucketType: %s", bucketType)
+}
+
+// Returns a copy of the bucket info with empty collections instead of nil
+func (b *bucket) response() bucketInfo {
+	info := b.info
+	if info.BucketInfo == nil {
+		info.BucketInfo = map[string]string{}
+	}
+	if info.LifecycleRules == nil {
+		info.LifecycleRules = []lifecycleRule{}
+	}
+	return info
+}
@@ -0,0 +1,60 @@
+package b2test
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// NewClient returns a client which makes requests to the server, using the
+// server's credentials
+func (s *Server) NewClient() *backblaze.B2 {
+	return &backblaze.B2{
+		Credentials: backblaze.Credentials{
+			AccountID:      s.AccountID,
+			ApplicationKey: s.ApplicationKey,
+		},
+		Host: s.URL,
+	}
+}
+
+// NewBucket creates a private bucket using a new client, failing the test if
+// it can't be created
+func (s *Server) NewBucket(t testing.TB, name string) *backblaze.Bucket {
+	t.Helper()
+	return CreateBucket(t, s.NewClient(), name)
+}
+
+// CreateBucket creates a private bucket using a client, so that the client's
+// options can be set first. The test fails if the bucket can't be created.
+func CreateBucket(t testing.TB, client *backblaze.B2, name string) *backblaze.Bucket {
+	t.Helper()
+	bucket, err := client.CreateBucket(name, backblaze.AllPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bucket
+}
+
+// ReadDownload returns a function which reads the content of a download,
+// failing the test on errors. The function takes the results of a download
+// call directly:
+//
+//	read := b2test.ReadDownload(t)
+//	file, data := read(bucket.DownloadFileByName("a.txt"))
+func ReadDownload(t testing.TB) func(*backblaze.File, io.ReadCloser, error) (*backblaze.File, []byte) {
+	return func(file *backblaze.File, reader io.ReadCloser, err error) (*backblaze.File, []byte) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file, data
+	}
+}
@@ -0,0 +1,276 @@
+package b2test
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FaultKind identifies a kind of misbehaviour which can be injected into
+// requests to the server
+type FaultKind string
+
+// Kinds of fault
+const (
+	// The token used by the request expires, and a 401 expired_auth_token
+	// error is returned. Has no effect on b2_authorize_account.
+	FaultExpiredToken FaultKind = "expired_token"
+
+	// A 503 service_unavailable error is returned, as when an upload URL's
+	// pod is busy
+	FaultServiceUnavailable FaultKind = "service_unavailable"
+
+	// A 429 too_many_requests error is returned, with a Retry-After header
+	FaultTooManyRequests FaultKind = "too_many_requests"
+
+	// The request succeeds, but the connection is closed after half of the
+	// response body has been sent
+	FaultTruncatedBody FaultKind = "truncated_body"
+
+	// Uploaded files and parts are rejected because their data does not
+	// match their SHA1 hash, and the first byte of downloaded files is
+	// corrupted. Has no effect on other APIs.
+	FaultChecksumMismatch FaultKind = "checksum_mismatch"
+
+	// The request is handled after a delay
+	FaultSlowResponse FaultKind = "slow_response"
+)
+
+// Fault describes misbehaviour to inject into requests to the server
+type Fault struct {
+	Kind FaultKind
+
+	// The APIs to inject the fault into, such as b2_list_file_names. Uploads
+	// are b2_upload_file and b2_upload_part, and downloads by name are
+	// b2_download_file_by_name. Requests to the S3-compatible API are named
+	// by operation, such as PutObject. If empty, all APIs match.
+	APIs []string
+
+	// The probability of injecting the fault into each matching request,
+	// decided using the server's random source. If zero, the fault is
+	// injected into every matching request.
+	Probability float64
+
+	// The number of matching requests to handle normally before the fault
+	// is injected
+	After int
+
+	// The maximum number of times to inject the fault. Unlimited if zero.
+	Count int
+
+	// How long to delay slow responses
+	Delay time.Duration
+
+	// The value of the Retry-After header sent with too_many_requests
+	// errors, rounded up to whole seconds. Defaults to one second.
+	RetryAfter time.Duration
+}
+
+// InjectedFault records a fault injected into a request
+type InjectedFault struct {
+	Kind FaultKind
+	API  string
+}
+
+// A fault and the number of times it has matched a request
+type activeFault struct {
+	Fault
+	matched  int
+	injected int
+}
+
+// InjectFault adds a fault to inject into matching requests. Faults are
+// checked in the order they were added, and more than one fault may be
+// injected into a request.
+func (s *Server) InjectFault(fault Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.faults = append(s.faults, &activeFault{Fault: fault})
+}
+
+// ClearFaults removes all faults, so requests are handled normally
+func (s *Server) ClearFaults() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.faults = nil
+}
+
+// SeedFaults resets the random source used to decide whether to inject
+// faults with a probability, so that a run can be repeated
+func (s *Server) SeedFaults(seed int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.random.Seed(seed)
+}
+
+// InjectedFaults returns the faults injected so far, in the order they were
+// injected
+func (s *Server) InjectedFaults() []InjectedFault {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]InjectedFault(nil), s.injected...)
+}
+
+// ExpireTokens expires all the authorization tokens issued so far, including
+// upload tokens, so that clients must authorize again
+func (s *Server) ExpireTokens() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, t := range s.tokens {
+		t.valid = false
+	}
+}
+
+// Returns the faults to inject into a request
+func (s *Server) matchFaults(api string) []Fault {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var matched []Fault
+	for _, fault := range s.faults {
+		if !fault.matches(api) {
+			continue
+		}
+		fault.matched++
+		if fault.matched <= fault.After || (fault.Count > 0 && fault.injected >= fault.Count) {
+			continue
+		}
+		if fault.Probability > 0 && s.random.Float64() >= fault.Probability {
+			continue
+		}
+
+		fault.injected++
+		s.injected = append(s.injected, InjectedFault{Kind: fault.Kind, API: api})
+		matched = append(matched, fault.Fault)
+	}
+	return matched
+}
+
+func (f *Fault) matches(api string) bool {
+	if len(f.APIs) == 0 {
+		return true
+	}
+	for _, name := range f.APIs {
+		if name == api {
+			return true
+		}
+	}
+	return false
+}
+
+// Injects faults into a request. Returns the writer to use for the response,
+// or false if the request has been answered.
+func (s *Server) injectFaults(w http.ResponseWriter, r *http.Request, api string) (http.ResponseWriter, bool) {
+	faults := s.matchFaults(api)
+	if len(faults) == 0 {
+		return w, true
+	}
+
+	upload := api == "b2_upload_file" || api == "b2_upload_part" || api == "PutObject"
+	download := api == "b2_download_file_by_id" || api == "b2_download_file_by_name" || api == "GetObject"
+
+	writeError := writeError
+	if strings.HasPrefix(r.URL.Path, s3Path) {
+		writeError = writeS3Error
+	}
+
+	writer := &faultWriter{ResponseWriter: w, limit: -1}
+	for _, fault := range faults {
+		switch fault.Kind {
+		case FaultSlowResponse:
+			select {
+			case <-time.After(fault.Delay):
+			case <-r.Context().Done():
+				return w, false
+			}
+		case FaultExpiredToken:
+			if api == "b2_authorize_account" {
+				continue
+			}
+			s.mutex.Lock()
+			if t, ok := s.tokens[r.Header.Get("Authorization")]; ok {
+				t.valid = false
+			}
+			s.mutex.Unlock()
+			writeError(w, unauthorized("expired_auth_token", "Authorization token has expired"))
+			return w, false
+		case FaultServiceUnavailable:
+			writeError(w, &apiError{http.StatusServiceUnavailable, "service_unavailable", "Service is temporarily unavailable"})
+			return w, false
+		case FaultTooManyRequests:
+			retryAfter := int64(1)
+			if fault.RetryAfter > 0 {
+				retryAfter = int64((fault.RetryAfter + time.Second - 1) / time.Second)
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+			writeError(w, &apiError{http.StatusTooManyRequests, "too_many_requests", "Too many requests"})
+			return w, false
+		case FaultChecksumMismatch:
+			if upload {
+				writeError(w, badRequest("Checksum did not match data received"))
+				return w, false
+			}
+			writer.corrupt = download
+		case FaultTruncatedBody:
+			writer.truncate = true
+		}
+	}
+	return writer, true
+}
+
+// A response writer which truncates or corrupts the response body
+type faultWriter struct {
+	http.ResponseWriter
+
+	truncate    bool  // Send only half of the body
+	corrupt     bool  // Corrupt the first byte of the body
+	limit       int64 // The number of bytes which may still be sent, or -1 if unlimited
+	wroteHeader bool
+}
+
+func (w *faultWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	// Only successful responses are modified
+	if status >= 300 {
+		w.truncate, w.corrupt = false, false
+	}
+	if w.truncate {
+		if length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil {
+			w.limit = length / 2
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *faultWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+
+	length := len(p)
+	if w.corrupt && len(p) > 0 {
+		p = append([]byte(nil), p...)
+		p[0] ^= 0xff
+		w.corrupt = false
+	}
+	if w.limit >= 0 {
+		if int64(len(p)) > w.limit {
+			p = p[:w.limit]
+		}
+		w.limit -= int64(len(p))
+	}
+
+	// Report the whole buffer as written, so the handler continues as normal
+	if _, err := w.ResponseWriter.Write(p); err != nil {
+		return 0, err
+	}
+	return length, nil
+}
@@ -0,0 +1,156 @@
+package b2test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+func TestFaults(T *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.NewClient()
+	bucket := CreateBucket(T, client, "fault-bucket")
+	content := []byte("some file content")
+	if _, err := bucket.UploadFile("file", nil, bytes.NewReader(content)); err != nil {
+		T.Fatal(err)
+	}
+
+	// Tokens expiring during a run are replaced
+	server.ExpireTokens()
+	if _, err := bucket.ListFileNames("", 10); err != nil {
+		T.Errorf("Expected list to succeed after tokens expired, saw %v", err)
+	}
+
+	server.InjectFault(Fault{Kind: FaultExpiredToken, APIs: []string{"b2_list_file_names"}, Count: 1})
+	if _, err := bucket.ListFileNames("", 10); err != nil {
+		T.Errorf("Expected list to succeed after an expired token, saw %v", err)
+	}
+
+	// A busy upload pod is retried once
+	server.InjectFault(Fault{Kind: FaultServiceUnavailable, APIs: []string{"b2_upload_file"}, Count: 1})
+	if _, err := bucket.UploadFile("file", nil, bytes.NewReader(content)); err != nil {
+		T.Errorf("Expected upload to succeed after a retry, saw %v", err)
+	}
+	server.InjectFault(Fault{Kind: FaultServiceUnavailable, APIs: []string{"b2_upload_file"}, Count: 2})
+	if _, err := bucket.UploadFile("file", nil, bytes.NewReader(content)); !errors.Is(err, backblaze.ErrServiceUnavailable) {
+		T.Errorf("Expected upload to fail after repeated errors, saw %v", err)
+	}
+
+	// Rejected uploads
+	server.InjectFault(Fault{Kind: FaultChecksumMismatch, APIs: []string{"b2_upload_file"}, Count: 1})
+	if _, err := bucket.UploadFile("file", nil, bytes.NewReader(content)); !errors.Is(err, backblaze.ErrBadRequest) {
+		T.Errorf("Expected upload to be rejected, saw %v", err)
+	}
+
+	// Corrupt and truncated downloads
+	server.InjectFault(Fault{Kind: FaultChecksumMismatch, APIs: []string{"b2_download_file_by_name"}, Count: 1})
+	_, reader, err := bucket.DownloadFileByName("file")
+	if err != nil {
+		T.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil || len(data) != len(content) || bytes.Equal(data, content) {
+		T.Errorf("Expected corrupted content, saw %q, %v", data, err)
+	}
+
+	server.InjectFault(Fault{Kind: FaultTruncatedBody, APIs: []string{"b2_download_file_by_name"}, Count: 1})
+	_, reader, err = bucket.DownloadFileByName("file")
+	if err != nil {
+		T.Fatal(err)
+	}
+	data, err = ioutil.ReadAll(reader)
+	reader.Close()
+	if err == nil || len(data) >= len(content) {
+		T.Errorf("Expected a truncated download, saw %d bytes, %v", len(data), err)
+	}
+
+	// Slow responses
+	server.InjectFault(Fault{Kind: FaultSlowResponse, APIs: []string{"b2_list_buckets"}, Count: 1, Delay: 50 * time.Millisecond})
+	start := time.Now()
+	if _, err := client.ListBuckets(); err != nil {
+		T.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		T.Errorf("Expected a slow response, took %v", elapsed)
+	}
+
+	// Rate limiting
+	server.InjectFault(Fault{Kind: FaultTooManyRequests, APIs: []string{"b2_get_file_info"}, After: 1, Count: 1, RetryAfter: 1500 * time.Millisecond})
+	resp, err := http.Post(server.URL+v1+"b2_get_file_info", "application/json", nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		T.Errorf("Expected the first request to be handled normally")
+	}
+	resp, err = http.Post(server.URL+v1+"b2_get_file_info", "application/json", nil)
+	if err != nil {
+		T.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		T.Errorf("Expected too many requests with Retry-After 2, saw %s %q", resp.Status, resp.Header.Get("Retry-After"))
+	}
+
+	expected := []FaultKind{
+		FaultExpiredToken,
+		FaultServiceUnavailable, FaultServiceUnavailable, FaultServiceUnavailable,
+		FaultChecksumMismatch, FaultChecksumMismatch,
+		FaultTruncatedBody,
+		FaultSlowResponse,
+		FaultTooManyRequests,
+	}
+	injected := server.InjectedFaults()
+	if len(injected) != len(expected) {
+		T.Fatalf("Expected %d faults to be injected, saw %v", len(expected), injected)
+	}
+	for i, fault := range injected {
+		if fault.Kind != expected[i] {
+			T.Errorf("Expected fault %d to be %s, saw %s", i, expected[i], fault.Kind)
+		}
+	}
+}
+
+func TestFaultProbability(T *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	client := server.NewClient()
+	client.NoRetry = true
+
+	// Returns which of a sequence of requests failed
+	run := func() []bool {
+		server.ClearFaults()
+		server.SeedFaults(42)
+		server.InjectFault(Fault{Kind: FaultServiceUnavailable, APIs: []string{"b2_list_buckets"}, Probability: 0.5})
+
+		failed := make([]bool, 50)
+		for i := range failed {
+			_, err := client.ListBuckets()
+			failed[i] = err != nil
+		}
+		return failed
+	}
+
+	first := run()
+	second := run()
+	count := 0
+	for i := range first {
+		if first[i] {
+			count++
+		}
+		if first[i] != second[i] {
+			T.Errorf("Expected the same faults with the same seed, request %d differs", i)
+		}
+	}
+	if count < 10 || count > 40 {
+		T.Errorf("Expected around half of the requests to fail, saw %d", count)
+	}
+}
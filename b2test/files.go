@@ -0,0 +1,563 @@
+package b2test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A version of a file. The data of a finished file is not modified, so may
+// be read without holding the mutex.
+type file struct {
+	info  fileInfo
+	data  []byte
+	parts map[int]*part // The uploaded parts of an unfinished large file
+}
+
+// Returns a copy of the file info with an empty map instead of nil
+func (f *file) response() fileInfo {
+	info := f.info
+	if info.FileInfo == nil {
+		info.FileInfo = map[string]string{}
+	}
+	return info
+}
+
+func (s *Server) getUploadURL(body []byte) (interface{}, *apiError) {
+	request := &bucketRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(request.BucketID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploadURLResponse{
+		BucketID:           b.info.BucketID,
+		UploadURL:          s.URL + v1 + "b2_upload_file/" + b.info.BucketID,
+		AuthorizationToken: s.issueToken("upload", b.info.BucketID),
+	}, nil
+}
+
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request, bucketID string) {
+	s.mutex.Lock()
+	err := s.checkToken(r, "upload", bucketID)
+	s.mutex.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	name, err := fileNameHeader(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, sha1Hash, err := readContent(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f := &file{
+		info: fileInfo{
+			AccountID:       s.AccountID,
+			Action:          "upload",
+			BucketID:        bucketID,
+			ContentLength:   int64(len(data)),
+			ContentSha1:     sha1Hash,
+			ContentType:     contentType(r.Header.Get("Content-Type"), name),
+			FileID:          s.newFileID(bucketID),
+			FileInfo:        infoHeaders(r.Header),
+			FileName:        name,
+			UploadTimestamp: nowMillis(),
+		},
+		data: data,
+	}
+	s.addFile(b, f)
+	writeJSON(w, http.StatusOK, f.response())
+}
+
+// Reads the file name from an upload request
+func fileNameHeader(r *http.Request) (string, *apiError) {
+	name, err := url.QueryUnescape(r.Header.Get("X-Bz-File-Name"))
+	if err != nil {
+		return "", badRequest("Invalid X-Bz-File-Name: %v", err)
+	}
+	if name == "" || len(name) > 1024 || strings.HasPrefix(name, "/") || strings.Contains(name, "//") {
+		return "", badRequest("Invalid file name %q", name)
+	}
+	return name, nil
+}
+
+// Reads the body of an upload request, checking its length and SHA1 hash
+func readContent(r *http.Request) ([]byte, string, *apiError) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", badRequest("Unable to read request: %v", err)
+	}
+	if r.ContentLength < 0 {
+		return nil, "", &apiError{http.StatusLengthRequired, "bad_request", "Content-Length is required"}
+	}
+
+	hash := sha1.Sum(data)
+	sha1Hash := hex.EncodeToString(hash[:])
+	switch expected := r.Header.Get("X-Bz-Content-Sha1"); expected {
+	case "do_not_verify":
+	case "":
+		return nil, "", badRequest("Missing X-Bz-Content-Sha1 header")
+	default:
+		if !strings.EqualFold(expected, sha1Hash) {
+			return nil, "", badRequest("Checksum did not match data received")
+		}
+	}
+	return data, sha1Hash, nil
+}
+
+// Determines the content type of an uploaded file
+func contentType(value, name string) string {
+	if value != "b2/x-auto" && value != "" {
+		return value
+	}
+	if mediaType, _, err := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name))); err == nil {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// Reads the file info from the X-Bz-Info- headers of an upload request. Keys
+// are not case sensitive, and are stored in lower case.
+func infoHeaders(header http.Header) map[string]string {
+	info := make(map[string]string)
+	for key, values := range header {
+		if !strings.HasPrefix(key, "X-Bz-Info-") {
+			continue
+		}
+		name, err := url.QueryUnescape(strings.TrimPrefix(key, "X-Bz-Info-"))
+		if err != nil {
+			name = strings.TrimPrefix(key, "X-Bz-Info-")
+		}
+		value, err := url.QueryUnescape(values[0])
+		if err != nil {
+			value = values[0]
+		}
+		info[strings.ToLower(name)] = value
+	}
+	return info
+}
+
+// Returns a new file ID in a bucket. Must be called while holding the mutex.
+func (s *Server) newFileID(bucketID string) string {
+	return fmt.Sprintf("4_z%s_f%s", bucketID, s.newID())
+}
+
+// Adds a new version of a file. Must be called while holding the mutex.
+func (s *Server) addFile(b *bucket, f *file) {
+	name := f.info.FileName
+	b.names[name] = append([]*file{f}, b.names[name]...)
+	s.files[f.info.FileID] = f
+}
+
+// Removes a version of a file. Must be called while holding the mutex.
+func (s *Server) removeFile(f *file) {
+	delete(s.files, f.info.FileID)
+
+	b := s.buckets[f.info.BucketID]
+	name := f.info.FileName
+	versions := b.names[name]
+	for i, version := range versions {
+		if version == f {
+			versions = append(versions[:i:i], versions[i+1:]...)
+			break
+		}
+	}
+	if len(versions) == 0 {
+		delete(b.names, name)
+	} else {
+		b.names[name] = versions
+	}
+}
+
+// Returns the current version of a file, or nil if the latest version is
+// hidden or there are no versions. Unfinished large files are ignored.
+func (b *bucket) current(name string) *file {
+	for _, f := range b.names[name] {
+		switch f.info.Action {
+		case "upload":
+			return f
+		case "hide":
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *Server) getFileInfo(body []byte) (interface{}, *apiError) {
+	request := &fileRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	f, ok := s.files[request.FileID]
+	if !ok {
+		return nil, notFound("File not present: %s", request.FileID)
+	}
+	return f.response(), nil
+}
+
+func (s *Server) hideFile(body []byte) (interface{}, *apiError) {
+	request := &hideFileRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(request.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.names[request.FileName]) == 0 {
+		return nil, fileNotPresent(request.FileName)
+	}
+	if b.current(request.FileName) == nil {
+		return nil, badRequest("File already hidden: %s", request.FileName)
+	}
+
+	f := &file{
+		info: fileInfo{
+			AccountID:       s.AccountID,
+			Action:          "hide",
+			BucketID:        b.info.BucketID,
+			ContentSha1:     "none",
+			ContentType:     "application/x-bz-hide-marker",
+			FileID:          s.newFileID(b.info.BucketID),
+			FileName:        request.FileName,
+			UploadTimestamp: nowMillis(),
+		},
+	}
+	s.addFile(b, f)
+	return f.response(), nil
+}
+
+func (s *Server) deleteFileVersion(body []byte) (interface{}, *apiError) {
+	request := &fileVersionRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	f, ok := s.files[request.FileID]
+	if !ok || f.info.FileName != request.FileName {
+		return nil, fileNotPresent(request.FileName + " " + request.FileID)
+	}
+
+	s.removeFile(f)
+	return &fileVersionRequest{FileName: f.info.FileName, FileID: f.info.FileID}, nil
+}
+
+func (s *Server) copyFile(body []byte) (interface{}, *apiError) {
+	request := &copyFileRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	source, ok := s.files[request.SourceFileID]
+	if !ok || source.info.Action != "upload" {
+		return nil, badRequest("Source file not present: %s", request.SourceFileID)
+	}
+
+	bucketID := request.DestinationBucketID
+	if bucketID == "" {
+		bucketID = source.info.BucketID
+	}
+	b, err := s.bucket(bucketID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := source.data
+	if request.Range != "" {
+		start, end, err := parseRange(request.Range, int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		data = data[start : end+1]
+	}
+	hash := sha1.Sum(data)
+
+	info := fileInfo{
+		AccountID:       s.AccountID,
+		Action:          "upload",
+		BucketID:        bucketID,
+		ContentLength:   int64(len(data)),
+		ContentSha1:     hex.EncodeToString(hash[:]),
+		FileID:          s.newFileID(bucketID),
+		FileName:        request.FileName,
+		UploadTimestamp: nowMillis(),
+	}
+	switch request.MetadataDirective {
+	case "", "COPY":
+		if request.ContentType != "" || request.FileInfo != nil {
+			return nil, badRequest("contentType and fileInfo must not be set when metadataDirective is COPY")
+		}
+		info.ContentType = source.info.ContentType
+		info.FileInfo = copyInfo(source.info.FileInfo)
+	case "REPLACE":
+		if request.ContentType == "" {
+			return nil, badRequest("contentType must be set when metadataDirective is REPLACE")
+		}
+		info.ContentType = contentType(request.ContentType, request.FileName)
+		info.FileInfo = copyInfo(request.FileInfo)
+	default:
+		return nil, badRequest("Invalid metadataDirective: %s", request.MetadataDirective)
+	}
+
+	f := &file{info: info, data: data}
+	s.addFile(b, f)
+	return f.response(), nil
+}
+
+func copyInfo(info map[string]string) map[string]string {
+	copied := make(map[string]string, len(info))
+	for k, v := range info {
+		copied[k] = v
+	}
+	return copied
+}
+
+func (s *Server) listFileNames(body []byte) (interface{}, *apiError) {
+	return s.listFiles(body, false)
+}
+
+func (s *Server) listFileVersions(body []byte) (interface{}, *apiError) {
+	return s.listFiles(body, true)
+}
+
+// Lists the current versions of files, or all versions, in name order
+func (s *Server) listFiles(body []byte, versions bool) (interface{}, *apiError) {
+	request := &listFilesRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(request.BucketID)
+	if err != nil {
+		return nil, err
+	}
+
+	max := request.MaxFileCount
+	switch {
+	case max == 0:
+		max = 100
+	case max < 0 || max > 10000:
+		return nil, badRequest("maxFileCount must be in the range 1 to 10000")
+	}
+
+	var names []string
+	for name := range b.names {
+		// Hidden files are not listed, and don't make folders visible
+		if !versions && b.current(name) == nil {
+			continue
+		}
+		if strings.HasPrefix(name, request.Prefix) && name >= request.StartFileName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	response := &listFilesResponse{Files: []fileInfo{}}
+	lastFolder := ""
+	for _, name := range names {
+		// Files within a folder are listed as a single entry for the folder
+		if request.Delimiter != "" {
+			if i := strings.Index(name[len(request.Prefix):], request.Delimiter); i >= 0 {
+				folder := name[:len(request.Prefix)+i+len(request.Delimiter)]
+				if folder == lastFolder || folder < request.StartFileName {
+					continue
+				}
+				lastFolder = folder
+				if len(response.Files) == max {
+					response.NextFileName = &folder
+					break
+				}
+				response.Files = append(response.Files, fileInfo{Action: "folder", FileName: folder, FileInfo: map[string]string{}})
+				continue
+			}
+		}
+
+		var list []*file
+		if versions {
+			list = b.names[name]
+		} else if f := b.current(name); f != nil {
+			list = []*file{f}
+		}
+
+		for _, f := range list {
+			if request.StartFileID != "" && name == request.StartFileName {
+				if f.info.FileID != request.StartFileID {
+					continue
+				}
+				request.StartFileID = ""
+			}
+			if len(response.Files) == max {
+				response.NextFileName = &f.info.FileName
+				if versions {
+					response.NextFileID = &f.info.FileID
+				}
+				return response, nil
+			}
+			response.Files = append(response.Files, f.response())
+		}
+	}
+	return response, nil
+}
+
+func (s *Server) downloadFileByID(w http.ResponseWriter, r *http.Request) {
+	fileID := r.URL.Query().Get("fileId")
+	if r.Method == http.MethodPost {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, badRequest("Unable to read request: %v", err))
+			return
+		}
+		request := &fileRequest{}
+		if err := decodeRequest(body, request); err != nil {
+			writeError(w, err)
+			return
+		}
+		fileID = request.FileID
+	}
+
+	s.mutex.Lock()
+	err := s.checkToken(r, "account", "")
+	f, ok := s.files[fileID]
+	ok = ok && f.info.Action == "upload"
+	s.mutex.Unlock()
+
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case !ok:
+		writeError(w, notFound("File not present: %s", fileID))
+	default:
+		writeFile(w, r, f)
+	}
+}
+
+func (s *Server) downloadFileByName(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 {
+		writeError(w, badRequest("Invalid download path %s", r.URL.Path))
+		return
+	}
+
+	s.mutex.Lock()
+	var f *file
+	b := s.bucketNamed(parts[0])
+	if b != nil {
+		f = b.current(parts[1])
+	}
+	var err *apiError
+	if b != nil && b.info.BucketType != "allPublic" {
+		err = s.checkToken(r, "account", "")
+	}
+	s.mutex.Unlock()
+
+	switch {
+	case err != nil:
+		writeError(w, err)
+	case f == nil:
+		writeError(w, notFound("File not present: %s", r.URL.Path))
+	default:
+		writeFile(w, r, f)
+	}
+}
+
+// Writes the contents of a file, or the requested range
+func writeFile(w http.ResponseWriter, r *http.Request, f *file) {
+	data := f.data
+	status := http.StatusOK
+
+	header := w.Header()
+	if value := r.Header.Get("Range"); value != "" {
+		start, end, err := parseRange(value, int64(len(data)))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	header.Set("Content-Type", f.info.ContentType)
+	header.Set("X-Bz-File-Id", f.info.FileID)
+	header.Set("X-Bz-File-Name", url.QueryEscape(f.info.FileName))
+	header.Set("X-Bz-Content-Sha1", f.info.ContentSha1)
+	header.Set("X-Bz-Upload-Timestamp", strconv.FormatInt(f.info.UploadTimestamp, 10))
+	for key, value := range f.info.FileInfo {
+		header.Set("X-Bz-Info-"+url.QueryEscape(key), url.QueryEscape(value))
+	}
+
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+}
+
+// Parses a range header of the form bytes=start-end, bytes=start- or
+// bytes=-length, returning the inclusive range of bytes requested
+func parseRange(value string, size int64) (int64, int64, *apiError) {
+	notSatisfiable := &apiError{http.StatusRequestedRangeNotSatisfiable, "range_not_satisfiable", "Invalid range: " + value}
+
+	spec := strings.TrimPrefix(value, "bytes=")
+	parts := strings.Split(spec, "-")
+	if spec == value || len(parts) != 2 {
+		return 0, 0, badRequest("Invalid range: %s", value)
+	}
+
+	var start, end int64
+	var err error
+	switch {
+	case parts[0] == "":
+		// Suffix of the file
+		length, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || length <= 0 {
+			return 0, 0, badRequest("Invalid range: %s", value)
+		}
+		if length > size {
+			length = size
+		}
+		start, end = size-length, size-1
+	default:
+		if start, err = strconv.ParseInt(parts[0], 10, 64); err != nil || start < 0 {
+			return 0, 0, badRequest("Invalid range: %s", value)
+		}
+		end = size - 1
+		if parts[1] != "" {
+			if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil || end < start {
+				return 0, 0, badRequest("Invalid range: %s", value)
+			}
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	if start >= size || start > end {
+		return 0, 0, notSatisfiable
+	}
+	return start, end, nil
+}
@@ -0,0 +1,188 @@
+package b2test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The maximum part number of a large file
+const maxParts = 10000
+
+// An uploaded part of a large file
+type part struct {
+	data []byte
+	sha1 string
+}
+
+func (s *Server) startLargeFile(body []byte) (interface{}, *apiError) {
+	request := &startLargeFileRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	b, err := s.bucket(request.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	if request.FileName == "" || strings.HasPrefix(request.FileName, "/") {
+		return nil, badRequest("Invalid file name %q", request.FileName)
+	}
+	if request.ContentType == "" {
+		return nil, badRequest("contentType is required")
+	}
+
+	info := make(map[string]string, len(request.FileInfo))
+	for key, value := range request.FileInfo {
+		info[strings.ToLower(key)] = value
+	}
+
+	f := &file{
+		info: fileInfo{
+			AccountID:       s.AccountID,
+			Action:          "start",
+			BucketID:        b.info.BucketID,
+			ContentSha1:     "none",
+			ContentType:     contentType(request.ContentType, request.FileName),
+			FileID:          s.newFileID(b.info.BucketID),
+			FileInfo:        info,
+			FileName:        request.FileName,
+			UploadTimestamp: nowMillis(),
+		},
+		parts: make(map[int]*part),
+	}
+	s.addFile(b, f)
+	return f.response(), nil
+}
+
+// Returns an unfinished large file. Must be called while holding the mutex.
+func (s *Server) largeFile(fileID string) (*file, *apiError) {
+	f, ok := s.files[fileID]
+	if !ok || f.info.Action != "start" {
+		return nil, badRequest("No unfinished large file with ID %s", fileID)
+	}
+	return f, nil
+}
+
+func (s *Server) getUploadPartURL(body []byte) (interface{}, *apiError) {
+	request := &fileRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	f, err := s.largeFile(request.FileID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &uploadURLResponse{
+		FileID:             f.info.FileID,
+		UploadURL:          s.URL + v1 + "b2_upload_part/" + f.info.FileID,
+		AuthorizationToken: s.issueToken("part", f.info.FileID),
+	}, nil
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, fileID string) {
+	s.mutex.Lock()
+	err := s.checkToken(r, "part", fileID)
+	s.mutex.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	partNumber, convErr := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+	if convErr != nil || partNumber < 1 || partNumber > maxParts {
+		writeError(w, badRequest("Invalid X-Bz-Part-Number: %s", r.Header.Get("X-Bz-Part-Number")))
+		return
+	}
+	data, sha1Hash, err := readContent(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f, err := s.largeFile(fileID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	f.parts[partNumber] = &part{data: data, sha1: sha1Hash}
+
+	writeJSON(w, http.StatusOK, &uploadPartResponse{
+		FileID:        fileID,
+		PartNumber:    partNumber,
+		ContentLength: int64(len(data)),
+		ContentSha1:   sha1Hash,
+	})
+}
+
+func (s *Server) finishLargeFile(body []byte) (interface{}, *apiError) {
+	request := &finishLargeFileRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	f, err := s.largeFile(request.FileID)
+	if err != nil {
+		return nil, err
+	}
+
+	count := len(request.PartSha1Array)
+	if count < 2 {
+		return nil, badRequest("Large files must have at least 2 parts")
+	}
+	if count != len(f.parts) {
+		return nil, badRequest("Expected %d parts, %d were uploaded", count, len(f.parts))
+	}
+
+	var data bytes.Buffer
+	for i, expected := range request.PartSha1Array {
+		p, ok := f.parts[i+1]
+		switch {
+		case !ok:
+			return nil, badRequest("Part %d was not uploaded", i+1)
+		case !strings.EqualFold(p.sha1, expected):
+			return nil, badRequest("SHA1 of part %d does not match", i+1)
+		case i < count-1 && int64(len(p.data)) < s.AbsoluteMinimumPartSize:
+			return nil, badRequest("Part %d is smaller than the minimum part size %d", i+1, s.AbsoluteMinimumPartSize)
+		}
+		data.Write(p.data)
+	}
+
+	if expected, ok := f.info.FileInfo["large_file_sha1"]; ok {
+		hash := sha1.Sum(data.Bytes())
+		if !strings.EqualFold(expected, hex.EncodeToString(hash[:])) {
+			return nil, badRequest("SHA1 of file does not match large_file_sha1")
+		}
+	}
+
+	// The SHA1 of a large file is not recorded, only that of its parts
+	f.data = data.Bytes()
+	f.parts = nil
+	f.info.Action = "upload"
+	f.info.ContentLength = int64(len(f.data))
+	return f.response(), nil
+}
+
+func (s *Server) cancelLargeFile(body []byte) (interface{}, *apiError) {
+	request := &fileRequest{}
+	if err := decodeRequest(body, request); err != nil {
+		return nil, err
+	}
+	f, err := s.largeFile(request.FileID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.removeFile(f)
+	return &cancelLargeFileResponse{
+		FileID:    f.info.FileID,
+		AccountID: f.info.AccountID,
+		BucketID:  f.info.BucketID,
+		FileName:  f.info.FileName,
+	}, nil
+}
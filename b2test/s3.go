@@ -0,0 +1,463 @@
+package b2test
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The path of the S3-compatible API
+const s3Path = "/s3/"
+
+// Returns the S3 operation called by a request to a path within the
+// S3-compatible API, given the bucket and key named in the path
+func s3Operation(r *http.Request, target string) string {
+	object := strings.Contains(target, "/")
+	switch {
+	case r.Method == http.MethodGet && object:
+		return "GetObject"
+	case r.Method == http.MethodGet:
+		if _, ok := r.URL.Query()["versions"]; ok {
+			return "ListObjectVersions"
+		}
+		return "ListObjects"
+	case r.Method == http.MethodPut && object && r.Header.Get("X-Amz-Copy-Source") != "":
+		return "CopyObject"
+	case r.Method == http.MethodPut && object:
+		return "PutObject"
+	case r.Method == http.MethodDelete && object:
+		return "DeleteObject"
+	}
+	return r.Method + "Bucket"
+}
+
+type s3ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	RequestID string `xml:"RequestId"`
+}
+
+type s3ListVersionsResult struct {
+	XMLName             xml.Name `xml:"ListVersionsResult"`
+	Name                string
+	Prefix              string
+	KeyMarker           string
+	VersionIDMarker     string `xml:"VersionIdMarker"`
+	MaxKeys             int
+	Delimiter           string `xml:",omitempty"`
+	IsTruncated         bool
+	NextKeyMarker       string `xml:",omitempty"`
+	NextVersionIDMarker string `xml:"NextVersionIdMarker,omitempty"`
+	Entries             []s3Version
+	CommonPrefixes      []s3CommonPrefix
+}
+
+type s3Version struct {
+	XMLName      xml.Name // Version or DeleteMarker
+	Key          string
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool
+	LastModified string
+	ETag         string `xml:",omitempty"`
+	Size         int64  `xml:",omitempty"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string
+}
+
+type s3CopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	ETag         string
+	LastModified string
+}
+
+func s3Error(status int, code, format string, args ...interface{}) *apiError {
+	return &apiError{status, code, fmt.Sprintf(format, args...)}
+}
+
+// The S3 error codes corresponding to native API error codes
+var s3Codes = map[string]string{
+	"bad_request":           "InvalidArgument",
+	"range_not_satisfiable": "InvalidRange",
+	"expired_auth_token":    "ExpiredToken",
+	"service_unavailable":   "ServiceUnavailable",
+	"too_many_requests":     "SlowDown",
+}
+
+// Writes an error in the format used by the S3-compatible API
+func writeS3Error(w http.ResponseWriter, err *apiError) {
+	code := err.Code
+	if s3Code, ok := s3Codes[code]; ok {
+		code = s3Code
+	}
+	body, _ := xml.Marshal(&s3ErrorResponse{Code: code, Message: err.Message})
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(body)))
+	w.WriteHeader(err.Status)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func writeXML(w http.ResponseWriter, response interface{}) {
+	body, err := xml.Marshal(response)
+	if err != nil {
+		writeS3Error(w, s3Error(http.StatusInternalServerError, "InternalError", "%v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(body)))
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+// Formats a time given in milliseconds since the epoch
+func s3Time(millis int64) string {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// Checks that a request is signed with the server's key ID. Signatures are
+// not verified.
+func (s *Server) checkS3Signature(r *http.Request) *apiError {
+	const prefix = "AWS4-HMAC-SHA256 Credential="
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return s3Error(http.StatusForbidden, "AccessDenied", "Missing AWS Signature Version 4 authorization")
+	}
+	if !strings.HasPrefix(auth[len(prefix):], s.AccountID+"/") {
+		return s3Error(http.StatusForbidden, "InvalidAccessKeyId", "The key ID does not exist")
+	}
+	return nil
+}
+
+// Handles a request to the S3-compatible API. Objects are the same file
+// versions used by the native API, and version IDs are file IDs.
+func (s *Server) handleS3(w http.ResponseWriter, r *http.Request, op, target string) {
+	if err := s.checkS3Signature(r); err != nil {
+		writeS3Error(w, err)
+		return
+	}
+
+	bucketName, key := target, ""
+	if i := strings.Index(target, "/"); i >= 0 {
+		bucketName, key = target[:i], target[i+1:]
+	}
+
+	// Uploads are read before taking the lock
+	var data []byte
+	if op == "PutObject" {
+		var err error
+		if data, err = ioutil.ReadAll(r.Body); err != nil {
+			writeS3Error(w, s3Error(http.StatusBadRequest, "IncompleteBody", "Unable to read request: %v", err))
+			return
+		}
+	}
+
+	s.mutex.Lock()
+	b := s.bucketNamed(bucketName)
+	if b == nil {
+		s.mutex.Unlock()
+		writeS3Error(w, s3Error(http.StatusNotFound, "NoSuchBucket", "Bucket not found: %s", bucketName))
+		return
+	}
+
+	var f *file
+	var err *apiError
+	switch op {
+	case "GetObject":
+		f, err = s.s3GetObject(b, key, r)
+	case "PutObject":
+		f = s.s3PutObject(b, key, data, r)
+	case "CopyObject":
+		f, err = s.s3CopyObject(b, key, r)
+	case "DeleteObject":
+		f, err = s.s3DeleteObject(b, key, r)
+	case "ListObjectVersions":
+		var result *s3ListVersionsResult
+		if result, err = s.s3ListObjectVersions(b, r.URL.Query()); err == nil {
+			s.mutex.Unlock()
+			writeXML(w, result)
+			return
+		}
+	default:
+		err = s3Error(http.StatusNotImplemented, "NotImplemented", "%s is not supported", op)
+	}
+	s.mutex.Unlock()
+
+	if err != nil {
+		writeS3Error(w, err)
+		return
+	}
+
+	header := w.Header()
+	header.Set("X-Amz-Version-Id", f.info.FileID)
+	switch op {
+	case "GetObject":
+		header.Set("Last-Modified", time.Unix(0, f.info.UploadTimestamp*int64(time.Millisecond)).UTC().Format(http.TimeFormat))
+		header.Set("ETag", etag(f.data))
+		for key, value := range f.info.FileInfo {
+			header.Set("X-Amz-Meta-"+key, value)
+		}
+		writeFile(w, r, f)
+	case "PutObject":
+		header.Set("ETag", etag(f.data))
+		w.WriteHeader(http.StatusOK)
+	case "CopyObject":
+		writeXML(w, &s3CopyObjectResult{ETag: etag(f.data), LastModified: s3Time(f.info.UploadTimestamp)})
+	case "DeleteObject":
+		if f.info.Action == "hide" {
+			header.Set("X-Amz-Delete-Marker", "true")
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func etag(data []byte) string {
+	hash := md5.Sum(data)
+	return `"` + hex.EncodeToString(hash[:]) + `"`
+}
+
+// Returns the requested version of an object, or the current version. Must
+// be called while holding the mutex.
+func (s *Server) s3GetObject(b *bucket, key string, r *http.Request) (*file, *apiError) {
+	var f *file
+	if versionID := r.URL.Query().Get("versionId"); versionID != "" {
+		if version, ok := s.files[versionID]; ok && version.info.FileName == key && version.info.BucketID == b.info.BucketID && version.info.Action == "upload" {
+			f = version
+		}
+	} else {
+		f = b.current(key)
+	}
+	if f == nil {
+		return nil, s3Error(http.StatusNotFound, "NoSuchKey", "Key not found: %s", key)
+	}
+
+	if value := r.Header.Get("Range"); value != "" {
+		if _, _, err := parseRange(value, int64(len(f.data))); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Adds a new version of an object. Must be called while holding the mutex.
+func (s *Server) s3PutObject(b *bucket, key string, data []byte, r *http.Request) *file {
+	hash := sha1.Sum(data)
+	f := &file{
+		info: fileInfo{
+			AccountID:       s.AccountID,
+			Action:          "upload",
+			BucketID:        b.info.BucketID,
+			ContentLength:   int64(len(data)),
+			ContentSha1:     hex.EncodeToString(hash[:]),
+			ContentType:     contentType(r.Header.Get("Content-Type"), key),
+			FileID:          s.newFileID(b.info.BucketID),
+			FileInfo:        metaHeaders(r.Header),
+			FileName:        key,
+			UploadTimestamp: nowMillis(),
+		},
+		data: data,
+	}
+	s.addFile(b, f)
+	return f
+}
+
+// Reads file info from the X-Amz-Meta- headers of a request
+func metaHeaders(header http.Header) map[string]string {
+	info := make(map[string]string)
+	for key, values := range header {
+		if strings.HasPrefix(key, "X-Amz-Meta-") {
+			info[strings.ToLower(strings.TrimPrefix(key, "X-Amz-Meta-"))] = values[0]
+		}
+	}
+	return info
+}
+
+// Copies a version of an object. Must be called while holding the mutex.
+func (s *Server) s3CopyObject(b *bucket, key string, r *http.Request) (*file, *apiError) {
+	source, err := url.Parse(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		return nil, s3Error(http.StatusBadRequest, "InvalidArgument", "Invalid copy source: %v", err)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(source.Path, "/"), "/", 2)
+	if len(parts) != 2 {
+		return nil, s3Error(http.StatusBadRequest, "InvalidArgument", "Invalid copy source: %s", source.Path)
+	}
+	sourceBucket := s.bucketNamed(parts[0])
+	if sourceBucket == nil {
+		return nil, s3Error(http.StatusNotFound, "NoSuchBucket", "Bucket not found: %s", parts[0])
+	}
+
+	var from *file
+	if versionID := source.Query().Get("versionId"); versionID != "" {
+		if version, ok := s.files[versionID]; ok && version.info.FileName == parts[1] && version.info.Action == "upload" {
+			from = version
+		}
+	} else {
+		from = sourceBucket.current(parts[1])
+	}
+	if from == nil {
+		return nil, s3Error(http.StatusNotFound, "NoSuchKey", "Key not found: %s", parts[1])
+	}
+
+	info := fileInfo{
+		AccountID:       s.AccountID,
+		Action:          "upload",
+		BucketID:        b.info.BucketID,
+		ContentLength:   from.info.ContentLength,
+		ContentSha1:     from.info.ContentSha1,
+		FileID:          s.newFileID(b.info.BucketID),
+		FileName:        key,
+		UploadTimestamp: nowMillis(),
+	}
+	switch r.Header.Get("X-Amz-Metadata-Directive") {
+	case "", "COPY":
+		info.ContentType = from.info.ContentType
+		info.FileInfo = copyInfo(from.info.FileInfo)
+	case "REPLACE":
+		info.ContentType = contentType(r.Header.Get("Content-Type"), key)
+		info.FileInfo = metaHeaders(r.Header)
+	default:
+		return nil, s3Error(http.StatusBadRequest, "InvalidArgument", "Invalid metadata directive")
+	}
+
+	f := &file{info: info, data: from.data}
+	s.addFile(b, f)
+	return f, nil
+}
+
+// Deletes a version of an object, or hides the object if no version is given.
+// Must be called while holding the mutex.
+func (s *Server) s3DeleteObject(b *bucket, key string, r *http.Request) (*file, *apiError) {
+	versionID := r.URL.Query().Get("versionId")
+	if versionID == "" {
+		f := &file{
+			info: fileInfo{
+				AccountID:       s.AccountID,
+				Action:          "hide",
+				BucketID:        b.info.BucketID,
+				ContentSha1:     "none",
+				ContentType:     "application/x-bz-hide-marker",
+				FileID:          s.newFileID(b.info.BucketID),
+				FileName:        key,
+				UploadTimestamp: nowMillis(),
+			},
+		}
+		s.addFile(b, f)
+		return f, nil
+	}
+
+	f, ok := s.files[versionID]
+	if !ok || f.info.FileName != key || f.info.BucketID != b.info.BucketID {
+		return nil, s3Error(http.StatusNotFound, "NoSuchVersion", "Version not found: %s", versionID)
+	}
+	s.removeFile(f)
+	return f, nil
+}
+
+// Lists the versions of objects in a bucket. Listing starts after the key and
+// version ID markers, and unfinished large files are not listed. Must be
+// called while holding the mutex.
+func (s *Server) s3ListObjectVersions(b *bucket, query url.Values) (*s3ListVersionsResult, *apiError) {
+	result := &s3ListVersionsResult{
+		Name:            b.info.BucketName,
+		Prefix:          query.Get("prefix"),
+		KeyMarker:       query.Get("key-marker"),
+		VersionIDMarker: query.Get("version-id-marker"),
+		MaxKeys:         1000,
+		Delimiter:       query.Get("delimiter"),
+	}
+	if value := query.Get("max-keys"); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max < 0 {
+			return nil, s3Error(http.StatusBadRequest, "InvalidArgument", "Invalid max-keys: %s", value)
+		}
+		if max < result.MaxKeys {
+			result.MaxKeys = max
+		}
+	}
+
+	var names []string
+	for name := range b.names {
+		if strings.HasPrefix(name, result.Prefix) && name >= result.KeyMarker {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	count := 0
+	lastFolder := ""
+	afterMarker := result.VersionIDMarker == ""
+	for _, name := range names {
+		// Objects within a folder are listed as a single common prefix
+		if result.Delimiter != "" {
+			if i := strings.Index(name[len(result.Prefix):], result.Delimiter); i >= 0 {
+				folder := name[:len(result.Prefix)+i+len(result.Delimiter)]
+				if folder == lastFolder || folder <= result.KeyMarker {
+					continue
+				}
+				lastFolder = folder
+				if count == result.MaxKeys {
+					result.IsTruncated = true
+					return result, nil
+				}
+				result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{folder})
+				result.NextKeyMarker, result.NextVersionIDMarker = folder, ""
+				count++
+				continue
+			}
+		}
+
+		latest := true
+		for _, f := range b.names[name] {
+			entry := s3Version{
+				Key:          name,
+				VersionID:    f.info.FileID,
+				IsLatest:     latest,
+				LastModified: s3Time(f.info.UploadTimestamp),
+			}
+			switch f.info.Action {
+			case "upload":
+				entry.XMLName.Local = "Version"
+				entry.ETag = etag(f.data)
+				entry.Size = f.info.ContentLength
+			case "hide":
+				entry.XMLName.Local = "DeleteMarker"
+			default:
+				continue
+			}
+			latest = false
+
+			if name == result.KeyMarker && !afterMarker {
+				afterMarker = f.info.FileID == result.VersionIDMarker
+				continue
+			}
+			if name == result.KeyMarker && result.VersionIDMarker == "" {
+				continue
+			}
+
+			if count == result.MaxKeys {
+				result.IsTruncated = true
+				return result, nil
+			}
+			result.Entries = append(result.Entries, entry)
+			result.NextKeyMarker, result.NextVersionIDMarker = name, f.info.FileID
+			count++
+		}
+	}
+
+	result.NextKeyMarker, result.NextVersionIDMarker = "", ""
+	return result, nil
+}
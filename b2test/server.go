@@ -0,0 +1,326 @@
+// Package b2test provides an in-memory emulation of the B2 API, for testing
+// code which uses B2 without credentials or network access.
+//
+// The server supports account authorization, bucket management, uploads,
+// downloads by ID and name with ranges, listing file names and versions,
+// hiding, deleting and copying files, and large files. A subset of the
+// S3-compatible API is served at the S3 URL returned by b2_authorize_account,
+// for getting, putting, copying and deleting objects and listing their
+// versions:
+//
+//	server := b2test.NewServer()
+//	defer server.Close()
+//
+//	client := &backblaze.B2{
+//		Credentials: backblaze.Credentials{
+//			AccountID:      server.AccountID,
+//			ApplicationKey: server.ApplicationKey,
+//		},
+//		Host: server.URL,
+//	}
+//
+// NewClient returns a client configured this way, and NewBucket creates a
+// bucket for a test.
+//
+// Files and buckets are kept in memory, and are lost when the server is
+// closed. Permissions, download authorizations and lifecycle rules are not
+// enforced.
+package b2test // import "gopkg.in/kothar/go-backblaze.v0/b2test"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The path of the version of the API emulated by the server
+const v1 = "/b2api/v1/"
+
+// Default credentials accepted by a new server
+const (
+	DefaultAccountID      = "b2testaccount"
+	DefaultApplicationKey = "b2testkey"
+)
+
+// Server is an in-process B2 API emulator, served by an httptest.Server.
+// It may be used by multiple clients concurrently.
+type Server struct {
+	*httptest.Server
+
+	// The credentials accepted by b2_authorize_account. The account ID is
+	// also used as the key ID. The configuration fields may only be changed
+	// before the server is started, see NewUnstartedServer.
+	AccountID      string
+	ApplicationKey string
+
+	// The part sizes returned by b2_authorize_account. The minimum is
+	// enforced for all but the last part of a large file.
+	RecommendedPartSize     int64
+	AbsoluteMinimumPartSize int64
+
+	mutex    sync.Mutex // Guards the fields below
+	tokens   map[string]*token
+	buckets  map[string]*bucket // By ID
+	files    map[string]*file   // All file versions by ID
+	nextID   int
+	faults   []*activeFault
+	injected []InjectedFault
+	random   *rand.Rand
+}
+
+// An authorization token issued by the server
+type token struct {
+	kind   string // "account", "upload" or "part"
+	target string // The bucket or file which an upload token applies to
+	valid  bool
+}
+
+// NewServer starts a server with the default credentials and part sizes.
+// The server should be closed when it is no longer needed.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a server which is not yet listening, so that its
+// configuration can be changed. Call Start to start the server.
+func NewUnstartedServer() *Server {
+	s := &Server{
+		AccountID:               DefaultAccountID,
+		ApplicationKey:          DefaultApplicationKey,
+		RecommendedPartSize:     100 * 1000 * 1000,
+		AbsoluteMinimumPartSize: 5 * 1000 * 1000,
+
+		tokens:  make(map[string]*token),
+		buckets: make(map[string]*bucket),
+		files:   make(map[string]*file),
+		random:  rand.New(rand.NewSource(1)),
+	}
+	s.Server = httptest.NewUnstartedServer(s)
+	return s
+}
+
+// An error response
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func badRequest(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusBadRequest, "bad_request", fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusNotFound, "not_found", fmt.Sprintf(format, args...)}
+}
+
+func fileNotPresent(file string) *apiError {
+	return &apiError{http.StatusBadRequest, "file_not_present", "File not present: " + file}
+}
+
+func unauthorized(code, message string) *apiError {
+	return &apiError{http.StatusUnauthorized, code, message}
+}
+
+// An API call taking a JSON request and returning a JSON response. Handlers
+// are called while holding the server's mutex.
+type apiHandler func(s *Server, body []byte) (interface{}, *apiError)
+
+// API calls which are made to the API URL using an account authorization token
+var apiHandlers = map[string]apiHandler{
+	"b2_create_bucket":       (*Server).createBucket,
+	"b2_delete_bucket":       (*Server).deleteBucket,
+	"b2_list_buckets":        (*Server).listBuckets,
+	"b2_update_bucket":       (*Server).updateBucket,
+	"b2_get_upload_url":      (*Server).getUploadURL,
+	"b2_list_file_names":     (*Server).listFileNames,
+	"b2_list_file_versions":  (*Server).listFileVersions,
+	"b2_get_file_info":       (*Server).getFileInfo,
+	"b2_hide_file":           (*Server).hideFile,
+	"b2_delete_file_version": (*Server).deleteFileVersion,
+	"b2_copy_file":           (*Server).copyFile,
+	"b2_start_large_file":    (*Server).startLargeFile,
+	"b2_get_upload_part_url": (*Server).getUploadPartURL,
+	"b2_finish_large_file":   (*Server).finishLargeFile,
+	"b2_cancel_large_file":   (*Server).cancelLargeFile,
+}
+
+// ServeHTTP handles a request to the emulated API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	api, target := apiName(r)
+
+	w, ok := s.injectFaults(w, r, api)
+	if !ok {
+		return
+	}
+
+	switch api {
+	case "":
+		writeError(w, notFound("Unknown path %s", r.URL.Path))
+	case "b2_authorize_account":
+		s.authorizeAccount(w, r)
+	case "b2_download_file_by_id":
+		s.downloadFileByID(w, r)
+	case "b2_download_file_by_name":
+		s.downloadFileByName(w, r, target)
+	case "b2_upload_file":
+		s.uploadFile(w, r, target)
+	case "b2_upload_part":
+		s.uploadPart(w, r, target)
+	case "GetObject", "PutObject", "CopyObject", "DeleteObject", "ListObjectVersions", "ListObjects":
+		s.handleS3(w, r, api, target)
+	default:
+		s.handleAPI(w, r, api)
+	}
+}
+
+// Returns the name of the API or S3 operation called by a request, and the
+// bucket, file or upload target named in the path if any
+func apiName(r *http.Request) (string, string) {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, s3Path):
+		target := strings.TrimPrefix(path, s3Path)
+		return s3Operation(r, target), target
+	case strings.HasPrefix(path, "/file/"):
+		return "b2_download_file_by_name", strings.TrimPrefix(path, "/file/")
+	case strings.HasPrefix(path, v1+"b2_upload_file/"):
+		return "b2_upload_file", strings.TrimPrefix(path, v1+"b2_upload_file/")
+	case strings.HasPrefix(path, v1+"b2_upload_part/"):
+		return "b2_upload_part", strings.TrimPrefix(path, v1+"b2_upload_part/")
+	case strings.HasPrefix(path, v1):
+		return strings.TrimPrefix(path, v1), ""
+	}
+	return "", ""
+}
+
+// Handles a JSON API call
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request, api string) {
+	handler, ok := apiHandlers[api]
+	if !ok {
+		writeError(w, notFound("Unknown API %s", api))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, &apiError{http.StatusMethodNotAllowed, "method_not_allowed", "Use POST for " + api})
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, badRequest("Unable to read request: %v", err))
+		return
+	}
+
+	s.mutex.Lock()
+	var response interface{}
+	apiErr := s.checkToken(r, "account", "")
+	if apiErr == nil {
+		response, apiErr = handler(s, body)
+	}
+	s.mutex.Unlock()
+
+	if apiErr != nil {
+		writeError(w, apiErr)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) authorizeAccount(w http.ResponseWriter, r *http.Request) {
+	id, key, ok := r.BasicAuth()
+	if !ok {
+		writeError(w, unauthorized("bad_auth_token", "Missing basic authorization"))
+		return
+	}
+	if id != s.AccountID || key != s.ApplicationKey {
+		writeError(w, unauthorized("unauthorized", "Invalid account ID or application key"))
+		return
+	}
+
+	s.mutex.Lock()
+	authToken := s.issueToken("account", "")
+	s.mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, authorizeAccountResponse{
+		AccountID:               s.AccountID,
+		APIURL:                  s.URL,
+		AuthorizationToken:      authToken,
+		DownloadURL:             s.URL,
+		S3APIURL:                s.URL + strings.TrimSuffix(s3Path, "/"),
+		RecommendedPartSize:     s.RecommendedPartSize,
+		AbsoluteMinimumPartSize: s.AbsoluteMinimumPartSize,
+	})
+}
+
+// Creates a new authorization token. Must be called while holding the mutex.
+func (s *Server) issueToken(kind, target string) string {
+	value := fmt.Sprintf("%s_%s", kind, s.newID())
+	s.tokens[value] = &token{kind: kind, target: target, valid: true}
+	return value
+}
+
+// Checks that a request has a valid token of the given kind. Must be called
+// while holding the mutex.
+func (s *Server) checkToken(r *http.Request, kind, target string) *apiError {
+	value := r.Header.Get("Authorization")
+	if value == "" {
+		return unauthorized("bad_auth_token", "Missing authorization token")
+	}
+	t, ok := s.tokens[value]
+	if !ok || t.kind != kind || t.target != target {
+		return unauthorized("bad_auth_token", "Invalid authorization token")
+	}
+	if !t.valid {
+		return unauthorized("expired_auth_token", "Authorization token has expired")
+	}
+	return nil
+}
+
+// Returns a new unique ID. Must be called while holding the mutex.
+func (s *Server) newID() string {
+	s.nextID++
+	return fmt.Sprintf("%024x", s.nextID)
+}
+
+// Returns the current time in milliseconds since the epoch
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Decodes a JSON request body
+func decodeRequest(body []byte, request interface{}) *apiError {
+	if err := json.Unmarshal(body, request); err != nil {
+		return badRequest("Invalid request: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(&apiError{status, "internal_error", err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, err *apiError) {
+	writeJSON(w, err.Status, err)
+}
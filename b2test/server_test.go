@@ -0,0 +1,197 @@
+package b2test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+func TestServer(T *testing.T) {
+	server := NewUnstartedServer()
+	server.AbsoluteMinimumPartSize = 5
+	server.Start()
+	defer server.Close()
+	client := server.NewClient()
+	download := ReadDownload(T)
+
+	bucket := CreateBucket(T, client, "test-bucket")
+	if _, err := client.CreateBucket("test-bucket", backblaze.AllPrivate); !errors.Is(err, backblaze.ErrDuplicateBucketName) {
+		T.Errorf("Expected a duplicate bucket name error, saw %v", err)
+	}
+
+	// Upload two versions of a file, and some files in folders
+	meta := map[string]string{"src_last_modified_millis": "1234"}
+	first, err := bucket.UploadFile("a.txt", meta, bytes.NewReader([]byte("first version")))
+	if err != nil {
+		T.Fatal(err)
+	}
+	second, err := bucket.UploadFile("a.txt", meta, bytes.NewReader([]byte("second version")))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if second.ContentType != "text/plain" {
+		T.Errorf("Expected content type text/plain, saw %q", second.ContentType)
+	}
+	for _, name := range []string{"dir/b", "dir/c", "dir/sub/d"} {
+		if _, err := bucket.UploadTypedFile(name, "application/octet-stream", nil, bytes.NewReader([]byte(name))); err != nil {
+			T.Fatal(err)
+		}
+	}
+
+	// Download by name with a range, and by ID
+	file, data := download(bucket.DownloadFileRangeByName("a.txt", &backblaze.FileRange{Start: 7, End: 13}))
+	if string(data) != "version" {
+		T.Errorf("Expected range of second version, saw %q", data)
+	}
+	if file.FileInfo["src_last_modified_millis"] != "1234" {
+		T.Errorf("File info was not returned: %v", file.FileInfo)
+	}
+	_, data = download(client.DownloadFileByID(first.ID))
+	if string(data) != "first version" {
+		T.Errorf("Expected first version, saw %q", data)
+	}
+
+	// List names, with and without a delimiter, one file per page
+	var names []string
+	start := ""
+	for {
+		response, err := bucket.ListFileNamesWithPrefix(start, 1, "", "/")
+		if err != nil {
+			T.Fatal(err)
+		}
+		for _, f := range response.Files {
+			names = append(names, f.Name)
+		}
+		if response.NextFileName == "" {
+			break
+		}
+		start = response.NextFileName
+	}
+	if len(names) != 2 || names[0] != "a.txt" || names[1] != "dir/" {
+		T.Errorf("Unexpected folder listing: %v", names)
+	}
+
+	names = nil
+	response, err := bucket.ListFileNamesWithPrefix("", 100, "dir/", "")
+	if err != nil {
+		T.Fatal(err)
+	}
+	for _, f := range response.Files {
+		names = append(names, f.Name)
+	}
+	if len(names) != 3 || names[2] != "dir/sub/d" {
+		T.Errorf("Unexpected prefix listing: %v", names)
+	}
+
+	// Hide the file, leaving its versions
+	if _, err := bucket.HideFile("a.txt"); err != nil {
+		T.Fatal(err)
+	}
+	if _, _, err := bucket.DownloadFileByName("a.txt"); !errors.Is(err, backblaze.ErrNotFound) {
+		T.Errorf("Expected hidden file to be not found, saw %v", err)
+	}
+	versions, err := bucket.ListFileVersionsWithPrefix("", "", 100, "a.txt", "")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if len(versions.Files) != 3 || versions.Files[0].Action != backblaze.Hide || versions.Files[2].ID != first.ID {
+		T.Errorf("Unexpected versions: %+v", versions.Files)
+	}
+
+	// Page through versions
+	page, err := bucket.ListFileVersions("a.txt", "", 2)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if page.NextFileName != "a.txt" || page.NextFileID != first.ID {
+		T.Errorf("Expected next version to be %s, saw %s %s", first.ID, page.NextFileName, page.NextFileID)
+	}
+	page, err = bucket.ListFileVersions(page.NextFileName, page.NextFileID, 2)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if len(page.Files) != 2 || page.Files[0].ID != first.ID {
+		T.Errorf("Unexpected second page of versions: %+v", page.Files)
+	}
+
+	// Copy an old version
+	copied, err := bucket.CopyFile(first.ID, "copy.txt", "", backblaze.FileMetaDirectiveCopy)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if copied.FileInfo["src_last_modified_millis"] != "1234" {
+		T.Errorf("File info was not copied: %v", copied.FileInfo)
+	}
+	_, data = download(bucket.DownloadFileByName("copy.txt"))
+	if string(data) != "first version" {
+		T.Errorf("Expected copy of first version, saw %q", data)
+	}
+
+	// Upload a large file
+	large := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	largeFile, err := bucket.UploadLargeFileOptions("large", "b2/x-auto", nil, bytes.NewReader(large), int64(len(large)), 10, 2)
+	if err != nil {
+		T.Fatal(err)
+	}
+	_, data = download(client.DownloadFileRangeByID(largeFile.ID, &backblaze.FileRange{Start: 30, End: 35}))
+	if string(data) != "uvwxyz" {
+		T.Errorf("Expected end of large file, saw %q", data)
+	}
+
+	// Buckets can only be deleted once empty
+	if err := bucket.Delete(); !errors.Is(err, backblaze.ErrBucketNotEmpty) {
+		T.Errorf("Expected bucket not empty error, saw %v", err)
+	}
+	versions, err = bucket.ListFileVersions("", "", 100)
+	if err != nil {
+		T.Fatal(err)
+	}
+	for _, f := range versions.Files {
+		if _, err := bucket.DeleteFileVersion(f.Name, f.ID); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if err := bucket.Delete(); err != nil {
+		T.Fatal(err)
+	}
+}
+
+func TestServerAuthorization(T *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	client := &backblaze.B2{
+		Credentials: backblaze.Credentials{
+			AccountID:      server.AccountID,
+			ApplicationKey: "wrong",
+		},
+		Host: server.URL,
+	}
+	if err := client.AuthorizeAccount(); !errors.Is(err, backblaze.ErrUnauthorized) {
+		T.Errorf("Expected authorization to fail, saw %v", err)
+	}
+
+	// Public files can be downloaded without authorization
+	client = server.NewClient()
+	bucket, err := client.CreateBucket("public-bucket", backblaze.AllPublic)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if _, err := bucket.UploadFile("public", nil, bytes.NewReader([]byte("public"))); err != nil {
+		T.Fatal(err)
+	}
+	fileURL, err := bucket.FileURL("public")
+	if err != nil {
+		T.Fatal(err)
+	}
+	resp, err := server.Client().Get(fileURL)
+	if err != nil {
+		T.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		T.Errorf("Expected public download to succeed, saw %s", resp.Status)
+	}
+}
@@ -0,0 +1,147 @@
+package b2test
+
+// Request and response bodies of the emulated API
+
+type authorizeAccountResponse struct {
+	AccountID               string `json:"accountId"`
+	APIURL                  string `json:"apiUrl"`
+	AuthorizationToken      string `json:"authorizationToken"`
+	DownloadURL             string `json:"downloadUrl"`
+	RecommendedPartSize     int64  `json:"recommendedPartSize"`
+	AbsoluteMinimumPartSize int64  `json:"absoluteMinimumPartSize"`
+	S3APIURL                string `json:"s3ApiUrl"`
+}
+
+type lifecycleRule struct {
+	DaysFromUploadingToHiding int    `json:"daysFromUploadingToHiding"`
+	DaysFromHidingToDeleting  int    `json:"daysFromHidingToDeleting"`
+	FileNamePrefix            string `json:"fileNamePrefix"`
+}
+
+type bucketInfo struct {
+	AccountID      string            `json:"accountId"`
+	BucketID       string            `json:"bucketId"`
+	BucketName     string            `json:"bucketName"`
+	BucketType     string            `json:"bucketType"`
+	BucketInfo     map[string]string `json:"bucketInfo"`
+	LifecycleRules []lifecycleRule   `json:"lifecycleRules"`
+	Revision       int               `json:"revision"`
+}
+
+type createBucketRequest struct {
+	AccountID      string            `json:"accountId"`
+	BucketName     string            `json:"bucketName"`
+	BucketType     string            `json:"bucketType"`
+	BucketInfo     map[string]string `json:"bucketInfo"`
+	LifecycleRules []lifecycleRule   `json:"lifecycleRules"`
+}
+
+type bucketRequest struct {
+	AccountID string `json:"accountId"`
+	BucketID  string `json:"bucketId"`
+}
+
+type listBucketsRequest struct {
+	AccountID  string `json:"accountId"`
+	BucketID   string `json:"bucketId"`
+	BucketName string `json:"bucketName"`
+}
+
+type listBucketsResponse struct {
+	Buckets []bucketInfo `json:"buckets"`
+}
+
+type updateBucketRequest struct {
+	AccountID      string             `json:"accountId"`
+	BucketID       string             `json:"bucketId"`
+	BucketType     string             `json:"bucketType"`
+	BucketInfo     *map[string]string `json:"bucketInfo"`
+	LifecycleRules *[]lifecycleRule   `json:"lifecycleRules"`
+	IfRevisionIs   int                `json:"ifRevisionIs"`
+}
+
+type uploadURLResponse struct {
+	BucketID           string `json:"bucketId,omitempty"`
+	FileID             string `json:"fileId,omitempty"`
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+type fileInfo struct {
+	AccountID       string            `json:"accountId"`
+	Action          string            `json:"action"`
+	BucketID        string            `json:"bucketId"`
+	ContentLength   int64             `json:"contentLength"`
+	ContentSha1     string            `json:"contentSha1"`
+	ContentType     string            `json:"contentType"`
+	FileID          string            `json:"fileId"`
+	FileInfo        map[string]string `json:"fileInfo"`
+	FileName        string            `json:"fileName"`
+	Size            int64             `json:"size"`
+	UploadTimestamp int64             `json:"uploadTimestamp"`
+}
+
+type listFilesRequest struct {
+	BucketID      string `json:"bucketId"`
+	StartFileName string `json:"startFileName"`
+	StartFileID   string `json:"startFileId"`
+	MaxFileCount  int    `json:"maxFileCount"`
+	Prefix        string `json:"prefix"`
+	Delimiter     string `json:"delimiter"`
+}
+
+type listFilesResponse struct {
+	Files        []fileInfo `json:"files"`
+	NextFileName *string    `json:"nextFileName"`
+	NextFileID   *string    `json:"nextFileId,omitempty"`
+}
+
+type fileRequest struct {
+	FileID string `json:"fileId"`
+}
+
+type fileVersionRequest struct {
+	FileName string `json:"fileName"`
+	FileID   string `json:"fileId"`
+}
+
+type hideFileRequest struct {
+	BucketID string `json:"bucketId"`
+	FileName string `json:"fileName"`
+}
+
+type copyFileRequest struct {
+	SourceFileID        string            `json:"sourceFileId"`
+	DestinationBucketID string            `json:"destinationBucketId"`
+	FileName            string            `json:"fileName"`
+	Range               string            `json:"range"`
+	MetadataDirective   string            `json:"metadataDirective"`
+	ContentType         string            `json:"contentType"`
+	FileInfo            map[string]string `json:"fileInfo"`
+}
+
+type startLargeFileRequest struct {
+	BucketID    string            `json:"bucketId"`
+	FileName    string            `json:"fileName"`
+	ContentType string            `json:"contentType"`
+	FileInfo    map[string]string `json:"fileInfo"`
+}
+
+type uploadPartResponse struct {
+	FileID        string `json:"fileId"`
+	PartNumber    int    `json:"partNumber"`
+	ContentLength int64  `json:"contentLength"`
+	ContentSha1   string `json:"contentSha1"`
+}
+
+type finishLargeFileRequest struct {
+	FileID        string   `json:"fileId"`
+	PartSha1Array []string `json:"partSha1Array"`
+}
+
+type cancelLargeFileResponse struct {
+	FileID    string `json:"fileId"`
+	AccountID string `json:"accountId"`
+	BucketID  string `json:"bucketId"`
+	FileName  string `json:"fileName"`
+}
@@ -0,0 +1,542 @@
+// Package b2webdav implements a webdav.FileSystem over the files in a bucket,
+// so that a bucket can be mounted by desktop WebDAV clients.
+//
+//	handler := &webdav.Handler{
+//		FileSystem: b2webdav.NewFileSystem(bucket),
+//		LockSystem: webdav.NewMemLS(),
+//	}
+//
+// B2 has no directories, so folders are emulated using the prefixes of file
+// names. A folder exists while it contains files, and new folders are
+// created by uploading an empty .bzEmpty file as the B2 web interface does.
+//
+// Files are written to temporary files and uploaded when they are closed.
+// Removing or renaming a file hides it, so its earlier versions are kept,
+// and renaming copies it to the new name first.
+package b2webdav // import "gopkg.in/kothar/go-backblaze.v0/b2webdav"
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+)
+
+// The name of the empty file uploaded to create a folder. It is not listed.
+const folderMarker = ".bzEmpty"
+
+var (
+	errIsDir  = errors.New("is a directory")
+	errNotDir = errors.New("not a directory")
+)
+
+// FileSystem is a webdav.FileSystem which stores files in a bucket
+type FileSystem struct {
+	Bucket *backblaze.Bucket
+
+	// The folder containing the files to serve, ending with a slash. Other
+	// files in the bucket can't be accessed.
+	Prefix string
+
+	// The directory used for temporary files while writing. Defaults to
+	// os.TempDir.
+	TempDir string
+
+	// Files at least this large are uploaded in parts using UploadLargeFile,
+	// if they can be split into at least two parts. Defaults to 200MB.
+	LargeFileSize int64
+}
+
+// NewFileSystem returns a FileSystem for the files in a bucket
+func NewFileSystem(bucket *backblaze.Bucket) *FileSystem {
+	return &FileSystem{
+		Bucket:        bucket,
+		LargeFileSize: 200 * 1000 * 1000,
+	}
+}
+
+// Returns the name of a file in the bucket, and the prefix of the files in
+// it if it is a folder
+func (fs *FileSystem) resolve(name string) (fileName, folderPrefix string) {
+	rel := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if rel == "" {
+		return "", fs.Prefix
+	}
+	return fs.Prefix + rel, fs.Prefix + rel + "/"
+}
+
+func pathError(op, name string, err error) error {
+	if errors.Is(err, backblaze.ErrNotFound) {
+		err = os.ErrNotExist
+	}
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// Stat returns information about a file or folder
+func (fs *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fs.stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (fs *FileSystem) stat(ctx context.Context, name string) (*fileInfo, error) {
+	fileName, folderPrefix := fs.resolve(name)
+	if fileName == "" {
+		return &fileInfo{name: "/", dir: true}, nil
+	}
+
+	file, err := fs.Bucket.Stat(ctx, fileName)
+	if err == nil {
+		return newFileInfo(file), nil
+	}
+	if !errors.Is(err, backblaze.ErrNotFound) {
+		return nil, pathError("stat", name, err)
+	}
+
+	response, err := fs.Bucket.ListFileNamesWithPrefix("", 1, folderPrefix, "")
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	if len(response.Files) == 0 {
+		return nil, pathError("stat", name, os.ErrNotExist)
+	}
+	return &fileInfo{name: path.Base(fileName), dir: true}, nil
+}
+
+// Mkdir creates a folder by uploading an empty marker file to it. The
+// parent folder must exist.
+func (fs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	fileName, folderPrefix := fs.resolve(name)
+	if fileName == "" {
+		return pathError("mkdir", name, os.ErrExist)
+	}
+	if _, err := fs.stat(ctx, name); err == nil {
+		return pathError("mkdir", name, os.ErrExist)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	parent, err := fs.stat(ctx, path.Dir(path.Clean("/"+name)))
+	if err != nil {
+		return err
+	}
+	if !parent.dir {
+		return pathError("mkdir", name, errNotDir)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := fs.Bucket.UploadFile(folderPrefix+folderMarker, nil, bytes.NewReader(nil)); err != nil {
+		return pathError("mkdir", name, err)
+	}
+	return nil
+}
+
+// OpenFile opens a file or folder. Files opened for writing are written to a
+// temporary file, which is uploaded when closed.
+func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	info, err := fs.stat(ctx, name)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		switch {
+		case !exists:
+			return nil, err
+		case info.dir:
+			return &folder{fs: fs, ctx: ctx, name: name, info: info}, nil
+		default:
+			return &readFile{fs: fs, ctx: ctx, info: info}, nil
+		}
+	}
+
+	switch {
+	case exists && info.dir:
+		return nil, pathError("open", name, errIsDir)
+	case exists && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, pathError("open", name, os.ErrExist)
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, err
+	}
+	return fs.openWrite(ctx, name, info, flag)
+}
+
+// RemoveAll hides a file, or all of the files in a folder
+func (fs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	fileName, folderPrefix := fs.resolve(name)
+	if fileName == "" {
+		return pathError("remove", name, os.ErrPermission)
+	}
+	info, err := fs.stat(ctx, name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	names := []string{fileName}
+	if info.dir {
+		names = nil
+		err := fs.Bucket.List(ctx, folderPrefix, "", func(file *backblaze.File) error {
+			names = append(names, file.Name)
+			return nil
+		})
+		if err != nil {
+			return pathError("remove", name, err)
+		}
+	}
+
+	for _, fileName := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := fs.Bucket.HideFile(fileName); err != nil {
+			return pathError("remove", name, err)
+		}
+	}
+	return nil
+}
+
+// Rename copies a file, or all of the files in a folder, to a new name and
+// hides the originals
+func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldFileName, oldPrefix := fs.resolve(oldName)
+	newFileName, newPrefix := fs.resolve(newName)
+	if oldFileName == "" || newFileName == "" {
+		return pathError("rename", oldName, os.ErrPermission)
+	}
+	info, err := fs.stat(ctx, oldName)
+	if err != nil {
+		return err
+	}
+
+	// Pairs of names to copy from and to
+	var files []*backblaze.File
+	var names []string
+	if info.dir {
+		if strings.HasPrefix(newPrefix, oldPrefix) {
+			return pathError("rename", oldName, os.ErrInvalid)
+		}
+		err := fs.Bucket.List(ctx, oldPrefix, "", func(file *backblaze.File) error {
+			files = append(files, file)
+			names = append(names, newPrefix+strings.TrimPrefix(file.Name, oldPrefix))
+			return nil
+		})
+		if err != nil {
+			return pathError("rename", oldName, err)
+		}
+	} else {
+		files = []*backblaze.File{info.file}
+		names = []string{newFileName}
+	}
+
+	// Copy everything before hiding anything, so nothing is lost on failure
+	for i, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := fs.Bucket.CopyFile(file.ID, names[i], "", backblaze.FileMetaDirectiveCopy); err != nil {
+			return pathError("rename", oldName, err)
+		}
+	}
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := fs.Bucket.HideFile(file.Name); err != nil {
+			return pathError("rename", oldName, err)
+		}
+	}
+	return nil
+}
+
+// Describes a file or folder
+type fileInfo struct {
+	name     string
+	size     int64
+	modified time.Time
+	dir      bool
+	file     *backblaze.File // Set for files in the bucket
+}
+
+func newFileInfo(file *backblaze.File) *fileInfo {
+	return &fileInfo{
+		name:     path.Base(file.Name),
+		size:     file.ContentLength,
+		modified: file.ModTime(),
+		file:     file,
+	}
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return i.size }
+func (i *fileInfo) ModTime() time.Time { return i.modified }
+func (i *fileInfo) IsDir() bool        { return i.dir }
+func (i *fileInfo) Sys() interface{}   { return i.file }
+
+func (i *fileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ContentType returns the content type a file was uploaded with, so that
+// files don't need to be downloaded to find their type
+func (i *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if i.file == nil || i.file.ContentType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.file.ContentType, nil
+}
+
+// A folder opened for listing
+type folder struct {
+	fs      *FileSystem
+	ctx     context.Context
+	name    string
+	info    *fileInfo
+	entries []os.FileInfo
+	listed  bool
+}
+
+func (f *folder) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.listed {
+		_, prefix := f.fs.resolve(f.name)
+		err := f.fs.Bucket.List(f.ctx, prefix, "/", func(file *backblaze.File) error {
+			switch {
+			case file.Action == backblaze.Folder:
+				f.entries = append(f.entries, &fileInfo{name: path.Base(file.Name), dir: true})
+			case path.Base(file.Name) != folderMarker:
+				f.entries = append(f.entries, newFileInfo(file))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, pathError("readdir", f.name, err)
+		}
+		f.listed = true
+	}
+
+	if count <= 0 {
+		entries := f.entries
+		f.entries = nil
+		return entries, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	entries := f.entries[:count]
+	f.entries = f.entries[count:]
+	return entries, nil
+}
+
+func (f *folder) Stat() (os.FileInfo, error) { return f.info, nil }
+func (f *folder) Close() error               { return nil }
+
+func (f *folder) Read(p []byte) (int, error) {
+	return 0, pathError("read", f.name, errIsDir)
+}
+
+func (f *folder) Write(p []byte) (int, error) {
+	return 0, pathError("write", f.name, errIsDir)
+}
+
+func (f *folder) Seek(offset int64, whence int) (int64, error) {
+	return 0, pathError("seek", f.name, errIsDir)
+}
+
+// A file opened for reading. Its content is downloaded from the current
+// offset when first read, and again after seeking.
+type readFile struct {
+	fs         *FileSystem
+	ctx        context.Context
+	info       *fileInfo
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body != nil && f.bodyOffset != f.offset {
+		f.body.Close()
+		f.body = nil
+	}
+	if f.body == nil {
+		fileRange := &backblaze.FileRange{Start: f.offset, End: f.info.size - 1}
+		_, body, err := f.fs.Bucket.GetRange(f.ctx, f.info.file.Name, fileRange)
+		if err != nil {
+			return 0, pathError("read", f.info.file.Name, err)
+		}
+		f.body, f.bodyOffset = body, f.offset
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	f.bodyOffset += int64(n)
+	if err == io.EOF && f.offset < f.info.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, pathError("seek", f.info.file.Name, os.ErrInvalid)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *readFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, pathError("readdir", f.info.file.Name, errNotDir)
+}
+
+func (f *readFile) Write(p []byte) (int, error) {
+	return 0, pathError("write", f.info.file.Name, os.ErrPermission)
+}
+
+// A file opened for writing, buffered in a temporary file
+type writeFile struct {
+	*os.File
+	fs       *FileSystem
+	ctx      context.Context
+	name     string
+	fileName string
+	modified time.Time
+}
+
+// Opens a temporary file to write to, containing the existing content of the
+// file unless it is being truncated
+func (fs *FileSystem) openWrite(ctx context.Context, name string, info *fileInfo, flag int) (*writeFile, error) {
+	fileName, _ := fs.resolve(name)
+	temp, err := ioutil.TempFile(fs.TempDir, "b2webdav")
+	if err != nil {
+		return nil, err
+	}
+	f := &writeFile{
+		File:     temp,
+		fs:       fs,
+		ctx:      ctx,
+		name:     name,
+		fileName: fileName,
+		modified: time.Now().Truncate(time.Millisecond),
+	}
+
+	if info != nil && flag&os.O_TRUNC == 0 {
+		err = f.download(flag&os.O_APPEND != 0)
+	}
+	if err != nil {
+		f.discard()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *writeFile) download(atEnd bool) error {
+	_, body, err := f.fs.Bucket.Get(f.ctx, f.fileName)
+	if err != nil {
+		return pathError("open", f.name, err)
+	}
+	defer body.Close()
+	if _, err := io.Copy(f.File, body); err != nil {
+		return err
+	}
+	if !atEnd {
+		_, err = f.File.Seek(0, io.SeekStart)
+	}
+	return err
+}
+
+func (f *writeFile) discard() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	f.modified = time.Now().Truncate(time.Millisecond)
+	return f.File.Write(p)
+}
+
+// Stat describes the file as it will be uploaded
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(f.fileName), size: info.Size(), modified: f.modified}, nil
+}
+
+func (f *writeFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, pathError("readdir", f.name, errNotDir)
+}
+
+// Close uploads the file, recording the time it was last written
+func (f *writeFile) Close() error {
+	defer f.discard()
+
+	info, err := f.File.Stat()
+	if err == nil {
+		err = f.ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	meta := map[string]string{
+		"src_last_modified_millis": strconv.FormatInt(f.modified.UnixNano()/int64(time.Millisecond), 10),
+	}
+	size := info.Size()
+	large := f.fs.LargeFileSize > 0 && size >= f.fs.LargeFileSize
+	if large {
+		_, err = f.fs.Bucket.UploadLargeFile(f.fileName, "b2/x-auto", meta, f.File, size)
+
+		// Files which can't be split into parts are uploaded whole
+		large = !errors.Is(err, backblaze.ErrLargeFileTooSmall)
+	}
+	if !large {
+		if _, err = f.File.Seek(0, io.SeekStart); err == nil {
+			_, err = f.fs.Bucket.UploadFile(f.fileName, meta, f.File)
+		}
+	}
+	if err != nil {
+		return pathError("close", f.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package b2webdav
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+	"gopkg.in/kothar/go-backblaze.v0/b2test"
+)
+
+func newFileSystem(T *testing.T) (*FileSystem, func()) {
+	server := b2test.NewServer()
+	return NewFileSystem(server.NewBucket(T, "webdav-bucket")), server.Close
+}
+
+func TestFileSystem(T *testing.T) {
+	fs, closeServer := newFileSystem(T)
+	defer closeServer()
+	ctx := context.Background()
+
+	write := func(name, content string) {
+		T.Helper()
+		f, err := fs.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			T.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			T.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			T.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		T.Helper()
+		f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+		if err != nil {
+			T.Fatal(err)
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			T.Fatal(err)
+		}
+		return string(data)
+	}
+	list := func(name string) string {
+		T.Helper()
+		f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+		if err != nil {
+			T.Fatal(err)
+		}
+		defer f.Close()
+		infos, err := f.Readdir(0)
+		if err != nil {
+			T.Fatal(err)
+		}
+		var names []string
+		for _, info := range infos {
+			n := info.Name()
+			if info.IsDir() {
+				n += "/"
+			}
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return strings.Join(names, " ")
+	}
+
+	// Folders
+	if err := fs.Mkdir(ctx, "/docs", 0777); err != nil {
+		T.Fatal(err)
+	}
+	if err := fs.Mkdir(ctx, "/docs", 0777); !os.IsExist(err) {
+		T.Errorf("Expected existing folder error, saw %v", err)
+	}
+	if err := fs.Mkdir(ctx, "/missing/sub", 0777); !os.IsNotExist(err) {
+		T.Errorf("Expected missing parent error, saw %v", err)
+	}
+	if info, err := fs.Stat(ctx, "/docs"); err != nil || !info.IsDir() {
+		T.Errorf("Expected folder, saw %v %v", info, err)
+	}
+
+	// Files
+	write("/docs/a.txt", "hello world")
+	write("/docs/sub/b.txt", "b")
+	if content := read("/docs/a.txt"); content != "hello world" {
+		T.Errorf("Unexpected content %q", content)
+	}
+	if listed := list("/docs"); listed != "a.txt sub/" {
+		T.Errorf("Unexpected listing %s", listed)
+	}
+	if listed := list("/"); listed != "docs/" {
+		T.Errorf("Unexpected root listing %s", listed)
+	}
+	info, err := fs.Stat(ctx, "/docs/a.txt")
+	if err != nil || info.IsDir() || info.Size() != 11 {
+		T.Errorf("Unexpected file info %v %v", info, err)
+	}
+	if _, err := fs.Stat(ctx, "/docs/missing"); !os.IsNotExist(err) {
+		T.Errorf("Expected missing file error, saw %v", err)
+	}
+
+	// Seeking re-downloads from the new offset
+	f, err := fs.OpenFile(ctx, "/docs/a.txt", os.O_RDONLY, 0)
+	if err != nil {
+		T.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := f.Seek(6, 0); err != nil {
+		T.Fatal(err)
+	}
+	if n, err := f.Read(buf); err != nil && err != io.EOF || string(buf[:n]) != "world" {
+		T.Errorf("Unexpected read after seek %q %v", buf[:n], err)
+	}
+	f.Close()
+
+	// Appending downloads the existing content
+	f, err = fs.OpenFile(ctx, "/docs/a.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if _, err := f.Write([]byte("!")); err != nil {
+		T.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		T.Fatal(err)
+	}
+	if content := read("/docs/a.txt"); content != "hello world!" {
+		T.Errorf("Unexpected content after append %q", content)
+	}
+
+	// Files too small to upload in parts are uploaded whole
+	largeFileSize := fs.LargeFileSize
+	fs.LargeFileSize = 1
+	write("/docs/small.txt", "small")
+	if content := read("/docs/small.txt"); content != "small" {
+		T.Errorf("Unexpected content of small file %q", content)
+	}
+	if err := fs.RemoveAll(ctx, "/docs/small.txt"); err != nil {
+		T.Fatal(err)
+	}
+	fs.LargeFileSize = largeFileSize
+
+	// Rename and remove
+	if err := fs.Rename(ctx, "/docs", "/moved"); err != nil {
+		T.Fatal(err)
+	}
+	if listed := list("/"); listed != "moved/" {
+		T.Errorf("Unexpected listing after rename %s", listed)
+	}
+	if content := read("/moved/sub/b.txt"); content != "b" {
+		T.Errorf("Unexpected content after rename %q", content)
+	}
+	if err := fs.Rename(ctx, "/moved/a.txt", "/a.txt"); err != nil {
+		T.Fatal(err)
+	}
+	if err := fs.RemoveAll(ctx, "/moved"); err != nil {
+		T.Fatal(err)
+	}
+	if listed := list("/"); listed != "a.txt" {
+		T.Errorf("Unexpected listing after remove %s", listed)
+	}
+
+	// Removed files are hidden, so earlier versions are kept
+	versions, err := fs.Bucket.ListFileVersions("docs/a.txt", "", 10)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if len(versions.Files) == 0 || versions.Files[0].Name != "docs/a.txt" || versions.Files[0].Action != backblaze.Hide {
+		T.Errorf("Expected renamed file to be hidden, saw %+v", versions.Files)
+	}
+}
+
+func TestHandler(T *testing.T) {
+	fs, closeServer := newFileSystem(T)
+	defer closeServer()
+	fs.Prefix = "dav/"
+
+	web := httptest.NewServer(&webdav.Handler{
+		FileSystem: fs,
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer web.Close()
+
+	do := func(method, path, body string, header map[string]string) (*http.Response, string) {
+		T.Helper()
+		req, err := http.NewRequest(method, web.URL+path, strings.NewReader(body))
+		if err != nil {
+			T.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			T.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			T.Fatal(err)
+		}
+		return resp, string(data)
+	}
+
+	if resp, _ := do("MKCOL", "/folder", "", nil); resp.StatusCode != http.StatusCreated {
+		T.Errorf("Unexpected MKCOL status %d", resp.StatusCode)
+	}
+	resp, _ := do("PUT", "/folder/page.html", "<p>hello</p>", nil)
+	if resp.StatusCode != http.StatusCreated {
+		T.Fatalf("Unexpected PUT status %d", resp.StatusCode)
+	}
+	etag := resp.Header.Get("ETag")
+
+	resp, body := do("GET", "/folder/page.html", "", nil)
+	if resp.StatusCode != 200 || body != "<p>hello</p>" || resp.Header.Get("ETag") != etag ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		T.Errorf("Unexpected GET response %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	resp, body = do("GET", "/folder/page.html", "", map[string]string{"Range": "bytes=3-7"})
+	if resp.StatusCode != http.StatusPartialContent || body != "hello" {
+		T.Errorf("Unexpected range response %d %q", resp.StatusCode, body)
+	}
+
+	resp, body = do("PROPFIND", "/folder/", "", map[string]string{"Depth": "1"})
+	if resp.StatusCode != http.StatusMultiStatus || !strings.Contains(body, "/folder/page.html") || strings.Contains(body, folderMarker) {
+		T.Errorf("Unexpected PROPFIND response %d: %s", resp.StatusCode, body)
+	}
+
+	resp, _ = do("MOVE", "/folder/page.html", "", map[string]string{"Destination": web.URL + "/index.html"})
+	if resp.StatusCode != http.StatusCreated {
+		T.Errorf("Unexpected MOVE status %d", resp.StatusCode)
+	}
+	if resp, _ := do("DELETE", "/folder", "", nil); resp.StatusCode != http.StatusNoContent {
+		T.Errorf("Unexpected DELETE status %d", resp.StatusCode)
+	}
+
+	// Only the files under the prefix are visible, with the prefix in the bucket
+	listing, err := fs.Bucket.ListFileNames("", 10)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if len(listing.Files) != 1 || listing.Files[0].Name != "dav/index.html" {
+		T.Errorf("Unexpected files in bucket %+v", listing.Files)
+	}
+}
@@ -3,11 +3,13 @@ package backblaze // import "gopkg.in/kothar/go-backblaze.v0"
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/pquerna/ffjson/ffjson"
 )
@@ -34,35 +36,89 @@ type Credentials struct {
 	KeyID          string
 }
 
-// B2 implements a B2 API client. Do not modify state concurrently.
+// B2 implements a B2 API client.
+//
+// A client may be shared by multiple goroutines. The configuration fields
+// below should be set before the client is first used, and not modified
+// while requests are in progress.
 type B2 struct {
 	Credentials
 
+	// The URL of the service used to authorize the account. Defaults to the
+	// B2 API. May be set to use a compatible service, or a local emulator
+	// such as the one provided by the b2test package.
+	Host string
+
+	// The protocol used to list, upload, download, copy, hide and delete
+	// files in buckets. Defaults to the native B2 API. Account authorization,
+	// bucket management, large files and downloads by file ID always use the
+	// native API, so a key used with ProtocolS3 must be valid for both.
+	Protocol Protocol
+
+	// The endpoint of the S3-compatible API, such as
+	// https://s3.us-west-004.backblazeb2.com, and the region used to sign
+	// requests to it. By default the endpoint returned when authorizing the
+	// account is used, and the region is taken from its host name.
+	S3Endpoint string
+	S3Region   string
+
 	// If true, don't retry requests if authorization has expired
 	NoRetry bool
 
 	// If true, display debugging information about API calls
 	Debug bool
 
-	// Number of MaxIdleUploads to keep for reuse.
-	// This must be set prior to creating a bucket struct
+	// If set, the hook will be notified of every request made by the client
+	Hook RequestHook
+
+	// How long before the authorization token expires to request a new one.
+	// Defaults to DefaultTokenRefreshMargin if zero. If negative, the token
+	// will only be refreshed once it has expired.
+	TokenRefreshMargin time.Duration
+
+	// If set, called each time the client authorizes the account. Requests
+	// waiting for the authorization will continue once this returns.
+	OnAuthorization func(event AuthorizationEvent)
+
+	// If set, authorizations are saved in the store and reused by clients
+	// with the same credentials until they expire or are rejected
+	TokenStore TokenStore
+
+	// Number of upload URLs to keep for reuse in each bucket. Upload URLs
+	// are shared by all Bucket instances created by the client.
 	MaxIdleUploads int
 
 	// State
-	mutex      sync.Mutex
-	host       string
-	auth       *authorizationState
-	httpClient http.Client
+	usage         usageCounter
+	mutex         sync.Mutex // Guards AccountID once set, host, auth, authorizing, refreshFailed and uploads
+	host          string
+	auth          *authorizationState
+	authorizing   *authorizationCall
+	refreshFailed time.Time
+	clock         func() time.Time
+	uploads       uploadPool
+	httpClient    http.Client
 }
 
 // The current auth state of the client. Can be individually invalidated by
 // requests which fail, tringgering a reauth the next time its validity is
-// checked
+// checked. The authorizeAccountResponse is not modified once the state has
+// been created, so may be read without holding the lock.
 type authorizationState struct {
 	sync.Mutex
 	*authorizeAccountResponse
 
-	valid bool
+	issued time.Time
+	valid  bool
+}
+
+// An authorization request in progress. Callers which need a new
+// authorization while one is in progress wait for its result instead of
+// making their own request.
+type authorizationCall struct {
+	done chan struct{}
+	auth *authorizationState
+	err  error
 }
 
 func (a *authorizationState) isValid() bool {
@@ -87,7 +143,6 @@ func (a *authorizationState) invalidate() {
 	defer a.Unlock()
 
 	a.valid = false
-	a.authorizeAccountResponse = nil
 }
 
 // NewB2 creates a new Client for accessing the B2 API.
@@ -107,99 +162,222 @@ func NewB2(creds Credentials) (*B2, error) {
 }
 
 // AuthorizeAccount is used to log in to the B2 API.
+//
+// If AccountID has not been set, it will be set to the ID of the authorized
+// account.
 func (c *B2) AuthorizeAccount() error {
+	auth, err := c.authorize(true)
+	if err != nil {
+		return err
+	}
+
+	c.setAccountID(auth)
+	return nil
+}
+
+// Sets AccountID to the value returned by b2_authorize_account if it has not
+// been set. This is for when an Application Key is used instead of the Master
+// Application Key.
+func (c *B2) setAccountID(auth *authorizationState) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	return c.internalAuthorizeAccount()
+	if c.AccountID == "" {
+		c.AccountID = auth.AccountID
+	}
 }
 
-// The body of AuthorizeAccount without a mutex
-func (c *B2) internalAuthorizeAccount() error {
+// Returns a valid authorization, authorizing the account if needed.
+//
+// Only one authorization request is made at a time. If force is false and
+// the current authorization is valid it will be returned without making a
+// request. If the current authorization is close to expiry, a new one is
+// requested in the background.
+func (c *B2) authorize(force bool) (*authorizationState, error) {
+	c.mutex.Lock()
+
+	auth := c.auth
+	reason := AuthorizationRequested
+	if !force {
+		switch {
+		case auth == nil:
+			reason = AuthorizationInitial
+		case !auth.isValid():
+			reason = AuthorizationInvalidated
+		default:
+			now := c.now()
+			expires := auth.expires()
+			if now.Before(expires.Add(-c.refreshMargin())) {
+				c.mutex.Unlock()
+				return auth, nil
+			}
+
+			if now.Before(expires) {
+				// Keep using the current token while a new one is requested
+				if c.authorizing == nil && now.Sub(c.refreshFailed) >= refreshRetryInterval {
+					c.startAuthorization(AuthorizationRefresh)
+				}
+				c.mutex.Unlock()
+				return auth, nil
+			}
+			reason = AuthorizationExpired
+		}
+	}
+
+	// Wait for an authorization already in progress, or start a new one
+	call := c.authorizing
+	if call == nil {
+		call = c.startAuthorization(reason)
+	}
+	c.mutex.Unlock()
+
+	<-call.done
+	return call.auth, call.err
+}
+
+// Starts a new authorization request. Must be called while holding the mutex.
+func (c *B2) startAuthorization(reason AuthorizationReason) *authorizationCall {
+	call := &authorizationCall{done: make(chan struct{})}
+	c.authorizing = call
+	if c.host == "" {
+		c.host = c.Host
+	}
 	if c.host == "" {
 		c.host = b2Host
 	}
 
-	req, err := http.NewRequest("GET", c.host+v1+"b2_authorize_account", nil)
+	go c.runAuthorization(call, c.host, reason)
+	return call
+}
+
+func (c *B2) runAuthorization(call *authorizationCall, host string, reason AuthorizationReason) {
+	if reason == AuthorizationInitial {
+		if call.auth = c.loadAuthorization(host); call.auth != nil {
+			reason = AuthorizationStored
+		}
+	}
+
+	if call.auth == nil {
+		if c.Debug {
+			log.Printf("Authorizing account (%s)", reason)
+		}
+		if reason == AuthorizationInvalidated {
+			c.deleteAuthorization(host)
+		}
+		call.auth, call.err = c.authorizeAccount(host)
+		if call.err == nil {
+			c.saveAuthorization(host, call.auth)
+		}
+	}
+
+	c.mutex.Lock()
+	if call.err == nil {
+		c.auth = call.auth
+	} else if reason == AuthorizationRefresh {
+		c.refreshFailed = c.now()
+	}
+	c.authorizing = nil
+	c.mutex.Unlock()
+
+	if c.OnAuthorization != nil {
+		event := AuthorizationEvent{
+			Reason: reason,
+			Err:    call.err,
+		}
+		if call.auth != nil {
+			event.Issued = call.auth.issued
+			event.Expires = call.auth.expires()
+		}
+		c.OnAuthorization(event)
+	}
+
+	close(call.done)
+}
+
+// Returns the current authorization, authorizing the account if needed
+func (c *B2) authorization() (*authorizationState, error) {
+	return c.authorize(false)
+}
+
+// Returns the ID of the authorized account
+func (c *B2) accountID() (string, error) {
+	auth, err := c.authorization()
 	if err != nil {
-		return err
+		return "", err
 	}
+	return auth.AccountID, nil
+}
 
-	// Support the use of application keys. If a KeyID is not explicitly set,
-	// use the account ID as the key ID
-	keyID := c.KeyID
-	if keyID == "" {
-		keyID = c.AccountID
+// Support the use of application keys. If a KeyID is not explicitly set,
+// use the account ID as the key ID
+func (c *B2) keyID() string {
+	if c.KeyID != "" {
+		return c.KeyID
 	}
-	req.SetBasicAuth(keyID, c.ApplicationKey)
 
-	resp, err := c.httpClient.Do(req)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.AccountID
+}
+
+// Makes a b2_authorize_account request
+func (c *B2) authorizeAccount(host string) (*authorizationState, error) {
+	issued := c.now()
+	req, err := http.NewRequest("GET", host+v1+"b2_authorize_account", nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	req.SetBasicAuth(c.keyID(), c.ApplicationKey)
+
+	resp, err := c.doRequest(req, RequestInfo{API: "b2_authorize_account"})
+	if err != nil {
+		return nil, requestError(err, "b2_authorize_account", "", "")
 	}
 
 	authResponse := &authorizeAccountResponse{}
 	if err = c.parseResponse(resp, authResponse, nil); err != nil {
-		return err
+		return nil, requestError(err, "b2_authorize_account", "", "")
 	}
 
-	// Store token
-	c.auth = &authorizationState{
+	return &authorizationState{
 		authorizeAccountResponse: authResponse,
+		issued:                   issued,
 		valid:                    true,
-	}
-
-	// Set AccountID to the returned value from authorizeAccountResponse
-	// This is for when an Application Key is used instead of Master Application Key
-	if c.AccountID == "" {
-		c.AccountID = authResponse.AccountID
-	}
-
-	return nil
+	}, nil
 }
 
 // DownloadURL returns the URL prefix needed to construct download links.
 // Bucket.FileURL will costruct a full URL for given file names.
 func (c *B2) DownloadURL() (string, error) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	if !c.auth.isValid() {
-		if err := c.internalAuthorizeAccount(); err != nil {
-			return "", err
-		}
+	auth, err := c.authorization()
+	if err != nil {
+		return "", err
 	}
-	return c.auth.DownloadURL, nil
+	return auth.DownloadURL, nil
 }
 
 // Create an authorized request using the client's credentials
 func (c *B2) authRequest(method, apiPath string, body io.Reader) (*http.Request, *authorizationState, error) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
-
-	if !c.auth.isValid() {
-		if c.Debug {
-			log.Println("No valid authorization token, re-authorizing client")
-		}
-		if err := c.internalAuthorizeAccount(); err != nil {
-			return nil, nil, err
-		}
+	auth, err := c.authorization()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	path := c.auth.APIEndpoint + v1 + apiPath
+	path := auth.APIEndpoint + v1 + apiPath
 
 	req, err := http.NewRequest(method, path, body)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	req.Header.Add("Authorization", c.auth.AuthorizationToken)
+	req.Header.Add("Authorization", auth.AuthorizationToken)
 
 	if c.Debug {
 		log.Printf("authRequest: %s %s\n", method, req.URL)
 	}
 
-	return req, c.auth, nil
+	return req, auth, nil
 }
 
 // Dispatch an authorized API GET request
@@ -209,31 +387,21 @@ func (c *B2) authGet(apiPath string) (*http.Response, *authorizationState, error
 		return nil, nil, err
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doRequest(req, RequestInfo{API: apiPath})
 	return resp, auth, err
 }
 
 // Dispatch an authorized POST request
-func (c *B2) authPost(apiPath string, body io.Reader) (*http.Response, *authorizationState, error) {
-	req, auth, err := c.authRequest("POST", apiPath, body)
+func (c *B2) authPost(info RequestInfo, body io.Reader) (*http.Response, *authorizationState, error) {
+	req, auth, err := c.authRequest("POST", info.API, body)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doRequest(req, info)
 	return resp, auth, err
 }
 
-// Looks for an error message in the response body and parses it into a
-// B2Error object
-func (c *B2) parseError(body []byte) error {
-	b2err := &B2Error{}
-	if ffjson.Unmarshal(body, b2err) != nil {
-		return nil
-	}
-	return b2err
-}
-
 // Attempts to parse a response body into the provided result struct
 func (c *B2) parseResponse(resp *http.Response, result interface{}, auth *authorizationState) error {
 	defer resp.Body.Close()
@@ -253,23 +421,9 @@ func (c *B2) parseResponse(resp *http.Response, result interface{}, auth *author
 	case 200: // Response is OK
 	case 401:
 		auth.invalidate()
-		if err := c.parseError(body); err != nil {
-			return err
-		}
-		return &B2Error{
-			Code:    "UNAUTHORIZED",
-			Message: "The account ID is wrong, the account does not have B2 enabled, or the application key is not valid",
-			Status:  resp.StatusCode,
-		}
+		return c.responseError(resp, body)
 	default:
-		if err := c.parseError(body); err != nil {
-			return err
-		}
-		return &B2Error{
-			Code:    "UNKNOWN",
-			Message: "Unrecognised status code",
-			Status:  resp.StatusCode,
-		}
+		return c.responseError(resp, body)
 	}
 
 	return ffjson.Unmarshal(body, result)
@@ -288,29 +442,35 @@ func (c *B2) apiRequest(apiPath string, request interface{}, response interface{
 		log.Printf("apiRequest: %s %s", apiPath, body)
 	}
 
-	err = c.tryAPIRequest(apiPath, body, response)
+	info := RequestInfo{
+		API:          apiPath,
+		Bucket:       requestBucket(request),
+		Transactions: requestTransactions(request),
+	}
+	err = c.tryAPIRequest(info, body, response)
 
 	// Retry after non-fatal errors
-	if b2err, ok := err.(*B2Error); ok {
+	var b2err *B2Error
+	if errors.As(err, &b2err) {
 		if !b2err.IsFatal() && !c.NoRetry {
 			if c.Debug {
 				log.Printf("Retrying request %q due to error: %v", apiPath, err)
 			}
 
-			return c.tryAPIRequest(apiPath, body, response)
+			return c.tryAPIRequest(info, body, response)
 		}
 	}
 	return err
 }
 
-func (c *B2) tryAPIRequest(apiPath string, body []byte, response interface{}) error {
-	resp, auth, err := c.authPost(apiPath, bytes.NewReader(body))
+func (c *B2) tryAPIRequest(info RequestInfo, body []byte, response interface{}) error {
+	resp, auth, err := c.authPost(info, bytes.NewReader(body))
 	if err != nil {
 		if c.Debug {
 			log.Println("B2.post returned an error: ", err)
 		}
-		return err
+		return requestError(err, info.API, "", "")
 	}
 
-	return c.parseResponse(resp, response, auth)
+	return requestError(c.parseResponse(resp, response, auth), info.API, "", "")
 }
@@ -6,7 +6,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/pquerna/ffjson/ffjson"
 )
@@ -169,3 +173,191 @@ func TestReAuth(T *testing.T) {
 		T.Errorf("Expected auth token after re-auth to be %q, saw %q", token2, b2.auth.AuthorizationToken)
 	}
 }
+
+// Starts a server which issues sequentially numbered authorization tokens,
+// and rejects requests made with the expired token
+func authTestServer(accountID string, authCount *int32, expiredToken string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case v1 + "b2_authorize_account":
+			n := atomic.AddInt32(authCount, 1)
+			fmt.Fprint(w, toJSON(authorizeAccountResponse{
+				AccountID:          accountID,
+				APIEndpoint:        server.URL,
+				AuthorizationToken: "token" + strconv.Itoa(int(n)),
+				DownloadURL:        server.URL,
+			}))
+		case v1 + "b2_list_buckets":
+			if r.Header.Get("Authorization") == expiredToken {
+				w.WriteHeader(401)
+				fmt.Fprint(w, toJSON(B2Error{
+					Status:  401,
+					Code:    "expired_auth_token",
+					Message: "Authentication token expired",
+				}))
+				return
+			}
+			fmt.Fprint(w, toJSON(listBucketsResponse{}))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	return server
+}
+
+func TestConcurrentReAuth(T *testing.T) {
+
+	accountID := "test"
+	var authCount int32
+
+	server := authTestServer(accountID, &authCount, "token1")
+	defer server.Close()
+
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      accountID,
+			ApplicationKey: "test",
+		},
+		host: server.URL,
+	}
+	if err := b2.AuthorizeAccount(); err != nil {
+		T.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := b2.ListBuckets(); err != nil {
+				T.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&authCount); n != 2 {
+		T.Errorf("Expected account to be authorized twice, saw %d authorizations", n)
+	}
+}
+
+func TestConcurrentAuthorizeAccount(T *testing.T) {
+
+	var authCount int32
+	server := authTestServer("test", &authCount, "")
+	defer server.Close()
+
+	// AccountID is set by AuthorizeAccount while other requests authorize
+	b2 := &B2{
+		Credentials: Credentials{
+			ApplicationKey: "test",
+		},
+		host: server.URL,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := b2.AuthorizeAccount(); err != nil {
+				T.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := b2.ListBuckets(); err != nil {
+				T.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if b2.AccountID != "test" {
+		T.Errorf("Expected account ID to be set, saw %q", b2.AccountID)
+	}
+}
+
+// A clock which can be moved forward by tests
+type testClock struct {
+	sync.Mutex
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestTokenRefresh(T *testing.T) {
+
+	accountID := "test"
+	var authCount int32
+
+	server := authTestServer(accountID, &authCount, "")
+	defer server.Close()
+
+	clock := &testClock{t: time.Now()}
+	events := make(chan AuthorizationEvent, 10)
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      accountID,
+			ApplicationKey: "test",
+		},
+		TokenRefreshMargin: 30 * time.Minute,
+		OnAuthorization: func(event AuthorizationEvent) {
+			events <- event
+		},
+		clock: clock.now,
+		host:  server.URL,
+	}
+	if err := b2.AuthorizeAccount(); err != nil {
+		T.Fatal(err)
+	}
+	if event := <-events; event.Reason != AuthorizationRequested {
+		T.Errorf("Expected initial authorization to be %q, saw %q", AuthorizationRequested, event.Reason)
+	}
+
+	// Before the refresh margin the token is reused
+	clock.advance(23 * time.Hour)
+	if _, err := b2.ListBuckets(); err != nil {
+		T.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&authCount); n != 1 {
+		T.Fatalf("Expected token to be reused, saw %d authorizations", n)
+	}
+
+	// Within the margin the token is refreshed in the background
+	clock.advance(45 * time.Minute)
+	if _, err := b2.ListBuckets(); err != nil {
+		T.Fatal(err)
+	}
+	event := <-events
+	if event.Reason != AuthorizationRefresh || event.Err != nil {
+		T.Errorf("Expected token to be refreshed, saw %q (%v)", event.Reason, event.Err)
+	}
+	if !event.Expires.Equal(clock.now().Add(tokenLifetime)) {
+		T.Errorf("Expected refreshed token to expire at %v, saw %v", clock.now().Add(tokenLifetime), event.Expires)
+	}
+
+	// Once expired, requests wait for a new token
+	clock.advance(25 * time.Hour)
+	if _, err := b2.ListBuckets(); err != nil {
+		T.Fatal(err)
+	}
+	if event := <-events; event.Reason != AuthorizationExpired {
+		T.Errorf("Expected expired token to be replaced, saw %q", event.Reason)
+	}
+	if n := atomic.LoadInt32(&authCount); n != 3 {
+		T.Errorf("Expected 3 authorizations, saw %d", n)
+	}
+}
@@ -2,15 +2,15 @@ package backblaze
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
+	"time"
 )
 
 // Bucket provides access to the files stored in a B2 Bucket
 type Bucket struct {
 	*BucketInfo
 
-	uploadAuthPool chan *UploadAuth
-
 	b2 *B2
 }
 
@@ -20,6 +20,8 @@ type UploadAuth struct {
 	AuthorizationToken string
 	UploadURL          *url.URL
 	Valid              bool
+
+	issued time.Time
 }
 
 // CreateBucket creates a new B2 Bucket in the authorized account.
@@ -33,8 +35,13 @@ func (b *B2) CreateBucket(bucketName string, bucketType BucketType) (*Bucket, er
 
 // CreateBucketWithInfo extends CreateBucket to add bucket info and lifecycle rules to the creation request
 func (b *B2) CreateBucketWithInfo(bucketName string, bucketType BucketType, bucketInfo map[string]string, lifecycleRules []LifecycleRule) (*Bucket, error) {
+	accountID, err := b.accountID()
+	if err != nil {
+		return nil, err
+	}
+
 	request := &createBucketRequest{
-		AccountID:      b.AccountID,
+		AccountID:      accountID,
 		BucketName:     bucketName,
 		BucketType:     bucketType,
 		BucketInfo:     bucketInfo,
@@ -47,9 +54,8 @@ func (b *B2) CreateBucketWithInfo(bucketName string, bucketType BucketType, buck
 	}
 
 	bucket := &Bucket{
-		BucketInfo:     response,
-		uploadAuthPool: make(chan *UploadAuth, b.MaxIdleUploads),
-		b2:             b,
+		BucketInfo: response,
+		b2:         b,
 	}
 
 	return bucket, nil
@@ -58,8 +64,13 @@ func (b *B2) CreateBucketWithInfo(bucketName string, bucketType BucketType, buck
 // deleteBucket removes the specified bucket from the authorized account. Only
 // buckets that contain no version of any files can be deleted.
 func (b *B2) deleteBucket(bucketID string) (*Bucket, error) {
+	accountID, err := b.accountID()
+	if err != nil {
+		return nil, err
+	}
+
 	request := &deleteBucketRequest{
-		AccountID: b.AccountID,
+		AccountID: accountID,
 		BucketID:  bucketID,
 	}
 	response := &BucketInfo{}
@@ -69,9 +80,8 @@ func (b *B2) deleteBucket(bucketID string) (*Bucket, error) {
 	}
 
 	return &Bucket{
-		BucketInfo:     response,
-		uploadAuthPool: make(chan *UploadAuth, b.MaxIdleUploads),
-		b2:             b,
+		BucketInfo: response,
+		b2:         b,
 	}, nil
 }
 
@@ -79,14 +89,22 @@ func (b *B2) deleteBucket(bucketID string) (*Bucket, error) {
 // that contain no version of any files can be deleted.
 func (b *Bucket) Delete() error {
 	_, error := b.b2.deleteBucket(b.ID)
+	if error == nil {
+		b.b2.clearUploadAuths(b.ID)
+	}
 	return error
 }
 
 // ListBuckets lists buckets associated with an account, in alphabetical order
 // by bucket ID.
 func (b *B2) ListBuckets() ([]*Bucket, error) {
-	request := &accountRequest{
-		ID: b.AccountID,
+	accountID, err := b.accountID()
+	if err != nil {
+		return nil, err
+	}
+
+	request := &listBucketsRequest{
+		AccountID: accountID,
 	}
 	response := &listBucketsResponse{}
 
@@ -98,9 +116,8 @@ func (b *B2) ListBuckets() ([]*Bucket, error) {
 	buckets := make([]*Bucket, len(response.Buckets))
 	for i, info := range response.Buckets {
 		bucket := &Bucket{
-			BucketInfo:     info,
-			uploadAuthPool: make(chan *UploadAuth, b.MaxIdleUploads),
-			b2:             b,
+			BucketInfo: info,
+			b2:         b,
 		}
 
 		switch info.BucketType {
@@ -126,9 +143,8 @@ func (b *B2) updateBucket(request *updateBucketRequest) (*Bucket, error) {
 	}
 
 	return &Bucket{
-		BucketInfo:     response,
-		uploadAuthPool: make(chan *UploadAuth, b.MaxIdleUploads),
-		b2:             b,
+		BucketInfo: response,
+		b2:         b,
 	}, nil
 }
 
@@ -144,26 +160,107 @@ func (b *Bucket) Update(bucketType BucketType) error {
 // If not specified, setting will remain unchanged.
 //
 // bucketInfo (optional) -- User-defined information to be stored with the bucket.
-// If specified, the existing bucket info will be replaced with the new info. If not specified, setting will remain unchanged.
+// If not nil, the existing bucket info will be replaced with the new info, so an empty map removes all info. If nil, setting will remain unchanged.
 // Cache-Control policies can be set here on a global level for all the files in the bucket.
 //
 // lifecycleRules (optional) --  The list of lifecycle rules for this bucket.
-// If specified, the existing lifecycle rules will be replaced with this new list. If not specified, setting will remain unchanged.
+// If not nil, the existing lifecycle rules will be replaced with this new list, so an empty list removes all rules. If nil, setting will remain unchanged.
 //
 // ifRevisionIs (optional) -- When set (> 0), the update will only happen if the revision number stored in the B2 service matches the one passed in.
-// This can be used to avoid having simultaneous updates make conflicting changes.
+// This can be used to avoid having simultaneous updates make conflicting changes, but Modify is usually more convenient.
+//
+// On success, the bucket's BucketInfo is replaced with the updated info.
 func (b *Bucket) UpdateAll(bucketType BucketType, bucketInfo map[string]string, lifecycleRules []LifecycleRule, ifRevisionIs int) error {
-	_, err := b.b2.updateBucket(&updateBucketRequest{
-		AccountID:      b.AccountID,
-		BucketID:       b.ID,
-		BucketType:     bucketType,
-		BucketInfo:     bucketInfo,
-		LifecycleRules: lifecycleRules,
-		IfRevisionIs:   ifRevisionIs,
-	})
+	request := &updateBucketRequest{
+		AccountID:    b.AccountID,
+		BucketID:     b.ID,
+		BucketType:   bucketType,
+		IfRevisionIs: ifRevisionIs,
+	}
+	if bucketInfo != nil {
+		request.BucketInfo = &bucketInfo
+	}
+	if lifecycleRules != nil {
+		request.LifecycleRules = &lifecycleRules
+	}
+
+	updated, err := b.b2.updateBucket(request)
+	if err != nil {
+		return err
+	}
+
+	b.BucketInfo = updated.BucketInfo
+	return nil
+}
+
+// The number of times Modify will attempt an update before giving up
+const bucketModifyAttempts = 5
+
+// Modify changes the properties of a bucket without overwriting concurrent
+// changes made by other clients.
+//
+// The current bucket info is retrieved from B2 and passed to modify, which
+// may change its type, info and lifecycle rules. The changes are submitted
+// only if the bucket has not been updated since it was retrieved. If another
+// update happened in the meantime, the process is repeated with the new
+// bucket info, up to a limited number of times, after which an error
+// matching ErrConflict is returned. If modify returns an error, no update is
+// made and the error is returned.
+//
+// On success, the bucket's BucketInfo is replaced with the updated info.
+func (b *Bucket) Modify(modify func(*BucketInfo) error) error {
+	var err error
+	for attempt := 0; attempt < bucketModifyAttempts; attempt++ {
+		var info *BucketInfo
+		if info, err = b.b2.bucketInfo(b.ID); err != nil {
+			return err
+		}
+		if err = modify(info); err != nil {
+			return err
+		}
+
+		// Always submit the info and rules, so they can be cleared
+		bucketInfo, lifecycleRules := info.Info, info.LifecycleRules
+		if bucketInfo == nil {
+			bucketInfo = map[string]string{}
+		}
+		if lifecycleRules == nil {
+			lifecycleRules = []LifecycleRule{}
+		}
+
+		err = b.UpdateAll(info.BucketType, bucketInfo, lifecycleRules, info.Revision)
+		if !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
 	return err
 }
 
+// bucketInfo retrieves the current info for a bucket
+func (b *B2) bucketInfo(bucketID string) (*BucketInfo, error) {
+	accountID, err := b.accountID()
+	if err != nil {
+		return nil, err
+	}
+
+	request := &listBucketsRequest{
+		AccountID: accountID,
+		BucketID:  bucketID,
+	}
+	response := &listBucketsResponse{}
+
+	if err := b.apiRequest("b2_list_buckets", request, response); err != nil {
+		return nil, err
+	}
+
+	for _, info := range response.Buckets {
+		if info.ID == bucketID {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("bucket %s: %w", bucketID, ErrNotFound)
+}
+
 // Bucket looks up a bucket for the currently authorized client
 func (b *B2) Bucket(bucketName string) (*Bucket, error) {
 	buckets, err := b.ListBuckets()
@@ -184,50 +281,47 @@ func (b *B2) Bucket(bucketName string) (*Bucket, error) {
 //
 // When you upload a file to B2, you must call b2_get_upload_url first to get
 // the URL for uploading directly to the place where the file will be stored.
+// Upload URLs are pooled by the client, and reused until their token expires.
 //
-// If the upload is successful, ReturnUploadAuth(*uploadAuth) should be called
+// When the upload is complete, ReturnUploadAuth(*uploadAuth) should be called
 // to place it back in the pool for reuse.
 func (b *Bucket) GetUploadAuth() (*UploadAuth, error) {
-	select {
 	// Pop an UploadAuth from the pool
-	case auth := <-b.uploadAuthPool:
+	if auth := b.b2.getPooledUploadAuth(b.ID); auth != nil {
 		return auth, nil
+	}
 
 	// If none are available, make a new one
-	default:
-		// Make a new one
-		request := &bucketRequest{
-			ID: b.ID,
-		}
-
-		response := &getUploadURLResponse{}
-		if err := b.b2.apiRequest("b2_get_upload_url", request, response); err != nil {
-			return nil, err
-		}
+	issued := b.b2.now()
+	request := &bucketRequest{
+		ID: b.ID,
+	}
 
-		// Set bucket auth
-		uploadURL, err := url.Parse(response.UploadURL)
-		if err != nil {
-			return nil, err
-		}
-		auth := &UploadAuth{
-			AuthorizationToken: response.AuthorizationToken,
-			UploadURL:          uploadURL,
-			Valid:              true,
-		}
+	response := &getUploadURLResponse{}
+	if err := b.b2.apiRequest("b2_get_upload_url", request, response); err != nil {
+		return nil, err
+	}
 
-		return auth, nil
+	// Set bucket auth
+	uploadURL, err := url.Parse(response.UploadURL)
+	if err != nil {
+		return nil, err
 	}
+	auth := &UploadAuth{
+		AuthorizationToken: response.AuthorizationToken,
+		UploadURL:          uploadURL,
+		Valid:              true,
+		issued:             issued,
+	}
+
+	return auth, nil
 }
 
 // ReturnUploadAuth returns an upload URL to the available pool.
-// This should not be called if the upload fails.
-// Instead request another GetUploadAuth() and retry.
+//
+// If the upload failed, Valid should be set to false before returning the
+// upload URL so that it is discarded. Request another with GetUploadAuth()
+// to retry the upload.
 func (b *Bucket) ReturnUploadAuth(uploadAuth *UploadAuth) {
-	if uploadAuth.Valid {
-		select {
-		case b.uploadAuthPool <- uploadAuth:
-		default:
-		}
-	}
+	b.b2.returnUploadAuth(b.ID, uploadAuth)
 }
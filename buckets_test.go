@@ -1,7 +1,15 @@
 package backblaze
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+
+	"github.com/pquerna/ffjson/ffjson"
 )
 
 func TestListBuckets(T *testing.T) {
@@ -51,3 +59,169 @@ func TestListBuckets(T *testing.T) {
 		T.Errorf("Bucket ID does not match: expected %q, saw %q", bucketID, buckets[0].ID)
 	}
 }
+
+// A server which stores a single bucket, and simulates updates made by other
+// clients
+type bucketServer struct {
+	*httptest.Server
+	sync.Mutex
+
+	info       BucketInfo
+	concurrent int // Number of updates by other clients to make before each update
+	updates    int // Number of update requests received
+}
+
+func newBucketServer(info BucketInfo) *bucketServer {
+	s := &bucketServer{info: info}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Simulates n concurrent updates, and resets the update count
+func (s *bucketServer) setConcurrent(n int) {
+	s.Lock()
+	defer s.Unlock()
+	s.concurrent = n
+	s.updates = 0
+}
+
+// Returns the stored bucket info and the number of updates received
+func (s *bucketServer) state() (BucketInfo, int) {
+	s.Lock()
+	defer s.Unlock()
+	return s.info, s.updates
+}
+
+func (s *bucketServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case v1 + "b2_authorize_account":
+		fmt.Fprint(w, toJSON(authorizeAccountResponse{
+			AccountID:          "test",
+			APIEndpoint:        s.URL,
+			AuthorizationToken: "token",
+			DownloadURL:        s.URL,
+		}))
+	case v1 + "b2_list_buckets":
+		info := s.info
+		fmt.Fprint(w, toJSON(listBucketsResponse{Buckets: []*BucketInfo{&info}}))
+	case v1 + "b2_update_bucket":
+		request := &updateBucketRequest{}
+		body, _ := ioutil.ReadAll(r.Body)
+		ffjson.Unmarshal(body, request)
+
+		s.updates++
+		if s.concurrent > 0 {
+			s.concurrent--
+			s.info.Revision++
+		}
+		if request.IfRevisionIs != 0 && request.IfRevisionIs != s.info.Revision {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, toJSON(B2Error{Status: http.StatusConflict, Code: "conflict", Message: "revision mismatch"}))
+			return
+		}
+
+		if request.BucketType != "" {
+			s.info.BucketType = request.BucketType
+		}
+		if request.BucketInfo != nil {
+			s.info.Info = *request.BucketInfo
+		}
+		if request.LifecycleRules != nil {
+			s.info.LifecycleRules = *request.LifecycleRules
+		}
+		s.info.Revision++
+
+		info := s.info
+		fmt.Fprint(w, toJSON(&info))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBucketModify(T *testing.T) {
+	server := newBucketServer(BucketInfo{
+		AccountID:  "test",
+		ID:         "bucketid",
+		Name:       "testbucket",
+		BucketType: AllPrivate,
+		Revision:   1,
+	})
+	defer server.Close()
+
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      "test",
+			ApplicationKey: "test",
+		},
+		Debug: testing.Verbose(),
+		host:  server.URL,
+	}
+	bucket := &Bucket{
+		BucketInfo: &BucketInfo{AccountID: "test", ID: "bucketid", Name: "testbucket", Revision: 1},
+		b2:         b2,
+	}
+
+	// A concurrent update causes the change to be retried with the new revision
+	server.setConcurrent(1)
+	calls := 0
+	err := bucket.Modify(func(info *BucketInfo) error {
+		calls++
+		info.LifecycleRules = append(info.LifecycleRules, LifecycleRule{FileNamePrefix: "logs/", DaysFromHidingToDeleting: 7})
+		return nil
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	if calls != 2 {
+		T.Errorf("Expected modify to be called twice, saw %d", calls)
+	}
+	if info, _ := server.state(); len(info.LifecycleRules) != 1 {
+		T.Errorf("Expected 1 lifecycle rule, saw %v", info.LifecycleRules)
+	}
+	if bucket.Revision != 3 || len(bucket.LifecycleRules) != 1 {
+		T.Errorf("Bucket info was not refreshed: %+v", bucket.BucketInfo)
+	}
+
+	// Rules can be removed
+	err = bucket.Modify(func(info *BucketInfo) error {
+		info.LifecycleRules = nil
+		return nil
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	if info, _ := server.state(); len(info.LifecycleRules) != 0 {
+		T.Errorf("Expected lifecycle rules to be removed, saw %v", info.LifecycleRules)
+	}
+
+	// An error from modify prevents the update
+	server.setConcurrent(0)
+	expected := errors.New("modify failed")
+	err = bucket.Modify(func(info *BucketInfo) error {
+		info.BucketType = AllPublic
+		return expected
+	})
+	if err != expected {
+		T.Errorf("Expected modify error, saw %v", err)
+	}
+	if info, updates := server.state(); updates != 0 || info.BucketType != AllPrivate {
+		T.Errorf("Bucket was updated after modify failed")
+	}
+
+	// Persistent conflicts eventually fail
+	server.setConcurrent(100)
+	err = bucket.Modify(func(info *BucketInfo) error {
+		info.BucketType = AllPublic
+		return nil
+	})
+	if !errors.Is(err, ErrConflict) {
+		T.Errorf("Expected a conflict error, saw %v", err)
+	}
+	if _, updates := server.state(); updates != bucketModifyAttempts {
+		T.Errorf("Expected %d update attempts, saw %d", bucketModifyAttempts, updates)
+	}
+}
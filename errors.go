@@ -0,0 +1,139 @@
+package backblaze
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// Errors which may be matched against errors returned by the client using errors.Is.
+//
+// A B2Error matches one of these errors if its code or HTTP status indicates
+// that kind of failure. More than one may match, for example an expired auth
+// token is also unauthorized.
+var (
+	ErrBadRequest          = errors.New("bad request")
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrExpiredAuthToken    = errors.New("authorization token expired")
+	ErrCapExceeded         = errors.New("usage cap exceeded")
+	ErrNotFound            = errors.New("not found")
+	ErrConflict            = errors.New("conflict")
+	ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+	ErrTooManyRequests     = errors.New("too many requests")
+	ErrServiceUnavailable  = errors.New("service unavailable")
+	ErrBucketNotEmpty      = errors.New("bucket not empty")
+	ErrDuplicateBucketName = errors.New("duplicate bucket name")
+	ErrTooManyBuckets      = errors.New("too many buckets")
+
+	// Returned when the SHA1 hash of data received by B2 or downloaded from
+	// B2 does not match the expected value
+	ErrChecksumMismatch = errors.New("SHA1 checksum mismatch")
+
+	// Returned by UploadLargeFile when a file is too small to be split into
+	// at least two parts. The file can be uploaded with UploadFile instead.
+	ErrLargeFileTooSmall = errors.New("file too small to upload in parts")
+)
+
+// Is reports whether the error matches one of the sentinel errors defined by this package
+func (e *B2Error) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.Status == http.StatusBadRequest || e.Code == "bad_request"
+	case ErrUnauthorized:
+		switch e.Code {
+		case "InvalidAccessKeyId", "SignatureDoesNotMatch":
+			return true
+		}
+		return e.Status == http.StatusUnauthorized
+	case ErrExpiredAuthToken:
+		return e.Code == "expired_auth_token"
+	case ErrCapExceeded:
+		return strings.HasSuffix(e.Code, "cap_exceeded")
+	case ErrNotFound:
+		switch e.Code {
+		case "not_found", "file_not_present", "no_such_file":
+			return true
+		}
+		return e.Status == http.StatusNotFound
+	case ErrConflict:
+		return e.Status == http.StatusConflict || e.Code == "conflict"
+	case ErrRangeNotSatisfiable:
+		return e.Status == http.StatusRequestedRangeNotSatisfiable || e.Code == "range_not_satisfiable"
+	case ErrTooManyRequests:
+		return e.Status == http.StatusTooManyRequests || e.Code == "too_many_requests" || e.Code == "SlowDown"
+	case ErrServiceUnavailable:
+		return e.Status == http.StatusServiceUnavailable || e.Code == "service_unavailable"
+	case ErrBucketNotEmpty:
+		return e.Code == "cannot_delete_non_empty_bucket"
+	case ErrDuplicateBucketName:
+		return e.Code == "duplicate_bucket_name"
+	case ErrTooManyBuckets:
+		return e.Code == "too_many_buckets"
+	}
+	return false
+}
+
+// Headers which may carry an identifier for the request assigned by the service
+var requestIDHeaders = []string{"X-Bz-Request-Id", "X-Amz-Request-Id"}
+
+// Creates an error for an unsuccessful response. If the body contains an
+// error message from the B2 API it will be used, otherwise a generic error
+// describing the status code is returned.
+func (c *B2) responseError(resp *http.Response, body []byte) *B2Error {
+	b2err := &B2Error{}
+	if ffjson.Unmarshal(body, b2err) != nil || b2err.Code == "" {
+		b2err = &B2Error{
+			Code:    "UNKNOWN",
+			Message: "Unrecognised status code",
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			b2err.Code = "UNAUTHORIZED"
+			b2err.Message = "The account ID is wrong, the account does not have B2 enabled, or the application key is not valid"
+		}
+	}
+	if b2err.Status == 0 {
+		b2err.Status = resp.StatusCode
+	}
+
+	for _, header := range requestIDHeaders {
+		if id := resp.Header.Get(header); id != "" {
+			b2err.RequestID = id
+			break
+		}
+	}
+	return b2err
+}
+
+// Adds the context of a request to an error returned while making it.
+// B2Errors are annotated in place, other errors are wrapped.
+func requestError(err error, api, fileName, fileID string) error {
+	if err == nil {
+		return nil
+	}
+
+	var b2err *B2Error
+	if errors.As(err, &b2err) {
+		if b2err.API == "" {
+			b2err.API = api
+		}
+		if b2err.FileName == "" {
+			b2err.FileName = fileName
+		}
+		if b2err.FileID == "" {
+			b2err.FileID = fileID
+		}
+		return err
+	}
+
+	switch {
+	case fileName != "":
+		return fmt.Errorf("%s %q: %w", api, fileName, err)
+	case fileID != "":
+		return fmt.Errorf("%s %s: %w", api, fileID, err)
+	default:
+		return fmt.Errorf("%s: %w", api, err)
+	}
+}
@@ -0,0 +1,80 @@
+package backblaze
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorIs(T *testing.T) {
+	tests := []struct {
+		err    *B2Error
+		target error
+	}{
+		{&B2Error{Status: 400, Code: "bad_request"}, ErrBadRequest},
+		{&B2Error{Status: 401, Code: "expired_auth_token"}, ErrUnauthorized},
+		{&B2Error{Status: 401, Code: "expired_auth_token"}, ErrExpiredAuthToken},
+		{&B2Error{Status: 403, Code: "transaction_cap_exceeded"}, ErrCapExceeded},
+		{&B2Error{Status: 404, Code: "not_found"}, ErrNotFound},
+		{&B2Error{Status: 400, Code: "file_not_present"}, ErrNotFound},
+		{&B2Error{Status: 409, Code: "conflict"}, ErrConflict},
+		{&B2Error{Status: 416, Code: "range_not_satisfiable"}, ErrRangeNotSatisfiable},
+		{&B2Error{Status: 400, Code: "cannot_delete_non_empty_bucket"}, ErrBucketNotEmpty},
+		{&B2Error{Status: 400, Code: "duplicate_bucket_name"}, ErrDuplicateBucketName},
+		{&B2Error{Status: 503, Code: "service_unavailable"}, ErrServiceUnavailable},
+	}
+	for _, test := range tests {
+		if !errors.Is(test.err, test.target) {
+			T.Errorf("Expected %v to match %q", test.err, test.target)
+		}
+	}
+
+	if errors.Is(&B2Error{Status: 400, Code: "bad_request"}, ErrNotFound) {
+		T.Errorf("bad_request error should not match %q", ErrNotFound)
+	}
+}
+
+func TestDownloadErrorContext(T *testing.T) {
+
+	accountID := "test"
+
+	client, server := prepareResponses([]response{
+		{code: 200, body: authorizeAccountResponse{
+			AccountID:          accountID,
+			APIEndpoint:        "http://api.url",
+			AuthorizationToken: "testToken",
+			DownloadURL:        "http://download.url",
+		}},
+		{code: 404, body: []byte("Not Found")},
+	})
+	defer server.Close()
+
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      accountID,
+			ApplicationKey: "test",
+		},
+		Debug:      testing.Verbose(),
+		httpClient: *client,
+		host:       server.URL,
+	}
+	bucket := &Bucket{
+		BucketInfo: &BucketInfo{ID: "bucketid", Name: "testbucket"},
+		b2:         b2,
+	}
+
+	_, _, err := bucket.DownloadFileByName("missing.txt")
+	if !errors.Is(err, ErrNotFound) {
+		T.Fatalf("Expected not found error, saw %v", err)
+	}
+
+	var b2err *B2Error
+	if !errors.As(err, &b2err) {
+		T.Fatalf("Expected a B2Error, saw %T", err)
+	}
+	if b2err.Status != 404 {
+		T.Errorf("Expected status 404, saw %d", b2err.Status)
+	}
+	if b2err.API != "b2_download_file_by_name" || b2err.FileName != "missing.txt" {
+		T.Errorf("Expected error context to be set, saw API %q and file %q", b2err.API, b2err.FileName)
+	}
+}
@@ -38,11 +38,17 @@ func (b *Bucket) ListFileNames(startFileName string, maxFileCount int) (*ListFil
 //
 // If a delimiter is provided, files returned will be limited to those within the top folder, or any one subfolder.
 // Folder names will also be returned. The delimiter character will be used to "break" file names into folders.
+//
+// When using ProtocolS3 the listing starts after startFileName, and NextFileName is the last name returned.
+// File IDs are returned, but content types, SHA1 hashes and file info are not.
 func (b *Bucket) ListFileNamesWithPrefix(startFileName string, maxFileCount int, prefix, delimiter string) (*ListFilesResponse, error) {
 
 	if maxFileCount > 10000 || maxFileCount < 0 {
 		return nil, fmt.Errorf("maxFileCount must be in range 0 to 10,000")
 	}
+	if b.b2.Protocol == ProtocolS3 {
+		return b.s3ListFileNames(startFileName, maxFileCount, prefix, delimiter)
+	}
 
 	request := &listFilesRequest{
 		BucketID:      b.ID,
@@ -68,11 +74,17 @@ func (b *Bucket) UploadFile(name string, meta map[string]string, file io.Reader)
 // UploadTypedFile uploads a file to B2, returning its unique file ID.
 // This method computes the hash of the file before passing it to UploadHashedFile
 func (b *Bucket) UploadTypedFile(name, contentType string, meta map[string]string, file io.Reader) (*File, error) {
+	return b.UploadTypedFileWithProgress(name, contentType, meta, file, nil)
+}
+
+// UploadTypedFileWithProgress extends UploadTypedFile to report the progress of the upload
+// to the provided ProgressFunc, which may be nil.
+func (b *Bucket) UploadTypedFileWithProgress(name, contentType string, meta map[string]string, file io.Reader, progress ProgressFunc) (*File, error) {
 
 	// Hash the upload
 	hash := sha1.New()
 
-	var reader io.Reader
+	var reader io.ReadSeeker
 	var contentLength int64
 	if r, ok := file.(io.ReadSeeker); ok {
 		// If the input is seekable, just hash then seek back to the beginning
@@ -92,17 +104,23 @@ func (b *Bucket) UploadTypedFile(name, contentType string, meta map[string]strin
 		if err != nil {
 			return nil, err
 		}
-		reader = buffer
+		reader = bytes.NewReader(buffer.Bytes())
 		contentLength = written
 	}
 
 	sha1Hash := hex.EncodeToString(hash.Sum(nil))
-	f, err := b.UploadHashedTypedFile(name, contentType, meta, reader, sha1Hash, contentLength)
+	tracker := newProgressTracker(name, contentLength, 1, progress)
+	f, err := b.uploadHashedTypedFile(name, contentType, meta, reader, sha1Hash, contentLength, tracker)
 
 	// Retry after non-fatal errors
-	if b2err, ok := err.(*B2Error); ok {
+	var b2err *B2Error
+	if errors.As(err, &b2err) {
 		if !b2err.IsFatal() && !b.b2.NoRetry {
-			f, err = b.UploadHashedTypedFile(name, contentType, meta, reader, sha1Hash, contentLength)
+			if _, err := reader.Seek(0, 0); err != nil {
+				return nil, err
+			}
+			tracker.retry(true)
+			f, err = b.uploadHashedTypedFile(name, contentType, meta, reader, sha1Hash, contentLength, tracker)
 		}
 	}
 	return f, err
@@ -125,6 +143,17 @@ func (b *Bucket) UploadHashedTypedFile(
 	name, contentType string, meta map[string]string, file io.Reader,
 	sha1Hash string, contentLength int64) (*File, error) {
 
+	return b.uploadHashedTypedFile(name, contentType, meta, file, sha1Hash, contentLength, nil)
+}
+
+func (b *Bucket) uploadHashedTypedFile(
+	name, contentType string, meta map[string]string, file io.Reader,
+	sha1Hash string, contentLength int64, tracker *progressTracker) (*File, error) {
+
+	if b.b2.Protocol == ProtocolS3 {
+		return b.s3UploadFile(name, contentType, meta, file, sha1Hash, contentLength, tracker)
+	}
+
 	auth, err := b.GetUploadAuth()
 	if err != nil {
 		return nil, err
@@ -138,7 +167,7 @@ func (b *Bucket) UploadHashedTypedFile(
 	}
 
 	// Create authorized request
-	req, err := http.NewRequest("POST", auth.UploadURL.String(), file)
+	req, err := http.NewRequest("POST", auth.UploadURL.String(), trackReader(file, tracker))
 	if err != nil {
 		return nil, err
 	}
@@ -158,27 +187,28 @@ func (b *Bucket) UploadHashedTypedFile(
 		}
 	}
 
-	resp, err := b.b2.httpClient.Do(req)
+	// Place the UploadAuth back in the pool, unless the upload failed
+	defer b.ReturnUploadAuth(auth)
+
+	resp, err := b.b2.doRequest(req, RequestInfo{API: "b2_upload_file", Bucket: b.ID})
 	if err != nil {
 		auth.Valid = false
-		return nil, err
+		return nil, requestError(err, "b2_upload_file", name, "")
 	}
 
-	// Place the UploadAuth back in the pool
-	b.ReturnUploadAuth(auth)
-
 	result := &File{}
 
 	// We are not dealing with the b2 client auth token in this case, hence the nil auth
 	if err := b.b2.parseResponse(resp, result, nil); err != nil {
 		auth.Valid = false
-		return nil, err
+		return nil, requestError(err, "b2_upload_file", name, "")
 	}
 
 	if sha1Hash != result.ContentSha1 {
-		return nil, errors.New("SHA1 of uploaded file does not match local hash")
+		return nil, requestError(fmt.Errorf("SHA1 of uploaded file does not match local hash: %w", ErrChecksumMismatch), "b2_upload_file", name, "")
 	}
 
+	tracker.partDone()
 	return result, nil
 }
 
@@ -186,6 +216,10 @@ func (b *Bucket) UploadHashedTypedFile(
 //
 // If destination bucket is empty, file will be copy to the current file's bucket
 func (b *Bucket) CopyFile(fileID, fileName, destinationBucketId string, metadataDirective FileMetadataDirective) (*File, error) {
+	if b.b2.Protocol == ProtocolS3 {
+		return b.s3CopyFile(fileID, fileName, destinationBucketId, metadataDirective)
+	}
+
 	request := &fileCopyRequest{
 		ID:                fileID,
 		Name:              fileName,
@@ -226,6 +260,23 @@ func (c *B2) DownloadFileByID(fileID string) (*File, io.ReadCloser, error) {
 
 // DownloadFileRangeByID downloads part of a file from B2 using its unique ID and a requested byte range.
 func (c *B2) DownloadFileRangeByID(fileID string, fileRange *FileRange) (*File, io.ReadCloser, error) {
+	return c.DownloadFileRangeByIDWithProgress(fileID, fileRange, nil)
+}
+
+// DownloadFileRangeByIDWithProgress extends DownloadFileRangeByID to report the progress of the download
+// to the provided ProgressFunc as the returned body is read. The range may be nil to download the whole file.
+func (c *B2) DownloadFileRangeByIDWithProgress(fileID string, fileRange *FileRange, progress ProgressFunc) (*File, io.ReadCloser, error) {
+	tracker := newProgressTracker(fileID, -1, 1, progress)
+	f, body, err := c.downloadFileRangeByID(fileID, fileRange, tracker)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tracker.start(f.Name, f.ContentLength)
+	return f, trackReadCloser(body, tracker), nil
+}
+
+func (c *B2) downloadFileRangeByID(fileID string, fileRange *FileRange, tracker *progressTracker) (*File, io.ReadCloser, error) {
 
 	request := &fileRequest{
 		ID: fileID,
@@ -243,32 +294,36 @@ func (c *B2) DownloadFileRangeByID(fileID string, fileRange *FileRange) (*File,
 		return nil, nil, err
 	}
 
-	f, body, err := c.tryDownloadFileByID(requestBody, fileRange)
+	f, body, err := c.tryDownloadFileByID(fileID, requestBody, fileRange)
 
 	// Retry after non-fatal errors
-	if b2err, ok := err.(*B2Error); ok {
+	var b2err *B2Error
+	if errors.As(err, &b2err) {
 		if !b2err.IsFatal() && !c.NoRetry {
-			return c.tryDownloadFileByID(requestBody, fileRange)
+			tracker.retry(false)
+			return c.tryDownloadFileByID(fileID, requestBody, fileRange)
 		}
 	}
 	return f, body, err
 }
 
-func (c *B2) tryDownloadFileByID(requestBody []byte, fileRange *FileRange) (*File, io.ReadCloser, error) {
+func (c *B2) tryDownloadFileByID(fileID string, requestBody []byte, fileRange *FileRange) (*File, io.ReadCloser, error) {
 	req, auth, err := c.authRequest("POST", "b2_download_file_by_id", bytes.NewReader(requestBody))
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, requestError(err, "b2_download_file_by_id", "", fileID)
 	}
 
 	if fileRange != nil {
-		req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", fileRange.Start, fileRange.End))
+		req.Header.Add("Range", fileRange.header())
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.doRequest(req, RequestInfo{API: "b2_download_file_by_id"})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, requestError(err, "b2_download_file_by_id", "", fileID)
 	}
-	return c.downloadFile(resp, auth)
+
+	f, body, err := c.downloadFile(resp, auth)
+	return f, body, requestError(err, "b2_download_file_by_id", "", fileID)
 }
 
 // FileURL returns a URL which may be used to download the latest version of a file.
@@ -281,15 +336,11 @@ func (b *Bucket) FileURL(fileName string) (string, error) {
 // The B2 authRequest method assumes we are making a call to the API endpoint, so here we need to check the
 // authorization again and pass it to the caller so that they can generate an authorized request if needed
 func (b *Bucket) internalFileURL(fileName string) (string, *authorizationState, error) {
-	b.b2.mutex.Lock()
-	defer b.b2.mutex.Unlock()
-
-	if !b.b2.auth.isValid() {
-		if err := b.b2.internalAuthorizeAccount(); err != nil {
-			return "", nil, err
-		}
+	auth, err := b.b2.authorization()
+	if err != nil {
+		return "", nil, err
 	}
-	return b.b2.auth.DownloadURL + "/file/" + b.Name + "/" + fileName, b.b2.auth, nil
+	return auth.DownloadURL + "/file/" + b.Name + "/" + fileName, auth, nil
 }
 
 // DownloadFileByName downloads one file by providing the name of the bucket and the name of the
@@ -301,45 +352,86 @@ func (b *Bucket) DownloadFileByName(fileName string) (*File, io.ReadCloser, erro
 // DownloadFileRangeByName downloads part of a file by providing the name of the bucket, the name of the
 // file, and a requested byte range
 func (b *Bucket) DownloadFileRangeByName(fileName string, fileRange *FileRange) (*File, io.ReadCloser, error) {
+	return b.DownloadFileRangeByNameWithProgress(fileName, fileRange, nil)
+}
+
+// DownloadFileRangeByNameWithProgress extends DownloadFileRangeByName to report the progress of the download
+// to the provided ProgressFunc as the returned body is read. The range may be nil to download the whole file.
+func (b *Bucket) DownloadFileRangeByNameWithProgress(fileName string, fileRange *FileRange, progress ProgressFunc) (*File, io.ReadCloser, error) {
+	return b.downloadFileByName(fileName, fileRange.header(), progress)
+}
+
+// Downloads a file by name, sending the given Range header if it is not empty
+func (b *Bucket) downloadFileByName(fileName, rangeHeader string, progress ProgressFunc) (*File, io.ReadCloser, error) {
 
 	if b.b2.Debug {
 		fmt.Println("---")
 		fmt.Printf("  Download by name: %s/%s\n", b.Name, fileName)
-		fmt.Printf("             Range: %+v\n", fileRange)
+		fmt.Printf("             Range: %s\n", rangeHeader)
 	}
 
-	f, body, err := b.tryDownloadFileByName(fileName, fileRange)
+	tracker := newProgressTracker(fileName, -1, 1, progress)
+	f, body, err := b.tryDownloadFileByName(fileName, rangeHeader)
 
 	// Retry after non-fatal errors
-	if b2err, ok := err.(*B2Error); ok {
+	var b2err *B2Error
+	if errors.As(err, &b2err) {
 		if !b2err.IsFatal() && !b.b2.NoRetry {
-			return b.tryDownloadFileByName(fileName, fileRange)
+			tracker.retry(false)
+			f, body, err = b.tryDownloadFileByName(fileName, rangeHeader)
 		}
 	}
-	return f, body, err
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tracker.start(f.Name, f.ContentLength)
+	return f, trackReadCloser(body, tracker), nil
 }
 
 // ReadaheadFileByName attempts to load chunks of the file being downloaded ahead of time to improve transfer rates.
 // File ranges are downloaded using Content-Range requests. See DownloadFileRangeByName
 func (b *Bucket) ReadaheadFileByName(fileName string) (*File, io.ReadCloser, error) {
-	resp, err := b.ListFileNames(fileName, 1)
+	return b.ReadaheadFileByNameWithProgress(fileName, nil)
+}
+
+// ReadaheadFileByNameWithProgress extends ReadaheadFileByName to report the progress of the download
+// to the provided ProgressFunc. Each chunk is reported as a part once it has been downloaded.
+func (b *Bucket) ReadaheadFileByNameWithProgress(fileName string, progress ProgressFunc) (*File, io.ReadCloser, error) {
+	file, err := b.findFile(fileName)
 	if err != nil {
 		return nil, nil, err
 	}
-	if len(resp.Files) != 1 || resp.Files[0].Name != fileName {
-		return nil, nil, fmt.Errorf("Unable to find file %s in bucket %s", fileName, b.Name)
-	}
 
-	file := &resp.Files[0].File
-	r, err := b.b2.ReadaheadFile(file)
+	r, err := b.b2.ReadaheadFileWithProgress(file, progress)
 	return file, r, err
 }
 
+// Finds the current version of a file by listing its name
+func (b *Bucket) findFile(fileName string) (*File, error) {
+	// The file is the first listed with its own name as a prefix. Unlike the
+	// start name, the prefix is treated the same by both protocols.
+	resp, err := b.ListFileNamesWithPrefix("", 1, fileName, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Files) != 1 || resp.Files[0].Name != fileName {
+		return nil, fmt.Errorf("Unable to find file %s in bucket %s: %w", fileName, b.Name, ErrNotFound)
+	}
+	return &resp.Files[0].File, nil
+}
+
 // ReadaheadFile attempts to load chunks of the file being downloaded ahead of time to improve transfer rates.
 // This method attempts to optimise the chunk size based on the file size and a target of 15 workers
 //
 // File ranges are downloaded using Content-Range requests. See DownloadFileRangeByName
 func (c *B2) ReadaheadFile(file *File) (io.ReadCloser, error) {
+	return c.ReadaheadFileWithProgress(file, nil)
+}
+
+// ReadaheadFileWithProgress extends ReadaheadFile to report the progress of the download
+// to the provided ProgressFunc. Each chunk is reported as a part once it has been downloaded.
+func (c *B2) ReadaheadFileWithProgress(file *File, progress ProgressFunc) (io.ReadCloser, error) {
 	numWorkers := 15
 	chunkSize := int(file.ContentLength / int64(numWorkers*2))
 	if chunkSize < 1<<20 {
@@ -347,7 +439,7 @@ func (c *B2) ReadaheadFile(file *File) (io.ReadCloser, error) {
 	} else if chunkSize > 10<<20 {
 		chunkSize = 10 << 20
 	}
-	return c.ReadaheadFileOptions(file, chunkSize, numWorkers*2, numWorkers)
+	return c.ReadaheadFileOptionsWithProgress(file, chunkSize, numWorkers*2, numWorkers, progress)
 }
 
 // ReadaheadFileOptions attempts to load chunks of the file being downloaded ahead of time to improve transfer rates.
@@ -356,9 +448,17 @@ func (c *B2) ReadaheadFile(file *File) (io.ReadCloser, error) {
 //
 // File ranges are downloaded using Content-Range requests. See DownloadFileRangeByName
 func (c *B2) ReadaheadFileOptions(file *File, chunkSize, chunkAhead, numWorkers int) (io.ReadCloser, error) {
+	return c.ReadaheadFileOptionsWithProgress(file, chunkSize, chunkAhead, numWorkers, nil)
+}
+
+// ReadaheadFileOptionsWithProgress extends ReadaheadFileOptions to report the progress of the download
+// to the provided ProgressFunc. Each chunk is reported as a part once it has been downloaded.
+func (c *B2) ReadaheadFileOptionsWithProgress(file *File, chunkSize, chunkAhead, numWorkers int, progress ProgressFunc) (io.ReadCloser, error) {
+	parts := int((file.ContentLength + int64(chunkSize) - 1) / int64(chunkSize))
 	readerAt := &fileReaderAt{
-		b2:   c,
-		file: file,
+		b2:      c,
+		file:    file,
+		tracker: newProgressTracker(file.Name, file.ContentLength, parts, progress),
 	}
 
 	reader := readahead.NewConcurrentReader(file.Name, readerAt, chunkSize, chunkAhead, numWorkers)
@@ -366,8 +466,9 @@ func (c *B2) ReadaheadFileOptions(file *File, chunkSize, chunkAhead, numWorkers
 }
 
 type fileReaderAt struct {
-	b2   *B2
-	file *File
+	b2      *B2
+	file    *File
+	tracker *progressTracker
 }
 
 func (r *fileReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
@@ -388,7 +489,7 @@ func (r *fileReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
 	if r.b2.Debug {
 		log.Printf("Reading chunk of %d bytes at offset %d", len(p), off)
 	}
-	_, reader, err := r.b2.DownloadFileRangeByID(r.file.ID, fileRange)
+	_, reader, err := r.b2.downloadFileRangeByID(r.file.ID, fileRange, r.tracker)
 	if err != nil {
 		log.Println(err)
 		return 0, err
@@ -411,14 +512,23 @@ func (r *fileReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
 			err = io.EOF
 		}
 	}
+
+	r.tracker.add(n)
+	if err == nil || err == io.EOF {
+		r.tracker.partDone()
+	}
 	return n, err
 }
 
-func (b *Bucket) tryDownloadFileByName(fileName string, fileRange *FileRange) (*File, io.ReadCloser, error) {
+func (b *Bucket) tryDownloadFileByName(fileName, rangeHeader string) (*File, io.ReadCloser, error) {
+	if b.b2.Protocol == ProtocolS3 {
+		return b.s3DownloadFile(fileName, rangeHeader)
+	}
+
 	// Locate the file
 	fileURL, auth, err := b.internalFileURL(fileName)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, requestError(err, "b2_download_file_by_name", fileName, "")
 	}
 
 	if b.b2.Debug {
@@ -432,17 +542,18 @@ func (b *Bucket) tryDownloadFileByName(fileName string, fileRange *FileRange) (*
 	}
 	req.Header.Add("Authorization", auth.AuthorizationToken)
 
-	if fileRange != nil {
-		req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", fileRange.Start, fileRange.End))
+	if rangeHeader != "" {
+		req.Header.Add("Range", rangeHeader)
 	}
 
-	resp, err := b.b2.httpClient.Do(req)
+	resp, err := b.b2.doRequest(req, RequestInfo{API: "b2_download_file_by_name", Bucket: b.ID})
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, requestError(err, "b2_download_file_by_name", fileName, "")
 	}
 
 	// Handle the response
-	return b.b2.downloadFile(resp, auth)
+	f, body, err := b.b2.downloadFile(resp, auth)
+	return f, body, requestError(err, "b2_download_file_by_name", fileName, "")
 }
 
 func (c *B2) downloadFile(resp *http.Response, auth *authorizationState) (*File, io.ReadCloser, error) {
@@ -467,24 +578,13 @@ func (c *B2) downloadFile(resp *http.Response, auth *authorizationState) (*File,
 		if err != nil {
 			return nil, nil, err
 		}
-		if err := c.parseError(body); err != nil {
-			return nil, nil, err
-		}
-		return nil, nil, &B2Error{
-			Code:    "UNAUTHORIZED",
-			Message: "The account ID is wrong, the account does not have B2 enabled, or the application key is not valid",
-			Status:  resp.StatusCode,
-		}
+		return nil, nil, c.responseError(resp, body)
 	default:
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, nil, err
 		}
-		if err := c.parseError(body); err != nil {
-			return nil, nil, err
-		}
-
-		return nil, nil, fmt.Errorf("Unrecognised status code: %d", resp.StatusCode)
+		return nil, nil, c.responseError(resp, body)
 	}
 
 	name, err := url.QueryUnescape(resp.Header.Get("X-Bz-File-Name"))
@@ -500,24 +600,11 @@ func (c *B2) downloadFile(resp *http.Response, auth *authorizationState) (*File,
 		FileInfo:    make(map[string]string),
 	}
 
-	// Parse Content-Length
-	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
-	if err != nil {
+	if err := setContentLength(file, resp); err != nil {
 		return nil, nil, err
 	}
-	file.ContentLength = size
-
-	// Parse Content-Range
-	contentRange := resp.Header.Get("Content-Range")
-	if contentRange != "" {
-		var start, end, total int64
-		_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total)
-		if err != nil {
-			return nil, nil, fmt.Errorf("Unable to parse Content-Range header: %s", err)
-		}
-		if end-start+1 != file.ContentLength {
-			return nil, nil, fmt.Errorf("Content-Range (%d-%d) does not match Content-Length (%d)", start, end, file.ContentLength)
-		}
+	if timestamp := resp.Header.Get("X-Bz-Upload-Timestamp"); timestamp != "" {
+		file.UploadTimestamp, _ = strconv.ParseInt(timestamp, 10, 64)
 	}
 
 	for k, v := range resp.Header {
@@ -533,7 +620,9 @@ func (c *B2) downloadFile(resp *http.Response, auth *authorizationState) (*File,
 				value = v[0]
 				log.Printf("Unable to decode value: %q", value)
 			}
-			file.FileInfo[key] = value
+			// Header names are canonicalised, but file info keys are
+			// stored in lower case
+			file.FileInfo[strings.ToLower(key)] = value
 		}
 	}
 
@@ -541,15 +630,56 @@ func (c *B2) downloadFile(resp *http.Response, auth *authorizationState) (*File,
 	return file, resp.Body, nil
 }
 
+// Sets the length of a downloaded file, and the range and total size of the
+// file if only part of it was returned, checking that the range matches the
+// length of the download
+func setContentLength(file *File, resp *http.Response) error {
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return err
+	}
+	file.ContentLength = size
+	file.downloadSize = size
+
+	contentRange := resp.Header.Get("Content-Range")
+	if contentRange != "" {
+		var start, end, total int64
+		_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &total)
+		if err != nil {
+			return fmt.Errorf("Unable to parse Content-Range header: %s", err)
+		}
+		if end-start+1 != size {
+			return fmt.Errorf("Content-Range (%d-%d) does not match Content-Length (%d)", start, end, size)
+		}
+		file.downloadRange = &FileRange{Start: start, End: end}
+		file.downloadSize = total
+	}
+	return nil
+}
+
 // ListFileVersions lists all of the versions of all of the files contained in
 // one bucket, in alphabetical order by file name, and by reverse of date/time
 // uploaded for versions of files with the same name.
 func (b *Bucket) ListFileVersions(startFileName, startFileID string, maxFileCount int) (*ListFileVersionsResponse, error) {
+	return b.ListFileVersionsWithPrefix(startFileName, startFileID, maxFileCount, "", "")
+}
+
+// ListFileVersionsWithPrefix lists the versions of files in a bucket, starting at a given name and file ID.
+//
+// Files returned will be limited to those with the given prefix, and if a
+// delimiter is provided, to those within the top folder. See ListFileNamesWithPrefix.
+func (b *Bucket) ListFileVersionsWithPrefix(startFileName, startFileID string, maxFileCount int, prefix, delimiter string) (*ListFileVersionsResponse, error) {
+	if b.b2.Protocol == ProtocolS3 {
+		return b.s3ListFileVersions(startFileName, startFileID, maxFileCount, prefix, delimiter)
+	}
+
 	request := &listFileVersionsRequest{
 		BucketID:      b.ID,
 		StartFileName: startFileName,
 		StartFileID:   startFileID,
 		MaxFileCount:  maxFileCount,
+		Prefix:        prefix,
+		Delimiter:     delimiter,
 	}
 	response := &ListFileVersionsResponse{}
 
@@ -567,6 +697,10 @@ func (b *Bucket) ListFileVersions(startFileName, startFileID string, maxFileCoun
 // version, and be the one that you'll get when downloading by name. See the
 // File Versions page for more details.
 func (b *Bucket) DeleteFileVersion(fileName, fileID string) (*FileStatus, error) {
+	if b.b2.Protocol == ProtocolS3 {
+		return b.s3DeleteFileVersion(fileName, fileID)
+	}
+
 	request := &fileVersionRequest{
 		Name: fileName,
 		ID:   fileID,
@@ -584,6 +718,10 @@ func (b *Bucket) DeleteFileVersion(fileName, fileID string) (*FileStatus, error)
 // but previous versions of the file are still stored. See File Versions about
 // what it means to hide a file.
 func (b *Bucket) HideFile(fileName string) (*FileStatus, error) {
+	if b.b2.Protocol == ProtocolS3 {
+		return b.s3HideFile(fileName)
+	}
+
 	request := &hideFileRequest{
 		BucketID: b.ID,
 		FileName: fileName,
@@ -0,0 +1,323 @@
+package backblaze
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileServer is an http.Handler which serves the files in a bucket.
+//
+// Request paths are mapped to file names by removing the leading slash and
+// adding Prefix. Files are streamed from B2, honouring Range, If-None-Match
+// and If-Modified-Since headers. The ETag of a file is its SHA1 hash, and its
+// modification time is taken from its src_last_modified_millis file info if
+// set, otherwise its upload time. Each request for a file is a single
+// download from B2, including HEAD and Range requests.
+//
+// The Content-Type of a file is the one it was uploaded with, and its
+// Cache-Control is taken from its b2-cache-control file info if set.
+// Paths ending in a slash are listed as folders.
+type FileServer struct {
+	Bucket *Bucket
+
+	// Added to the start of request paths to find file names
+	Prefix string
+
+	// The Cache-Control header sent for files without b2-cache-control file info
+	CacheControl string
+
+	// If true, folders are not listed
+	NoListing bool
+
+	// ErrorLog specifies an optional logger for errors talking to B2.
+	// If nil, logging is done via the log package's standard logger.
+	ErrorLog *log.Logger
+}
+
+// NewFileServer returns a FileServer for the files in a bucket
+func NewFileServer(bucket *Bucket) *FileServer {
+	return &FileServer{Bucket: bucket}
+}
+
+// ServeHTTP responds to GET and HEAD requests for files and folders
+func (s *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filePath := strings.TrimPrefix(r.URL.Path, "/")
+	if filePath == "" || strings.HasSuffix(filePath, "/") {
+		s.serveFolder(w, r, filePath)
+	} else {
+		s.serveFile(w, r, filePath)
+	}
+}
+
+func (s *FileServer) serveFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	ctx := r.Context()
+	name := s.Prefix + filePath
+
+	// Each request downloads the file once. HEAD requests download its first
+	// byte, and range requests only the range, taking the size of the file
+	// from the response. Ranges are ignored for HEAD requests.
+	rangeHeader := "bytes=0-0"
+	var err error
+	if r.Method == http.MethodGet {
+		rangeHeader, err = parseRangeHeader(r.Header.Get("Range"))
+	}
+	var file *File
+	var body io.ReadCloser
+	if err == nil {
+		file, body, err = s.download(ctx, name, rangeHeader)
+		if errors.Is(err, ErrRangeNotSatisfiable) && r.Method == http.MethodHead {
+			// Empty files have no first byte
+			file, body, err = s.download(ctx, name, "")
+		}
+	}
+	if errors.Is(err, ErrNotFound) && s.isFolder(name) {
+		http.Redirect(w, r, "./"+path.Base(filePath)+"/", http.StatusMovedPermanently)
+		return
+	}
+	if errors.Is(err, ErrRangeNotSatisfiable) {
+		// The size of the file is only needed to describe the failure
+		if stat, statErr := s.Bucket.Stat(ctx, name); statErr == nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", stat.ContentLength))
+		}
+	}
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	defer body.Close()
+
+	header := w.Header()
+	if contentType := file.ContentType; contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if cacheControl := file.FileInfo["b2-cache-control"]; cacheControl != "" {
+		header.Set("Cache-Control", cacheControl)
+	} else if s.CacheControl != "" {
+		header.Set("Cache-Control", s.CacheControl)
+	}
+	if etag := fileETag(file); etag != "" {
+		header.Set("ETag", etag)
+	}
+	if modified := file.ModTime(); !modified.IsZero() {
+		header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	header.Set("Accept-Ranges", "bytes")
+
+	if notModified(r, file) {
+		header.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	status := http.StatusOK
+	length := file.downloadSize
+	if fileRange := file.downloadRange; fileRange != nil && r.Method == http.MethodGet {
+		status = http.StatusPartialContent
+		length = file.ContentLength
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", fileRange.Start, fileRange.End, file.downloadSize))
+	}
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodGet {
+		if _, err := io.Copy(w, body); err != nil && ctx.Err() == nil {
+			s.logf("Error sending %s: %v", name, err)
+		}
+	}
+}
+
+// Downloads the current version of a file, sending a Range header if one is given
+func (s *FileServer) download(ctx context.Context, name, rangeHeader string) (*File, io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	file, reader, err := s.Bucket.downloadFileByName(name, rangeHeader, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, &contextReader{ctx, reader}, nil
+}
+
+// Returns true if there are files in a folder of the given name
+func (s *FileServer) isFolder(name string) bool {
+	if s.NoListing {
+		return false
+	}
+	response, err := s.Bucket.ListFileNamesWithPrefix("", 1, name+"/", "")
+	return err == nil && len(response.Files) > 0
+}
+
+// An entry in a folder listing
+type folderEntry struct {
+	Name     string
+	Link     string
+	Size     string
+	Modified string
+}
+
+var folderTemplate = template.Must(template.New("folder").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>/{{.Path}}</title>
+</head>
+<body>
+<h1>/{{.Path}}</h1>
+<table>
+{{if .Path}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Link}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.Modified}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (s *FileServer) serveFolder(w http.ResponseWriter, r *http.Request, folderPath string) {
+	if s.NoListing {
+		http.NotFound(w, r)
+		return
+	}
+
+	prefix := s.Prefix + folderPath
+	var entries []folderEntry
+	err := s.Bucket.List(r.Context(), prefix, "/", func(file *File) error {
+		name := strings.TrimPrefix(file.Name, prefix)
+		entry := folderEntry{
+			Name: name,
+			Link: (&url.URL{Path: name}).String(),
+		}
+		if file.Action != Folder {
+			entry.Size = strconv.FormatInt(file.ContentLength, 10)
+			if modified := file.ModTime(); !modified.IsZero() {
+				entry.Modified = modified.UTC().Format(time.RFC3339)
+			}
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	if len(entries) == 0 && folderPath != "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	err = folderTemplate.Execute(w, struct {
+		Path    string
+		Entries []folderEntry
+	}{folderPath, entries})
+	if err != nil && r.Context().Err() == nil {
+		s.logf("Error listing %s: %v", prefix, err)
+	}
+}
+
+// Responds with the status matching an error
+func (s *FileServer) fail(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, ErrRangeNotSatisfiable):
+		http.Error(w, "416 range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+	case r.Context().Err() != nil:
+		// The client has gone away
+	default:
+		s.logf("Error serving %s: %v", r.URL.Path, err)
+		http.Error(w, "502 bad gateway", http.StatusBadGateway)
+	}
+}
+
+func (s *FileServer) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// Returns the ETag of a file, which is its quoted SHA1 hash
+func fileETag(file *File) string {
+	if sha1 := file.SHA1(); sha1 != "" {
+		return `"` + sha1 + `"`
+	}
+	return ""
+}
+
+// Returns true if a conditional request matches the current version of a
+// file. If-Modified-Since is ignored if If-None-Match is given.
+func notModified(r *http.Request, file *File) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		etag := fileETag(file)
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || etag != "" && tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified := file.ModTime()
+	return !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
+
+// Checks a Range header requesting a single range of bytes, returning the
+// header to send to B2 or an empty string if the whole file should be sent.
+// The range is checked against the size of the file by B2.
+func parseRangeHeader(value string) (string, error) {
+	spec := strings.TrimPrefix(value, "bytes=")
+	i := strings.Index(spec, "-")
+	if spec == value || i < 0 || strings.Contains(spec, ",") {
+		return "", nil
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+
+	if first == "" {
+		// A suffix of the file
+		length, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || length < 0 {
+			return "", nil
+		}
+		if length == 0 {
+			return "", ErrRangeNotSatisfiable
+		}
+		return fmt.Sprintf("bytes=-%d", length), nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return "", nil
+	}
+	if last == "" {
+		return fmt.Sprintf("bytes=%d-", start), nil
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return "", nil
+	}
+	return fmt.Sprintf("bytes=%d-%d", start, end), nil
+}
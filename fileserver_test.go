@@ -0,0 +1,190 @@
+package backblaze_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+	"gopkg.in/kothar/go-backblaze.v0/b2test"
+)
+
+// Records the APIs called by a client
+type apiHook struct {
+	sync.Mutex
+	apis []string
+}
+
+func (h *apiHook) RequestStarted(info *backblaze.RequestInfo) {
+	h.Lock()
+	defer h.Unlock()
+	h.apis = append(h.apis, info.API)
+}
+
+func (h *apiHook) RequestFinished(info *backblaze.RequestInfo) {}
+
+// Returns the APIs called since the last call
+func (h *apiHook) reset() []string {
+	h.Lock()
+	defer h.Unlock()
+	apis := h.apis
+	h.apis = nil
+	return apis
+}
+
+func TestFileServer(T *testing.T) {
+	server := b2test.NewServer()
+	defer server.Close()
+	hook := &apiHook{}
+	client := server.NewClient()
+	client.Hook = hook
+	bucket := b2test.CreateBucket(T, client, "site-bucket")
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	meta := map[string]string{
+		"src_last_modified_millis": "1577934245000",
+		"b2-cache-control":         "max-age=60",
+	}
+	file, err := bucket.UploadTypedFile("site/index.html", "text/html", meta, bytes.NewReader([]byte("<p>hello</p>")))
+	if err != nil {
+		T.Fatal(err)
+	}
+	for _, name := range []string{"site/docs/a.txt", "site/docs/b.txt", "site/docs/sub/c.txt", "site/empty.txt"} {
+		content := name
+		if name == "site/empty.txt" {
+			content = ""
+		}
+		if _, err := bucket.UploadFile(name, nil, bytes.NewReader([]byte(content))); err != nil {
+			T.Fatal(err)
+		}
+	}
+
+	fileServer := backblaze.NewFileServer(bucket)
+	fileServer.Prefix = "site/"
+	fileServer.CacheControl = "no-cache"
+	web := httptest.NewServer(fileServer)
+	defer web.Close()
+
+	get := func(method, path string, header map[string]string) (*http.Response, string) {
+		T.Helper()
+		req, err := http.NewRequest(method, web.URL+path, nil)
+		if err != nil {
+			T.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			T.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			T.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	// Whole files
+	resp, body := get("GET", "/index.html", nil)
+	etag := `"` + file.ContentSha1 + `"`
+	if resp.StatusCode != 200 || body != "<p>hello</p>" {
+		T.Errorf("Unexpected response %d: %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Type") != "text/html" || resp.Header.Get("Cache-Control") != "max-age=60" ||
+		resp.Header.Get("ETag") != etag || resp.Header.Get("Last-Modified") != modified.Format(http.TimeFormat) {
+		T.Errorf("Unexpected headers %v", resp.Header)
+	}
+	resp, body = get("HEAD", "/index.html", nil)
+	if resp.StatusCode != 200 || body != "" || resp.Header.Get("Content-Length") != "12" || resp.Header.Get("ETag") != etag {
+		T.Errorf("Unexpected HEAD response %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp, _ = get("GET", "/docs/a.txt", nil); resp.Header.Get("Cache-Control") != "no-cache" {
+		T.Errorf("Expected default Cache-Control, saw %v", resp.Header)
+	}
+
+	// Ranges
+	resp, body = get("GET", "/index.html", map[string]string{"Range": "bytes=3-7"})
+	if resp.StatusCode != 206 || body != "hello" || resp.Header.Get("Content-Range") != "bytes 3-7/12" {
+		T.Errorf("Unexpected range response %d %q %v", resp.StatusCode, body, resp.Header)
+	}
+	resp, body = get("GET", "/index.html", map[string]string{"Range": "bytes=-4"})
+	if resp.StatusCode != 206 || body != "</p>" {
+		T.Errorf("Unexpected suffix range response %d %q", resp.StatusCode, body)
+	}
+	resp, _ = get("GET", "/index.html", map[string]string{"Range": "bytes=20-"})
+	if resp.StatusCode != 416 || resp.Header.Get("Content-Range") != "bytes */12" {
+		T.Errorf("Expected unsatisfiable range, saw %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// Files are downloaded once for each request, without being listed
+	hook.reset()
+	for _, header := range []map[string]string{nil, {"Range": "bytes=3-7"}, {"Range": "bytes=-4"}} {
+		for _, method := range []string{"GET", "HEAD"} {
+			get(method, "/index.html", header)
+			if apis := hook.reset(); len(apis) != 1 || apis[0] != "b2_download_file_by_name" {
+				T.Errorf("Expected one download for %s %v, saw %v", method, header, apis)
+			}
+		}
+	}
+	resp, _ = get("HEAD", "/index.html", map[string]string{"Range": "bytes=3-7"})
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Length") != "12" || resp.Header.Get("Content-Range") != "" {
+		T.Errorf("Expected range to be ignored for HEAD, saw %d %v", resp.StatusCode, resp.Header)
+	}
+	resp, _ = get("HEAD", "/empty.txt", nil)
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Length") != "0" {
+		T.Errorf("Unexpected HEAD response for empty file %d %v", resp.StatusCode, resp.Header)
+	}
+	resp, _ = get("GET", "/empty.txt", map[string]string{"Range": "bytes=0-"})
+	if resp.StatusCode != 416 || resp.Header.Get("Content-Range") != "bytes */0" {
+		T.Errorf("Expected unsatisfiable range for empty file, saw %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// Conditional requests
+	conditions := []struct {
+		header map[string]string
+		status int
+	}{
+		{map[string]string{"If-None-Match": etag}, 304},
+		{map[string]string{"If-None-Match": `"other", W/` + etag}, 304},
+		{map[string]string{"If-None-Match": `"other"`}, 200},
+		{map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, 304},
+		{map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, 200},
+		{map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modified.Format(http.TimeFormat)}, 200},
+	}
+	for _, c := range conditions {
+		if resp, _ := get("GET", "/index.html", c.header); resp.StatusCode != c.status {
+			T.Errorf("Expected status %d for %v, saw %d", c.status, c.header, resp.StatusCode)
+		}
+	}
+
+	// Folders
+	resp, body = get("GET", "/docs/", nil)
+	if resp.StatusCode != 200 || !strings.Contains(body, `<a href="a.txt">a.txt</a>`) ||
+		!strings.Contains(body, `<a href="sub/">sub/</a>`) || strings.Contains(body, "c.txt") {
+		T.Errorf("Unexpected folder listing %d: %s", resp.StatusCode, body)
+	}
+	resp, _ = get("GET", "/docs", nil)
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/docs/" {
+		T.Errorf("Expected redirect to folder, saw %d %v", resp.StatusCode, resp.Header)
+	}
+	if resp, _ = get("GET", "/missing", nil); resp.StatusCode != 404 {
+		T.Errorf("Expected missing file to be not found, saw %d", resp.StatusCode)
+	}
+	if resp, _ = get("GET", "/missing/", nil); resp.StatusCode != 404 {
+		T.Errorf("Expected missing folder to be not found, saw %d", resp.StatusCode)
+	}
+	if resp, _ = get("POST", "/index.html", nil); resp.StatusCode != http.StatusMethodNotAllowed {
+		T.Errorf("Expected POST to be rejected, saw %d", resp.StatusCode)
+	}
+
+	fileServer.NoListing = true
+	if resp, _ = get("GET", "/docs/", nil); resp.StatusCode != 404 {
+		T.Errorf("Expected listing to be disabled, saw %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,13 @@
+module gopkg.in/kothar/go-backblaze.v0
+
+go 1.13
+
+require (
+	github.com/dustin/go-humanize v1.0.1
+	github.com/google/readahead v0.0.0-20161222183148-eaceba169032
+	github.com/gosuri/uilive v0.0.4 // indirect
+	github.com/gosuri/uiprogress v0.0.1
+	github.com/jessevdk/go-flags v1.5.0
+	github.com/pquerna/ffjson v0.0.0-20190930134022-aa0246cd15f7
+	golang.org/x/net v0.11.0
+)
@@ -0,0 +1,138 @@
+package backblaze
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RequestInfo describes a single HTTP request made to the B2 service.
+// The same RequestInfo is passed to RequestStarted and RequestFinished.
+type RequestInfo struct {
+	// The name of the B2 API being called, e.g. "b2_list_file_names"
+	API string
+
+	// The ID of the bucket the request relates to, if known
+	Bucket string
+
+	// The number of transactions the request is billed as. Listing more than
+	// 1000 files in one request is billed as several transactions.
+	Transactions int
+
+	// The HTTP status code of the response, or 0 if no response was received
+	Status int
+
+	// Number of bytes in the request and response bodies
+	BytesSent     int64
+	BytesReceived int64
+
+	// The time the request was started, and how long it took to complete
+	// including reading the response body
+	Start    time.Time
+	Duration time.Duration
+
+	// Set if the request failed without receiving a response
+	Err error
+}
+
+// RequestHook observes the requests made by a B2 client.
+//
+// A hook is invoked for every API call, upload and download, including
+// retries and account authorization. Hooks may be called concurrently and
+// should return quickly.
+type RequestHook interface {
+	// RequestStarted is called immediately before a request is sent
+	RequestStarted(info *RequestInfo)
+
+	// RequestFinished is called once the response body has been read and
+	// closed, or the request has failed. For downloads, this happens when
+	// the caller closes the returned reader.
+	RequestFinished(info *RequestInfo)
+}
+
+// Implemented by API requests which relate to a single bucket
+type bucketRequester interface {
+	bucketID() string
+}
+
+func (r *bucketRequest) bucketID() string           { return r.ID }
+func (r *listBucketsRequest) bucketID() string      { return r.BucketID }
+func (r *deleteBucketRequest) bucketID() string     { return r.BucketID }
+func (r *updateBucketRequest) bucketID() string     { return r.BucketID }
+func (r *listFilesRequest) bucketID() string        { return r.BucketID }
+func (r *listFileVersionsRequest) bucketID() string { return r.BucketID }
+func (r *hideFileRequest) bucketID() string         { return r.BucketID }
+func (r *fileCopyRequest) bucketID() string         { return r.DestinationBucketID }
+func (r *startLargeFileRequest) bucketID() string   { return r.BucketID }
+
+// Returns the bucket ID for an API request, if known
+func requestBucket(request interface{}) string {
+	if r, ok := request.(bucketRequester); ok {
+		return r.bucketID()
+	}
+	return ""
+}
+
+// Sends an HTTP request, notifying the client's hook when the request starts
+// and when the response body is closed. The API and Bucket of the provided
+// RequestInfo should be set.
+func (c *B2) doRequest(req *http.Request, template RequestInfo) (*http.Response, error) {
+	info := &template
+	info.Start = time.Now()
+	if info.Transactions == 0 {
+		info.Transactions = 1
+	}
+	if req.ContentLength > 0 {
+		info.BytesSent = req.ContentLength
+	}
+	if c.Hook != nil {
+		c.Hook.RequestStarted(info)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		info.Err = err
+		c.finishRequest(info)
+		return nil, err
+	}
+
+	info.Status = resp.StatusCode
+	resp.Body = &hookedBody{
+		ReadCloser: resp.Body,
+		c:          c,
+		info:       info,
+	}
+	return resp, nil
+}
+
+func (c *B2) finishRequest(info *RequestInfo) {
+	info.Duration = time.Since(info.Start)
+	c.usage.record(info)
+	if c.Hook != nil {
+		c.Hook.RequestFinished(info)
+	}
+}
+
+// Counts the bytes read from a response body, and finishes the request when
+// the body is closed
+type hookedBody struct {
+	io.ReadCloser
+	c    *B2
+	info *RequestInfo
+	once sync.Once
+}
+
+func (b *hookedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.info.BytesReceived += int64(n)
+	return n, err
+}
+
+func (b *hookedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.c.finishRequest(b.info)
+	})
+	return err
+}
@@ -0,0 +1,104 @@
+package backblaze
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+type recordingHook struct {
+	sync.Mutex
+	started  []*RequestInfo
+	finished []*RequestInfo
+}
+
+func (h *recordingHook) RequestStarted(info *RequestInfo) {
+	h.Lock()
+	defer h.Unlock()
+	h.started = append(h.started, info)
+}
+
+func (h *recordingHook) RequestFinished(info *RequestInfo) {
+	h.Lock()
+	defer h.Unlock()
+	h.finished = append(h.finished, info)
+}
+
+func TestRequestHook(T *testing.T) {
+
+	accountID := "test"
+	testFile := []byte("File contents")
+
+	client, server := prepareResponses([]response{
+		{code: 200, body: authorizeAccountResponse{
+			AccountID:          accountID,
+			APIEndpoint:        "http://api.url",
+			AuthorizationToken: "testToken",
+			DownloadURL:        "http://download.url",
+		}},
+		{code: 200, body: ListFilesResponse{}},
+		{code: 200, body: testFile},
+	})
+	defer server.Close()
+
+	hook := &recordingHook{}
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      accountID,
+			ApplicationKey: "test",
+		},
+		Debug:      testing.Verbose(),
+		Hook:       hook,
+		httpClient: *client,
+		host:       server.URL,
+	}
+	bucket := &Bucket{
+		BucketInfo: &BucketInfo{ID: "bucketid", Name: "testbucket"},
+		b2:         b2,
+	}
+
+	if _, err := bucket.ListFileNames("", 10); err != nil {
+		T.Fatal(err)
+	}
+
+	_, reader, err := b2.DownloadFileByID("fileId")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		T.Fatal(err)
+	}
+
+	if len(hook.started) != 3 || len(hook.finished) != 2 {
+		T.Fatalf("Expected 3 requests started and 2 finished before download is closed, saw %d and %d", len(hook.started), len(hook.finished))
+	}
+	reader.Close()
+
+	expected := []struct {
+		api    string
+		bucket string
+	}{
+		{"b2_authorize_account", ""},
+		{"b2_list_file_names", "bucketid"},
+		{"b2_download_file_by_id", ""},
+	}
+	if len(hook.finished) != len(expected) {
+		T.Fatalf("Expected %d requests finished, saw %d", len(expected), len(hook.finished))
+	}
+	for i, e := range expected {
+		info := hook.finished[i]
+		if info.API != e.api || info.Bucket != e.bucket {
+			T.Errorf("Expected request %d to be %s on %q, saw %s on %q", i, e.api, e.bucket, info.API, info.Bucket)
+		}
+		if info.Status != 200 {
+			T.Errorf("Expected %s to return status 200, saw %d", info.API, info.Status)
+		}
+	}
+
+	if received := hook.finished[2].BytesReceived; received != int64(len(testFile)) {
+		T.Errorf("Expected download to receive %d bytes, saw %d", len(testFile), received)
+	}
+	if sent := hook.finished[1].BytesSent; sent == 0 {
+		T.Errorf("Expected API request to record bytes sent")
+	}
+}
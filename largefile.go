@@ -0,0 +1,274 @@
+package backblaze
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+const (
+	// Part sizes used if the service does not specify them
+	defaultRecommendedPartSize     = 100 * 1000 * 1000
+	defaultAbsoluteMinimumPartSize = 5 * 1000 * 1000
+
+	// The maximum number of parts in a large file
+	maxLargeFileParts = 10000
+)
+
+// UploadLargeFile uploads a file to B2 in parts using the large file API,
+// with the part size recommended by the service. Large files must consist of
+// at least two parts.
+func (b *Bucket) UploadLargeFile(name, contentType string, meta map[string]string, file io.ReaderAt, size int64) (*File, error) {
+	return b.UploadLargeFileOptionsWithProgress(name, contentType, meta, file, size, 0, 1, nil)
+}
+
+// UploadLargeFileWithProgress extends UploadLargeFile to report the progress of the upload
+// to the provided ProgressFunc, which may be nil.
+func (b *Bucket) UploadLargeFileWithProgress(name, contentType string, meta map[string]string, file io.ReaderAt, size int64, progress ProgressFunc) (*File, error) {
+	return b.UploadLargeFileOptionsWithProgress(name, contentType, meta, file, size, 0, 1, progress)
+}
+
+// UploadLargeFileOptions uploads a large file with the given part size,
+// uploading up to numWorkers parts at once. If partSize is zero, the part
+// size recommended by the service is used. If the file is no larger than
+// one part, it is split into two parts if possible.
+func (b *Bucket) UploadLargeFileOptions(name, contentType string, meta map[string]string, file io.ReaderAt, size, partSize int64, numWorkers int) (*File, error) {
+	return b.UploadLargeFileOptionsWithProgress(name, contentType, meta, file, size, partSize, numWorkers, nil)
+}
+
+// UploadLargeFileOptionsWithProgress extends UploadLargeFileOptions to report the progress
+// of the upload to the provided ProgressFunc, which may be nil.
+//
+// If the upload fails, the unfinished large file is cancelled.
+func (b *Bucket) UploadLargeFileOptionsWithProgress(name, contentType string, meta map[string]string, file io.ReaderAt, size, partSize int64, numWorkers int, progress ProgressFunc) (*File, error) {
+	auth, err := b.b2.authorization()
+	if err != nil {
+		return nil, err
+	}
+
+	partSize = largeFilePartSize(size, partSize, auth.authorizeAccountResponse)
+	parts := int((size + partSize - 1) / partSize)
+	if parts < 2 {
+		return nil, fmt.Errorf("large file %q must consist of at least two parts of %d bytes: %w", name, partSize, ErrLargeFileTooSmall)
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	request := &startLargeFileRequest{
+		BucketID:    b.ID,
+		FileName:    name,
+		ContentType: contentType,
+		FileInfo:    meta,
+	}
+	largeFile := &File{}
+	if err := b.b2.apiRequest("b2_start_large_file", request, largeFile); err != nil {
+		return nil, err
+	}
+
+	tracker := newProgressTracker(name, size, parts, progress)
+	hashes := make([]string, parts)
+	tasks := make(chan int, parts)
+	for i := 0; i < parts; i++ {
+		tasks <- i
+	}
+	close(tasks)
+
+	var mutex sync.Mutex
+	var firstErr error
+	failed := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return firstErr != nil
+	}
+
+	// Each worker uploads parts using its own upload URL
+	wg := sync.WaitGroup{}
+	for i := 0; i < numWorkers && i < parts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var partAuth *UploadAuth
+			for part := range tasks {
+				if failed() {
+					return
+				}
+
+				offset := int64(part) * partSize
+				length := partSize
+				if offset+length > size {
+					length = size - offset
+				}
+
+				hash, err := b.uploadLargeFilePart(largeFile.ID, &partAuth, io.NewSectionReader(file, offset, length), part+1, length, tracker)
+				if err != nil {
+					mutex.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mutex.Unlock()
+					return
+				}
+				hashes[part] = hash
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Unfinished large files are cancelled, so that their parts aren't stored
+	cancel := func() {
+		if err := b.b2.cancelLargeFile(largeFile.ID); err != nil && b.b2.Debug {
+			log.Printf("Unable to cancel large file %q: %v", name, err)
+		}
+	}
+	if firstErr != nil {
+		cancel()
+		return nil, firstErr
+	}
+
+	finished := &File{}
+	finish := &finishLargeFileRequest{
+		ID:            largeFile.ID,
+		PartSha1Array: hashes,
+	}
+	if err := b.b2.apiRequest("b2_finish_large_file", finish, finished); err != nil {
+		cancel()
+		return nil, err
+	}
+	return finished, nil
+}
+
+// Chooses the part size for a large file, within the limits set by the service
+func largeFilePartSize(size, partSize int64, auth *authorizeAccountResponse) int64 {
+	recommended := auth.RecommendedPartSize
+	if recommended <= 0 {
+		recommended = defaultRecommendedPartSize
+	}
+	minimum := auth.AbsoluteMinimumPartSize
+	if minimum <= 0 {
+		minimum = defaultAbsoluteMinimumPartSize
+	}
+
+	if partSize <= 0 {
+		partSize = recommended
+	}
+	if partSize < minimum {
+		partSize = minimum
+	}
+	if size <= partSize && size >= 2*minimum {
+		// Split files smaller than a part in two
+		partSize = (size + 1) / 2
+	}
+	if min := (size + maxLargeFileParts - 1) / maxLargeFileParts; partSize < min {
+		partSize = min
+	}
+	return partSize
+}
+
+// Uploads a single part of a large file, returning its SHA1 hash. An upload
+// URL is requested if partAuth is not set, and replaced if the upload fails.
+func (b *Bucket) uploadLargeFilePart(fileID string, partAuth **UploadAuth, part *io.SectionReader, partNumber int, length int64, tracker *progressTracker) (string, error) {
+	hash := sha1.New()
+	if _, err := io.Copy(hash, part); err != nil {
+		return "", err
+	}
+	sha1Hash := hex.EncodeToString(hash.Sum(nil))
+
+	for attempt := 0; ; attempt++ {
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+
+		if *partAuth == nil {
+			auth, err := b.b2.getUploadPartURL(fileID)
+			if err != nil {
+				return "", err
+			}
+			*partAuth = auth
+		}
+
+		err := b.tryUploadLargeFilePart(*partAuth, part, partNumber, length, sha1Hash, tracker)
+		if err == nil {
+			tracker.partDone()
+			return sha1Hash, nil
+		}
+		*partAuth = nil
+
+		// Retry after non-fatal errors
+		var b2err *B2Error
+		if attempt > 0 || b.b2.NoRetry || !errors.As(err, &b2err) || b2err.IsFatal() {
+			return "", requestError(err, "b2_upload_part", "", fileID)
+		}
+
+		// Discount the bytes sent by the failed attempt
+		sent, _ := part.Seek(0, io.SeekCurrent)
+		tracker.add(-int(sent))
+		tracker.retry(false)
+	}
+}
+
+func (b *Bucket) tryUploadLargeFilePart(auth *UploadAuth, part io.Reader, partNumber int, length int64, sha1Hash string, tracker *progressTracker) error {
+	req, err := http.NewRequest("POST", auth.UploadURL.String(), trackReader(part, tracker))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", auth.AuthorizationToken)
+	req.Header.Set("X-Bz-Part-Number", strconv.Itoa(partNumber))
+	req.Header.Set("X-Bz-Content-Sha1", sha1Hash)
+	req.ContentLength = length
+
+	resp, err := b.b2.doRequest(req, RequestInfo{API: "b2_upload_part", Bucket: b.ID})
+	if err != nil {
+		return err
+	}
+
+	// We are not dealing with the b2 client auth token in this case, hence the nil auth
+	result := &uploadPartResponse{}
+	if err := b.b2.parseResponse(resp, result, nil); err != nil {
+		return err
+	}
+
+	if sha1Hash != result.ContentSha1 {
+		return fmt.Errorf("SHA1 of uploaded part %d does not match local hash: %w", partNumber, ErrChecksumMismatch)
+	}
+	return nil
+}
+
+// Retrieves a URL for uploading parts of a large file
+func (c *B2) getUploadPartURL(fileID string) (*UploadAuth, error) {
+	request := &fileRequest{
+		ID: fileID,
+	}
+	response := &getUploadPartURLResponse{}
+	if err := c.apiRequest("b2_get_upload_part_url", request, response); err != nil {
+		return nil, err
+	}
+
+	uploadURL, err := url.Parse(response.UploadURL)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadAuth{
+		AuthorizationToken: response.AuthorizationToken,
+		UploadURL:          uploadURL,
+		Valid:              true,
+	}, nil
+}
+
+// Cancels an unfinished large file, deleting the parts which have been uploaded
+func (c *B2) cancelLargeFile(fileID string) error {
+	request := &fileRequest{
+		ID: fileID,
+	}
+	response := &FileStatus{}
+	return c.apiRequest("b2_cancel_large_file", request, response)
+}
@@ -0,0 +1,196 @@
+package backblaze
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// A server which implements the large file API, storing uploaded parts
+type largeFileServer struct {
+	*httptest.Server
+
+	sync.Mutex
+	parts      map[int][]byte
+	failPart   int
+	failFinish bool
+	cancelled  bool
+}
+
+func newLargeFileServer() *largeFileServer {
+	s := &largeFileServer{parts: make(map[int][]byte)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *largeFileServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case v1 + "b2_authorize_account":
+		fmt.Fprint(w, toJSON(authorizeAccountResponse{
+			AccountID:               "test",
+			APIEndpoint:             s.URL,
+			AuthorizationToken:      "token",
+			DownloadURL:             s.URL,
+			RecommendedPartSize:     10,
+			AbsoluteMinimumPartSize: 5,
+		}))
+	case v1 + "b2_start_large_file":
+		request := &startLargeFileRequest{}
+		body, _ := ioutil.ReadAll(r.Body)
+		ffjson.Unmarshal(body, request)
+		fmt.Fprint(w, toJSON(File{
+			ID:       "largeFileId",
+			Name:     request.FileName,
+			BucketID: request.BucketID,
+		}))
+	case v1 + "b2_get_upload_part_url":
+		fmt.Fprint(w, toJSON(getUploadPartURLResponse{
+			FileID:             "largeFileId",
+			UploadURL:          s.URL + "/upload",
+			AuthorizationToken: "uploadToken",
+		}))
+	case "/upload":
+		partNumber, _ := strconv.Atoi(r.Header.Get("X-Bz-Part-Number"))
+		body, _ := ioutil.ReadAll(r.Body)
+		if partNumber == s.failPart {
+			w.WriteHeader(400)
+			fmt.Fprint(w, toJSON(B2Error{Status: 400, Code: "bad_request", Message: "Part rejected"}))
+			return
+		}
+		s.parts[partNumber] = body
+		hash := sha1.Sum(body)
+		fmt.Fprint(w, toJSON(uploadPartResponse{
+			FileID:        "largeFileId",
+			PartNumber:    partNumber,
+			ContentLength: int64(len(body)),
+			ContentSha1:   hex.EncodeToString(hash[:]),
+		}))
+	case v1 + "b2_finish_large_file":
+		if s.failFinish {
+			w.WriteHeader(400)
+			fmt.Fprint(w, toJSON(B2Error{Status: 400, Code: "bad_request", Message: "Finish rejected"}))
+			return
+		}
+		request := &finishLargeFileRequest{}
+		body, _ := ioutil.ReadAll(r.Body)
+		ffjson.Unmarshal(body, request)
+		var contents []byte
+		for i, partHash := range request.PartSha1Array {
+			part := s.parts[i+1]
+			hash := sha1.Sum(part)
+			if hex.EncodeToString(hash[:]) != partHash {
+				w.WriteHeader(400)
+				fmt.Fprint(w, toJSON(B2Error{Status: 400, Code: "bad_request", Message: "Part hash mismatch"}))
+				return
+			}
+			contents = append(contents, part...)
+		}
+		fmt.Fprint(w, toJSON(File{
+			ID:            request.ID,
+			ContentLength: int64(len(contents)),
+		}))
+	case v1 + "b2_cancel_large_file":
+		s.cancelled = true
+		fmt.Fprint(w, toJSON(File{ID: "largeFileId"}))
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func TestUploadLargeFile(T *testing.T) {
+	server := newLargeFileServer()
+	defer server.Close()
+
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      "test",
+			ApplicationKey: "test",
+		},
+		Debug: testing.Verbose(),
+		host:  server.URL,
+	}
+	bucket := &Bucket{
+		BucketInfo: &BucketInfo{ID: "bucketid", Name: "testbucket"},
+		b2:         b2,
+	}
+
+	contents := []byte("Large file contents, in three parts")
+	var last Progress
+	var progressMutex sync.Mutex
+	file, err := bucket.UploadLargeFileOptionsWithProgress("large.txt", "text/plain", nil, bytes.NewReader(contents), int64(len(contents)), 12, 2, func(p Progress) {
+		progressMutex.Lock()
+		defer progressMutex.Unlock()
+		last = p
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	if file.ID != "largeFileId" || file.ContentLength != int64(len(contents)) {
+		T.Errorf("Unexpected file %+v", file)
+	}
+	server.Lock()
+	if len(server.parts) != 3 {
+		T.Errorf("Expected 3 parts to be uploaded, saw %d", len(server.parts))
+	}
+	server.Unlock()
+	if last.PartsCompleted != 3 || last.Transferred != int64(len(contents)) {
+		T.Errorf("Expected 3 parts and %d bytes to be transferred, saw %+v", len(contents), last)
+	}
+
+	// The file must consist of more than one part
+	if _, err := bucket.UploadLargeFile("small.txt", "text/plain", nil, bytes.NewReader(contents), 8); !errors.Is(err, ErrLargeFileTooSmall) {
+		T.Errorf("Expected error uploading a single part, saw %v", err)
+	}
+}
+
+func TestUploadLargeFileCancel(T *testing.T) {
+	// Uploads fail when a part is rejected, or when the file can't be finished
+	for _, failFinish := range []bool{false, true} {
+		server := newLargeFileServer()
+		if failFinish {
+			server.failFinish = true
+		} else {
+			server.failPart = 2
+		}
+		defer server.Close()
+
+		b2 := &B2{
+			Credentials: Credentials{
+				AccountID:      "test",
+				ApplicationKey: "test",
+			},
+			Debug: testing.Verbose(),
+			host:  server.URL,
+		}
+		bucket := &Bucket{
+			BucketInfo: &BucketInfo{ID: "bucketid", Name: "testbucket"},
+			b2:         b2,
+		}
+
+		contents := []byte("Large file contents, in three parts")
+		_, err := bucket.UploadLargeFileOptions("large.txt", "text/plain", nil, bytes.NewReader(contents), int64(len(contents)), 12, 1)
+		if err == nil {
+			T.Fatalf("Expected upload to fail with failFinish %v", failFinish)
+		}
+		server.Lock()
+		if !server.cancelled {
+			T.Errorf("Expected unfinished large file to be cancelled with failFinish %v", failFinish)
+		}
+		server.Unlock()
+	}
+}
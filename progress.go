@@ -0,0 +1,169 @@
+package backblaze
+
+import (
+	"io"
+	"sync"
+)
+
+// Progress describes the state of an upload or download at the time a
+// ProgressFunc is invoked
+type Progress struct {
+	// The name of the file being transferred
+	Name string
+
+	// Number of bytes transferred so far. This is reset to zero if the
+	// transfer is retried from the beginning.
+	Transferred int64
+
+	// Total number of bytes expected, or -1 if not known
+	Total int64
+
+	// Number of parts which have been transferred completely, and the total
+	// number of parts. Simple transfers consist of a single part, while
+	// readahead downloads report each chunk as a part.
+	PartsCompleted int
+	PartsTotal     int
+
+	// Number of times a request has been retried during this transfer
+	Retries int
+}
+
+// ProgressFunc receives progress updates for a transfer.
+//
+// Calls for a single transfer are serialised, but may be made from several
+// goroutines when parts are transferred concurrently. The function should
+// return quickly, as it is called while data is being transferred.
+type ProgressFunc func(p Progress)
+
+// Tracks and reports the progress of a single transfer. All methods may be
+// called on a nil tracker, in which case nothing is reported
+type progressTracker struct {
+	sync.Mutex
+	progress Progress
+	report   ProgressFunc
+
+	// Set once the name and size of the file are known
+	started bool
+}
+
+func newProgressTracker(name string, total int64, parts int, report ProgressFunc) *progressTracker {
+	if report == nil {
+		return nil
+	}
+	return &progressTracker{
+		progress: Progress{
+			Name:       name,
+			Total:      total,
+			PartsTotal: parts,
+		},
+		report:  report,
+		started: total >= 0,
+	}
+}
+
+// Sets the file name and size once they are known from the response
+func (t *progressTracker) start(name string, total int64) {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.progress.Name = name
+	t.progress.Total = total
+	t.started = true
+}
+
+// Records n bytes transferred
+func (t *progressTracker) add(n int) {
+	if t == nil || n == 0 {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.progress.Transferred += int64(n)
+	t.report(t.progress)
+}
+
+// Records the completion of a part
+func (t *progressTracker) partDone() {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.progress.PartsCompleted++
+	t.report(t.progress)
+}
+
+// Records a retry. If restart is true, the transfer is starting again from
+// the beginning and the transferred byte count is reset. Retries made before
+// the transfer has started are reported with the first progress update.
+func (t *progressTracker) retry(restart bool) {
+	if t == nil {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	t.progress.Retries++
+	if restart {
+		t.progress.Transferred = 0
+	}
+	if t.started {
+		t.report(t.progress)
+	}
+}
+
+// Wraps a reader to report the bytes read through it. If the tracker is nil,
+// the original reader is returned.
+func trackReader(r io.Reader, t *progressTracker) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &progressReader{r: r, tracker: t}
+}
+
+// Wraps a response body to report the bytes read through it, and the
+// completion of the part when the end of the body is reached
+func trackReadCloser(r io.ReadCloser, t *progressTracker) io.ReadCloser {
+	if t == nil {
+		return r
+	}
+	return &progressReadCloser{
+		progressReader: progressReader{r: r, tracker: t, reportDone: true},
+		closer:         r,
+	}
+}
+
+type progressReader struct {
+	r          io.Reader
+	tracker    *progressTracker
+	reportDone bool
+	done       bool
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.tracker.add(n)
+	if err == io.EOF && p.reportDone && !p.done {
+		p.done = true
+		p.tracker.partDone()
+	}
+	return n, err
+}
+
+type progressReadCloser struct {
+	progressReader
+	closer io.Closer
+}
+
+func (p *progressReadCloser) Close() error {
+	return p.closer.Close()
+}
@@ -0,0 +1,200 @@
+package backblaze
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"testing"
+)
+
+func TestUploadProgress(T *testing.T) {
+
+	accountID := "test"
+	testFile := []byte("File contents")
+	hash := sha1.Sum(testFile)
+	sha1Hash := hex.EncodeToString(hash[:])
+
+	client, server := prepareResponses([]response{
+		{code: 200, body: authorizeAccountResponse{
+			AccountID:          accountID,
+			APIEndpoint:        "http://api.url",
+			AuthorizationToken: "testToken",
+			DownloadURL:        "http://download.url",
+		}},
+		{code: 200, body: getUploadURLResponse{
+			BucketID:           "bucketid",
+			UploadURL:          "http://upload.url/upload",
+			AuthorizationToken: "uploadToken",
+		}},
+		{code: 200, body: File{
+			ID:            "fileId",
+			Name:          "test.txt",
+			ContentLength: int64(len(testFile)),
+			ContentSha1:   sha1Hash,
+		}},
+	})
+	defer server.Close()
+
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      accountID,
+			ApplicationKey: "test",
+		},
+		Debug:      testing.Verbose(),
+		httpClient: *client,
+		host:       server.URL,
+	}
+	bucket := &Bucket{
+		BucketInfo: &BucketInfo{ID: "bucketid", Name: "testbucket"},
+		b2:         b2,
+	}
+
+	var last Progress
+	_, err := bucket.UploadTypedFileWithProgress("test.txt", "text/plain", nil, bytes.NewReader(testFile), func(p Progress) {
+		last = p
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+
+	if last.Name != "test.txt" {
+		T.Errorf("Expected progress for %q, saw %q", "test.txt", last.Name)
+	}
+	if last.Transferred != int64(len(testFile)) || last.Total != int64(len(testFile)) {
+		T.Errorf("Expected %d of %d bytes transferred, saw %d of %d", len(testFile), len(testFile), last.Transferred, last.Total)
+	}
+	if last.PartsCompleted != 1 || last.PartsTotal != 1 {
+		T.Errorf("Expected 1 of 1 parts completed, saw %d of %d", last.PartsCompleted, last.PartsTotal)
+	}
+}
+
+func TestDownloadProgress(T *testing.T) {
+
+	accountID := "test"
+	testFile := []byte("File contents")
+
+	client, server := prepareResponses([]response{
+		{code: 200, body: authorizeAccountResponse{
+			AccountID:          accountID,
+			APIEndpoint:        "http://api.url",
+			AuthorizationToken: "testToken",
+			DownloadURL:        "http://download.url",
+		}},
+		{code: 401, body: B2Error{
+			Status:  401,
+			Code:    "expired_auth_token",
+			Message: "Authentication token expired",
+		}},
+		{code: 200, body: authorizeAccountResponse{
+			AccountID:          accountID,
+			APIEndpoint:        "http://api.url",
+			AuthorizationToken: "testToken2",
+			DownloadURL:        "http://download.url",
+		}},
+		{code: 200, body: testFile, headers: map[string]string{
+			"X-Bz-File-Name": "test.txt",
+		}},
+	})
+	defer server.Close()
+
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      accountID,
+			ApplicationKey: "test",
+		},
+		Debug:      testing.Verbose(),
+		httpClient: *client,
+		host:       server.URL,
+	}
+
+	var last Progress
+	_, reader, err := b2.DownloadFileRangeByIDWithProgress("fileId", nil, func(p Progress) {
+		last = p
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer reader.Close()
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if !bytes.Equal(body, testFile) {
+		T.Errorf("Expected file contents to be [%s], saw [%s]", testFile, body)
+	}
+
+	if last.Name != "test.txt" {
+		T.Errorf("Expected progress for %q, saw %q", "test.txt", last.Name)
+	}
+	if last.Transferred != int64(len(testFile)) || last.Total != int64(len(testFile)) {
+		T.Errorf("Expected %d of %d bytes transferred, saw %d of %d", len(testFile), len(testFile), last.Transferred, last.Total)
+	}
+	if last.PartsCompleted != 1 {
+		T.Errorf("Expected part to be completed, saw %d", last.PartsCompleted)
+	}
+	if last.Retries != 1 {
+		T.Errorf("Expected 1 retry, saw %d", last.Retries)
+	}
+}
+
+func TestDownloadProgressRetry(T *testing.T) {
+
+	accountID := "test"
+	testFile := []byte("File contents")
+
+	client, server := prepareResponses([]response{
+		{code: 200, body: authorizeAccountResponse{
+			AccountID:          accountID,
+			APIEndpoint:        "http://api.url",
+			AuthorizationToken: "testToken",
+			DownloadURL:        "http://download.url",
+		}},
+		{code: 503, body: B2Error{
+			Status:  503,
+			Code:    "service_unavailable",
+			Message: "Service unavailable",
+		}},
+		{code: 200, body: testFile, headers: map[string]string{
+			"X-Bz-File-Name": "test.txt",
+		}},
+	})
+	defer server.Close()
+
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      accountID,
+			ApplicationKey: "test",
+		},
+		Debug:      testing.Verbose(),
+		httpClient: *client,
+		host:       server.URL,
+	}
+	bucket := &Bucket{
+		BucketInfo: &BucketInfo{ID: "bucketid", Name: "testbucket"},
+		b2:         b2,
+	}
+
+	// Retries are only reported once the name and size of the file are known
+	var reports []Progress
+	_, reader, err := bucket.DownloadFileRangeByNameWithProgress("test.txt", nil, func(p Progress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		T.Fatal(err)
+	}
+
+	if len(reports) == 0 {
+		T.Fatal("Expected progress to be reported")
+	}
+	for _, p := range reports {
+		if p.Name != "test.txt" || p.Total != int64(len(testFile)) || p.Retries != 1 {
+			T.Errorf("Unexpected progress %+v", p)
+		}
+	}
+}
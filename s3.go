@@ -0,0 +1,556 @@
+package backblaze
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocol selects the API a client uses for file operations on buckets
+type Protocol string
+
+// Protocols which may be used by a client
+const (
+	// The native B2 API
+	ProtocolNative Protocol = ""
+
+	// The S3-compatible API, with requests signed using AWS Signature
+	// Version 4. The application key ID and key are used as the access key
+	// and secret key.
+	ProtocolS3 Protocol = "s3"
+)
+
+// The region used for signing if it can't be found from the endpoint
+const defaultS3Region = "us-east-1"
+
+// A request to the S3-compatible API
+type s3Request struct {
+	op       string // The S3 operation, e.g. "PutObject"
+	bucketID string
+	method   string
+	bucket   string // The bucket name
+	key      string
+	query    url.Values
+	header   http.Header
+	body     io.Reader
+	length   int64
+}
+
+// An error response from the S3-compatible API
+type s3Error struct {
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+	RequestID string `xml:"RequestId"`
+}
+
+type s3ListVersionsResult struct {
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string `xml:"NextVersionIdMarker"`
+	CommonPrefixes      []struct {
+		Prefix string
+	}
+
+	// Versions and delete markers, in order. Other elements are ignored.
+	Entries []s3Version `xml:",any"`
+}
+
+type s3Version struct {
+	XMLName      xml.Name
+	Key          string
+	VersionID    string `xml:"VersionId"`
+	IsLatest     bool
+	LastModified string
+	Size         int64
+}
+
+type s3CopyResult struct {
+	LastModified string
+}
+
+// Returns the endpoint and region of the S3-compatible API
+func (c *B2) s3Endpoint() (string, string, error) {
+	endpoint := c.S3Endpoint
+	if endpoint == "" {
+		auth, err := c.authorization()
+		if err != nil {
+			return "", "", err
+		}
+		endpoint = auth.S3APIURL
+	}
+	if endpoint == "" {
+		return "", "", errors.New("no S3 endpoint has been configured")
+	}
+
+	region := c.S3Region
+	if region == "" {
+		region = s3Region(endpoint)
+	}
+	return strings.TrimSuffix(endpoint, "/"), region, nil
+}
+
+// Returns the region of an endpoint such as https://s3.us-west-004.backblazeb2.com
+func s3Region(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil {
+		parts := strings.Split(u.Hostname(), ".")
+		if len(parts) > 2 && parts[0] == "s3" {
+			return parts[1]
+		}
+	}
+	return defaultS3Region
+}
+
+// Sends a signed request to the S3-compatible API. Unsuccessful responses are
+// returned as a B2Error.
+func (c *B2) s3Send(r *s3Request) (*http.Response, error) {
+	endpoint, region, err := c.s3Endpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path += "/" + r.bucket
+	if r.key != "" {
+		u.Path += "/" + r.key
+	}
+	u.RawPath = s3Escape(u.Path, true)
+	u.RawQuery = s3CanonicalQuery(r.query)
+
+	req, err := http.NewRequest(r.method, u.String(), r.body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range r.header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if r.body != nil {
+		req.ContentLength = r.length
+		payloadHash = unsignedPayload
+	}
+	signS3Request(req, c.keyID(), c.ApplicationKey, region, c.now(), payloadHash)
+
+	if c.Debug {
+		log.Printf("s3Request: %s %s", r.method, req.URL)
+	}
+
+	resp, err := c.doRequest(req, RequestInfo{API: r.op, Bucket: r.bucketID})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if c.Debug {
+			log.Printf("Response: %s", body)
+		}
+		return nil, s3ResponseError(resp, body)
+	}
+	return resp, nil
+}
+
+// Makes a request without a body to the S3-compatible API, retrying once
+// after non-fatal errors. If result is not nil, the XML response is decoded
+// into it. Returns the response headers.
+func (c *B2) s3Call(r *s3Request, result interface{}) (http.Header, error) {
+	header, err := c.tryS3Call(r, result)
+
+	// Retry after non-fatal errors
+	var b2err *B2Error
+	if errors.As(err, &b2err) {
+		if !b2err.IsFatal() && !c.NoRetry {
+			if c.Debug {
+				log.Printf("Retrying request %q due to error: %v", r.op, err)
+			}
+
+			header, err = c.tryS3Call(r, result)
+		}
+	}
+	return header, requestError(err, r.op, r.key, r.query.Get("versionId"))
+}
+
+func (c *B2) tryS3Call(r *s3Request, result interface{}) (http.Header, error) {
+	resp, err := c.s3Send(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Debug {
+		log.Printf("Response: %s", body)
+	}
+
+	if result != nil {
+		if err := xml.Unmarshal(body, result); err != nil {
+			return nil, err
+		}
+	}
+	return resp.Header, nil
+}
+
+// Creates an error for an unsuccessful response from the S3-compatible API
+func s3ResponseError(resp *http.Response, body []byte) *B2Error {
+	b2err := &B2Error{
+		Code:    "UNKNOWN",
+		Message: "Unrecognised status code",
+		Status:  resp.StatusCode,
+	}
+
+	s3err := &s3Error{}
+	if xml.Unmarshal(body, s3err) == nil && s3err.Code != "" {
+		b2err.Code = s3err.Code
+		b2err.Message = s3err.Message
+		b2err.RequestID = s3err.RequestID
+	}
+	if b2err.RequestID == "" {
+		for _, header := range requestIDHeaders {
+			if id := resp.Header.Get(header); id != "" {
+				b2err.RequestID = id
+				break
+			}
+		}
+	}
+	return b2err
+}
+
+// Lists object versions using the S3-compatible API. The listing starts after
+// the given name and file ID, so NextFileName is the last name listed.
+func (b *Bucket) s3ListObjectVersions(startFileName, startFileID string, maxFileCount int, prefix, delimiter string) (*s3ListVersionsResult, error) {
+	if maxFileCount == 0 {
+		maxFileCount = 100
+	}
+
+	query := url.Values{
+		"versions": {""},
+		"max-keys": {strconv.Itoa(maxFileCount)},
+	}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if startFileName != "" {
+		query.Set("key-marker", startFileName)
+		if startFileID != "" {
+			query.Set("version-id-marker", startFileID)
+		}
+	}
+
+	result := &s3ListVersionsResult{}
+	_, err := b.b2.s3Call(&s3Request{
+		op:       "ListObjectVersions",
+		bucketID: b.ID,
+		method:   http.MethodGet,
+		bucket:   b.Name,
+		query:    query,
+	}, result)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Returns the files in a listing, in name order. If current is true, only
+// the current version of each file is returned.
+func (r *s3ListVersionsResult) files(bucketID string, current bool) []FileStatus {
+	files := []FileStatus{}
+	for _, v := range r.Entries {
+		var action FileAction
+		switch {
+		case v.XMLName.Local == "Version" && (v.IsLatest || !current):
+			action = Upload
+		case v.XMLName.Local == "DeleteMarker" && !current:
+			action = Hide
+		default:
+			continue
+		}
+
+		f := File{
+			ID:            v.VersionID,
+			Name:          v.Key,
+			BucketID:      bucketID,
+			ContentLength: v.Size,
+			Action:        action,
+		}
+		if t, err := time.Parse(time.RFC3339, v.LastModified); err == nil {
+			f.UploadTimestamp = toMillis(t)
+		}
+		files = append(files, FileStatus{f})
+	}
+	for _, p := range r.CommonPrefixes {
+		files = append(files, FileStatus{File{Name: p.Prefix, Action: Folder}})
+	}
+
+	// Folders are listed separately, but are sorted with the files
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files
+}
+
+func (b *Bucket) s3ListFileNames(startFileName string, maxFileCount int, prefix, delimiter string) (*ListFilesResponse, error) {
+	result, err := b.s3ListObjectVersions(startFileName, "", maxFileCount, prefix, delimiter)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ListFilesResponse{Files: result.files(b.ID, true)}
+	if result.IsTruncated {
+		response.NextFileName = result.NextKeyMarker
+	}
+	return response, nil
+}
+
+func (b *Bucket) s3ListFileVersions(startFileName, startFileID string, maxFileCount int, prefix, delimiter string) (*ListFileVersionsResponse, error) {
+	result, err := b.s3ListObjectVersions(startFileName, startFileID, maxFileCount, prefix, delimiter)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ListFileVersionsResponse{Files: result.files(b.ID, false)}
+	if result.IsTruncated {
+		response.NextFileName = result.NextKeyMarker
+		response.NextFileID = result.NextVersionIDMarker
+	}
+	return response, nil
+}
+
+// Uploads a file using the S3-compatible API. The SHA1 hash is not checked
+// by the service, but is returned in the File.
+func (b *Bucket) s3UploadFile(
+	name, contentType string, meta map[string]string, file io.Reader,
+	sha1Hash string, contentLength int64, tracker *progressTracker) (*File, error) {
+
+	// The content type is chosen by the client, as b2/x-auto is not supported
+	if contentType == "" || contentType == "b2/x-auto" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	info := make(map[string]string, len(meta))
+	for k, v := range meta {
+		header.Set("X-Amz-Meta-"+k, v)
+		info[strings.ToLower(k)] = v
+	}
+
+	resp, err := b.b2.s3Send(&s3Request{
+		op:       "PutObject",
+		bucketID: b.ID,
+		method:   http.MethodPut,
+		bucket:   b.Name,
+		key:      name,
+		header:   header,
+		body:     trackReader(file, tracker),
+		length:   contentLength,
+	})
+	if err != nil {
+		return nil, requestError(err, "PutObject", name, "")
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	tracker.partDone()
+	return &File{
+		ID:              resp.Header.Get("X-Amz-Version-Id"),
+		Name:            name,
+		BucketID:        b.ID,
+		ContentLength:   contentLength,
+		ContentSha1:     sha1Hash,
+		ContentType:     contentType,
+		FileInfo:        info,
+		Action:          Upload,
+		UploadTimestamp: headerMillis(resp.Header, "Date"),
+	}, nil
+}
+
+func (b *Bucket) s3DownloadFile(fileName, rangeHeader string) (*File, io.ReadCloser, error) {
+	header := http.Header{}
+	if rangeHeader != "" {
+		header.Set("Range", rangeHeader)
+	}
+
+	resp, err := b.b2.s3Send(&s3Request{
+		op:       "GetObject",
+		bucketID: b.ID,
+		method:   http.MethodGet,
+		bucket:   b.Name,
+		key:      fileName,
+		header:   header,
+	})
+	if err != nil {
+		return nil, nil, requestError(err, "GetObject", fileName, "")
+	}
+
+	file := &File{
+		ID:              resp.Header.Get("X-Amz-Version-Id"),
+		Name:            fileName,
+		BucketID:        b.ID,
+		ContentSha1:     resp.Header.Get("X-Bz-Content-Sha1"),
+		ContentType:     resp.Header.Get("Content-Type"),
+		FileInfo:        make(map[string]string),
+		Action:          Upload,
+		UploadTimestamp: headerMillis(resp.Header, "Last-Modified"),
+	}
+	if err := setContentLength(file, resp); err != nil {
+		resp.Body.Close()
+		return nil, nil, requestError(err, "GetObject", fileName, "")
+	}
+
+	for k, v := range resp.Header {
+		if strings.HasPrefix(k, "X-Amz-Meta-") {
+			file.FileInfo[strings.ToLower(k[len("X-Amz-Meta-"):])] = v[0]
+		}
+	}
+	return file, resp.Body, nil
+}
+
+func (b *Bucket) s3DeleteFileVersion(fileName, fileID string) (*FileStatus, error) {
+	_, err := b.b2.s3Call(&s3Request{
+		op:       "DeleteObject",
+		bucketID: b.ID,
+		method:   http.MethodDelete,
+		bucket:   b.Name,
+		key:      fileName,
+		query:    url.Values{"versionId": {fileID}},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStatus{File{ID: fileID, Name: fileName}}, nil
+}
+
+// Hides a file by deleting it without a version ID, which adds a delete marker
+func (b *Bucket) s3HideFile(fileName string) (*FileStatus, error) {
+	header, err := b.b2.s3Call(&s3Request{
+		op:       "DeleteObject",
+		bucketID: b.ID,
+		method:   http.MethodDelete,
+		bucket:   b.Name,
+		key:      fileName,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileStatus{File{
+		ID:              header.Get("X-Amz-Version-Id"),
+		Name:            fileName,
+		BucketID:        b.ID,
+		Action:          Hide,
+		UploadTimestamp: headerMillis(header, "Date"),
+	}}, nil
+}
+
+// Copies a file using the S3-compatible API. The name and bucket of the
+// source file are found using the native API, as S3 objects are addressed
+// by name.
+func (b *Bucket) s3CopyFile(fileID, fileName, destinationBucketID string, metadataDirective FileMetadataDirective) (*File, error) {
+	source, err := b.GetFileInfo(fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceBucket, err := b.s3BucketName(source.BucketID)
+	if err != nil {
+		return nil, err
+	}
+	if destinationBucketID == "" {
+		destinationBucketID = source.BucketID
+	}
+	destinationBucket, err := b.s3BucketName(destinationBucketID)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadataDirective == "" {
+		metadataDirective = FileMetaDirectiveCopy
+	}
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", "/"+sourceBucket+"/"+s3Escape(source.Name, true)+"?versionId="+url.QueryEscape(fileID))
+	header.Set("X-Amz-Metadata-Directive", string(metadataDirective))
+
+	result := &s3CopyResult{}
+	responseHeader, err := b.b2.s3Call(&s3Request{
+		op:       "CopyObject",
+		bucketID: destinationBucketID,
+		method:   http.MethodPut,
+		bucket:   destinationBucket,
+		key:      fileName,
+		header:   header,
+	}, result)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{
+		ID:            responseHeader.Get("X-Amz-Version-Id"),
+		Name:          fileName,
+		AccountID:     source.AccountID,
+		BucketID:      destinationBucketID,
+		ContentLength: source.ContentLength,
+		ContentSha1:   source.ContentSha1,
+		FileInfo:      map[string]string{},
+		Action:        Upload,
+	}
+	if metadataDirective == FileMetaDirectiveCopy {
+		f.ContentType = source.ContentType
+		f.FileInfo = source.FileInfo
+	}
+	if t, err := time.Parse(time.RFC3339, result.LastModified); err == nil {
+		f.UploadTimestamp = toMillis(t)
+	}
+	return f, nil
+}
+
+// Returns the name of a bucket given its ID
+func (b *Bucket) s3BucketName(bucketID string) (string, error) {
+	if bucketID == b.ID {
+		return b.Name, nil
+	}
+	info, err := b.b2.bucketInfo(bucketID)
+	if err != nil {
+		return "", err
+	}
+	return info.Name, nil
+}
+
+// Returns the time in a response header in milliseconds since the epoch, or
+// zero if it is missing
+func headerMillis(header http.Header, name string) int64 {
+	t, err := http.ParseTime(header.Get(name))
+	if err != nil {
+		return 0
+	}
+	return toMillis(t)
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
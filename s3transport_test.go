@@ -0,0 +1,174 @@
+package backblaze_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"gopkg.in/kothar/go-backblaze.v0"
+	"gopkg.in/kothar/go-backblaze.v0/b2test"
+)
+
+func TestS3Protocol(T *testing.T) {
+	server := b2test.NewServer()
+	defer server.Close()
+	client := server.NewClient()
+	client.Protocol = backblaze.ProtocolS3
+	download := b2test.ReadDownload(T)
+	bucket := b2test.CreateBucket(T, client, "s3-bucket")
+
+	// Upload two versions of a file, and some files in a folder
+	meta := map[string]string{"src_last_modified_millis": "1234"}
+	first, err := bucket.UploadFile("a.txt", meta, bytes.NewReader([]byte("first version")))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if first.ID == "" || first.ContentType != "text/plain; charset=utf-8" {
+		T.Errorf("Unexpected upload response %+v", first)
+	}
+	second, err := bucket.UploadFile("a.txt", meta, bytes.NewReader([]byte("second version")))
+	if err != nil {
+		T.Fatal(err)
+	}
+	for _, name := range []string{"dir/b", "dir/c", "dir/sub/d"} {
+		if _, err := bucket.UploadFile(name, nil, bytes.NewReader([]byte(name))); err != nil {
+			T.Fatal(err)
+		}
+	}
+
+	// Uploads are visible to the native API
+	info, err := bucket.GetFileInfo(second.ID)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if info.Name != "a.txt" || info.FileInfo["src_last_modified_millis"] != "1234" {
+		T.Errorf("Unexpected file info %+v", info)
+	}
+
+	// Download by name with a range
+	file, data := download(bucket.DownloadFileRangeByName("a.txt", &backblaze.FileRange{Start: 7, End: 13}))
+	if string(data) != "version" {
+		T.Errorf("Expected range of second version, saw %q", data)
+	}
+	if file.ID != second.ID || file.FileInfo["src_last_modified_millis"] != "1234" {
+		T.Errorf("Unexpected download %+v", file)
+	}
+	if _, _, err := bucket.DownloadFileByName("missing"); !errors.Is(err, backblaze.ErrNotFound) {
+		T.Errorf("Expected missing file to be not found, saw %v", err)
+	}
+	if _, data := download(bucket.ReadaheadFileByName("a.txt")); string(data) != "second version" {
+		T.Errorf("Expected readahead of second version, saw %q", data)
+	}
+
+	// List names with a delimiter, one entry per page
+	var names []string
+	start := ""
+	for {
+		response, err := bucket.ListFileNamesWithPrefix(start, 1, "", "/")
+		if err != nil {
+			T.Fatal(err)
+		}
+		for _, f := range response.Files {
+			names = append(names, f.Name)
+		}
+		if response.NextFileName == "" {
+			break
+		}
+		start = response.NextFileName
+	}
+	if len(names) != 2 || names[0] != "a.txt" || names[1] != "dir/" {
+		T.Errorf("Unexpected folder listing: %v", names)
+	}
+
+	// Hide the file, leaving its versions
+	hidden, err := bucket.HideFile("a.txt")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if _, _, err := bucket.DownloadFileByName("a.txt"); !errors.Is(err, backblaze.ErrNotFound) {
+		T.Errorf("Expected hidden file to be not found, saw %v", err)
+	}
+	response, err := bucket.ListFileNamesWithPrefix("", 100, "a", "")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if len(response.Files) != 0 {
+		T.Errorf("Expected hidden file not to be listed: %+v", response.Files)
+	}
+
+	// Page through versions
+	var versions []backblaze.FileStatus
+	startName, startID := "", ""
+	for {
+		page, err := bucket.ListFileVersionsWithPrefix(startName, startID, 2, "a.txt", "")
+		if err != nil {
+			T.Fatal(err)
+		}
+		versions = append(versions, page.Files...)
+		if page.NextFileName == "" {
+			break
+		}
+		startName, startID = page.NextFileName, page.NextFileID
+	}
+	if len(versions) != 3 || versions[0].ID != hidden.ID || versions[0].Action != backblaze.Hide ||
+		versions[1].ID != second.ID || versions[2].ID != first.ID {
+		T.Errorf("Unexpected versions: %+v", versions)
+	}
+
+	// Copy an old version, keeping its metadata
+	copied, err := bucket.CopyFile(first.ID, "copy.txt", "", backblaze.FileMetaDirectiveCopy)
+	if err != nil {
+		T.Fatal(err)
+	}
+	if copied.FileInfo["src_last_modified_millis"] != "1234" {
+		T.Errorf("File info was not copied: %v", copied.FileInfo)
+	}
+	file, data = download(bucket.DownloadFileByName("copy.txt"))
+	if string(data) != "first version" || file.ID != copied.ID {
+		T.Errorf("Expected copy of first version, saw %q", data)
+	}
+
+	// Delete all versions, so the bucket can be deleted
+	all, err := bucket.ListFileVersions("", "", 100)
+	if err != nil {
+		T.Fatal(err)
+	}
+	for _, f := range all.Files {
+		if _, err := bucket.DeleteFileVersion(f.Name, f.ID); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if err := bucket.Delete(); err != nil {
+		T.Fatal(err)
+	}
+
+	usage := client.Usage()
+	if usage.APIs["PutObject"].Calls != 5 || usage.APIs["GetObject"].Calls != 4 {
+		T.Errorf("Unexpected usage of S3 operations: %+v", usage.APIs)
+	}
+}
+
+func TestS3ProtocolErrors(T *testing.T) {
+	server := b2test.NewServer()
+	defer server.Close()
+	client := server.NewClient()
+	client.Protocol = backblaze.ProtocolS3
+	bucket := b2test.CreateBucket(T, client, "s3-bucket")
+
+	// Busy servers are retried
+	server.InjectFault(b2test.Fault{Kind: b2test.FaultServiceUnavailable, APIs: []string{"ListObjectVersions"}, Count: 1})
+	if _, err := bucket.ListFileNames("", 10); err != nil {
+		T.Errorf("Expected list to succeed after a retry, saw %v", err)
+	}
+	server.InjectFault(b2test.Fault{Kind: b2test.FaultServiceUnavailable, APIs: []string{"ListObjectVersions"}, Count: 2})
+	if _, err := bucket.ListFileNames("", 10); !errors.Is(err, backblaze.ErrServiceUnavailable) {
+		T.Errorf("Expected list to fail after repeated errors, saw %v", err)
+	}
+
+	// Requests signed with the wrong key ID are rejected
+	client.KeyID = "wrong"
+	var b2err *backblaze.B2Error
+	if _, err := bucket.ListFileNames("", 10); !errors.As(err, &b2err) || b2err.Code != "InvalidAccessKeyId" || !errors.Is(err, backblaze.ErrUnauthorized) {
+		T.Errorf("Expected an invalid key error, saw %v", err)
+	}
+}
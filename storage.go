@@ -0,0 +1,190 @@
+package backblaze
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Storage is a store of named files, providing the operations common to most
+// object storage services. It is implemented by *Bucket, and by the in-memory
+// and local filesystem stores in the b2storage package, so that code written
+// against it can be tested or run without the network.
+//
+// File names are separated into folders by slashes. Errors for files which
+// don't exist match ErrNotFound using errors.Is.
+type Storage interface {
+	// Put stores a file, replacing any existing file with the same name. If
+	// contentType is empty or b2/x-auto, it is chosen from the name's extension.
+	Put(ctx context.Context, name, contentType string, meta map[string]string, content io.Reader) (*File, error)
+
+	// Get returns a file and its content, which must be closed
+	Get(ctx context.Context, name string) (*File, io.ReadCloser, error)
+
+	// GetRange returns a file and part of its content, which must be closed.
+	// The ContentLength of the file is the length of the range returned.
+	GetRange(ctx context.Context, name string, fileRange *FileRange) (*File, io.ReadCloser, error)
+
+	// Stat returns a file without its content
+	Stat(ctx context.Context, name string) (*File, error)
+
+	// List calls fn for each file with the given prefix, in name order. If a
+	// delimiter is given, each folder below the prefix is listed once with
+	// the Folder action instead of the files it contains. An error returned
+	// by fn stops the listing, and is returned by List.
+	List(ctx context.Context, prefix, delimiter string, fn func(file *File) error) error
+
+	// Remove deletes a file, and any earlier versions of it. An error
+	// matching ErrNotFound is returned if nothing was deleted. A Bucket also
+	// deletes the versions of a hidden file, returning nil.
+	//
+	// The method is named Remove rather than Delete because Bucket already
+	// has a Delete method, which deletes the bucket itself.
+	Remove(ctx context.Context, name string) error
+
+	// Copy copies a file to a new name, keeping its content type and file info
+	Copy(ctx context.Context, source, destination string) (*File, error)
+}
+
+// The B2 client does not accept a context, so the Storage methods of Bucket
+// check for cancellation before each request and while reading downloads.
+
+// Put uploads a file using UploadTypedFile
+func (b *Bucket) Put(ctx context.Context, name, contentType string, meta map[string]string, content io.Reader) (*File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = "b2/x-auto"
+	}
+	return b.UploadTypedFile(name, contentType, meta, content)
+}
+
+// Get downloads a file using DownloadFileByName
+func (b *Bucket) Get(ctx context.Context, name string) (*File, io.ReadCloser, error) {
+	return b.GetRange(ctx, name, nil)
+}
+
+// GetRange downloads part of a file using DownloadFileRangeByName
+func (b *Bucket) GetRange(ctx context.Context, name string, fileRange *FileRange) (*File, io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	file, reader, err := b.DownloadFileRangeByName(name, fileRange)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, &contextReader{ctx, reader}, nil
+}
+
+// Stat finds the current version of a file by listing its name. When using
+// ProtocolS3, the file info is then fetched using GetFileInfo.
+func (b *Bucket) Stat(ctx context.Context, name string) (*File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	file, err := b.findFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if b.b2.Protocol == ProtocolS3 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return b.GetFileInfo(file.ID)
+	}
+	return file, nil
+}
+
+// List lists the current versions of files using ListFileNamesWithPrefix.
+// When using ProtocolS3, files are listed without their content type, SHA1
+// hash or file info.
+func (b *Bucket) List(ctx context.Context, prefix, delimiter string, fn func(file *File) error) error {
+	start := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		response, err := b.ListFileNamesWithPrefix(start, 1000, prefix, delimiter)
+		if err != nil {
+			return err
+		}
+		for i := range response.Files {
+			if err := fn(&response.Files[i].File); err != nil {
+				return err
+			}
+		}
+		if response.NextFileName == "" {
+			return nil
+		}
+		start = response.NextFileName
+	}
+}
+
+// Remove deletes every version of a file, including hide markers. An error
+// matching ErrNotFound is returned if no versions of the file were found.
+func (b *Bucket) Remove(ctx context.Context, name string) error {
+	found := false
+	startName, startID := "", ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		response, err := b.ListFileVersionsWithPrefix(startName, startID, 1000, name, "")
+		if err != nil {
+			return err
+		}
+		for _, f := range response.Files {
+			// Versions are listed newest first, and before any longer names
+			// with the same prefix
+			if f.Name != name {
+				response.NextFileName = ""
+				break
+			}
+			found = true
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, err := b.DeleteFileVersion(f.Name, f.ID); err != nil {
+				return err
+			}
+		}
+		if response.NextFileName == "" {
+			break
+		}
+		startName, startID = response.NextFileName, response.NextFileID
+	}
+
+	if !found {
+		return fmt.Errorf("Unable to find file %s in bucket %s: %w", name, b.Name, ErrNotFound)
+	}
+	return nil
+}
+
+// Copy copies the current version of a file using CopyFile
+func (b *Bucket) Copy(ctx context.Context, source, destination string) (*File, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	file, err := b.findFile(source)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return b.CopyFile(file.ID, destination, "", FileMetaDirectiveCopy)
+}
+
+// Fails reads once a context is done
+type contextReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
+}
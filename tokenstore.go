@@ -0,0 +1,218 @@
+package backblaze
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pquerna/ffjson/ffjson"
+)
+
+// StoredAuthorization is an account authorization saved by a TokenStore
+type StoredAuthorization struct {
+	AccountID               string    `json:"accountId"`
+	APIEndpoint             string    `json:"apiUrl"`
+	AuthorizationToken      string    `json:"authorizationToken"`
+	DownloadURL             string    `json:"downloadUrl"`
+	RecommendedPartSize     int64     `json:"recommendedPartSize"`
+	AbsoluteMinimumPartSize int64     `json:"absoluteMinimumPartSize"`
+	Issued                  time.Time `json:"issued"`
+}
+
+// TokenStore saves account authorizations so that they can be reused by
+// other clients and processes until they expire, avoiding a call to
+// b2_authorize_account.
+//
+// Keys identify the credentials and API host used to obtain the
+// authorization. They are safe to use as file names and do not contain the
+// application key. Stored authorizations contain a valid authorization token
+// and should be kept private.
+type TokenStore interface {
+	// Load returns the authorization saved for a key, or nil if there is none
+	Load(key string) (*StoredAuthorization, error)
+
+	// Save stores an authorization, replacing any existing authorization for the key
+	Save(key string, auth *StoredAuthorization) error
+
+	// Delete removes the authorization saved for a key, if any
+	Delete(key string) error
+}
+
+// NewB2WithTokenStore creates a new Client for accessing the B2 API, which
+// saves its authorization in the given TokenStore.
+//
+// If the store holds an authorization for the credentials which has not
+// expired it will be used, otherwise the account will be authorized immediately.
+func NewB2WithTokenStore(creds Credentials, store TokenStore) (*B2, error) {
+	c := &B2{
+		Credentials:    creds,
+		MaxIdleUploads: 1,
+		TokenStore:     store,
+	}
+
+	auth, err := c.authorization()
+	if err != nil {
+		return nil, err
+	}
+
+	c.setAccountID(auth)
+	return c, nil
+}
+
+// Returns the key used to store authorizations for this client's credentials
+func (c *B2) tokenKey(host string) string {
+	hash := sha256.New()
+	hash.Write([]byte(host + "\x00" + c.keyID() + "\x00" + c.ApplicationKey))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Loads a stored authorization, if there is one which will not expire within
+// the refresh margin
+func (c *B2) loadAuthorization(host string) *authorizationState {
+	if c.TokenStore == nil {
+		return nil
+	}
+
+	stored, err := c.TokenStore.Load(c.tokenKey(host))
+	if err != nil {
+		if c.Debug {
+			log.Println("Unable to load stored authorization:", err)
+		}
+		return nil
+	}
+	if stored == nil {
+		return nil
+	}
+
+	auth := &authorizationState{
+		authorizeAccountResponse: &authorizeAccountResponse{
+			AccountID:               stored.AccountID,
+			APIEndpoint:             stored.APIEndpoint,
+			AuthorizationToken:      stored.AuthorizationToken,
+			DownloadURL:             stored.DownloadURL,
+			RecommendedPartSize:     stored.RecommendedPartSize,
+			AbsoluteMinimumPartSize: stored.AbsoluteMinimumPartSize,
+		},
+		issued: stored.Issued,
+		valid:  true,
+	}
+
+	if !c.now().Before(auth.expires().Add(-c.refreshMargin())) {
+		return nil
+	}
+	return auth
+}
+
+// Saves an authorization to the token store, if one is configured
+func (c *B2) saveAuthorization(host string, auth *authorizationState) {
+	if c.TokenStore == nil {
+		return
+	}
+
+	err := c.TokenStore.Save(c.tokenKey(host), &StoredAuthorization{
+		AccountID:               auth.AccountID,
+		APIEndpoint:             auth.APIEndpoint,
+		AuthorizationToken:      auth.AuthorizationToken,
+		DownloadURL:             auth.DownloadURL,
+		RecommendedPartSize:     auth.RecommendedPartSize,
+		AbsoluteMinimumPartSize: auth.AbsoluteMinimumPartSize,
+		Issued:                  auth.issued,
+	})
+	if err != nil && c.Debug {
+		log.Println("Unable to save authorization:", err)
+	}
+}
+
+// Removes a rejected authorization from the token store, if one is configured
+func (c *B2) deleteAuthorization(host string) {
+	if c.TokenStore == nil {
+		return
+	}
+
+	if err := c.TokenStore.Delete(c.tokenKey(host)); err != nil && c.Debug {
+		log.Println("Unable to delete stored authorization:", err)
+	}
+}
+
+// FileTokenStore is a TokenStore which saves each authorization as a file
+// in a directory. Files are only readable by the current user.
+type FileTokenStore struct {
+	Dir string
+}
+
+// NewFileTokenStore returns a FileTokenStore which saves authorizations in
+// the user's cache directory
+func NewFileTokenStore() (*FileTokenStore, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &FileTokenStore{
+		Dir: filepath.Join(dir, "go-backblaze", "tokens"),
+	}, nil
+}
+
+func (s *FileTokenStore) path(key string) string {
+	return filepath.Join(s.Dir, key+".json")
+}
+
+// Load reads the authorization saved for a key
+func (s *FileTokenStore) Load(key string) (*StoredAuthorization, error) {
+	data, err := ioutil.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &StoredAuthorization{}
+	if err := ffjson.Unmarshal(data, auth); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// Save writes an authorization to a file, replacing any existing file for the key
+func (s *FileTokenStore) Save(key string, auth *StoredAuthorization) error {
+	data, err := ffjson.Marshal(auth)
+	if err != nil {
+		return err
+	}
+	defer ffjson.Pool(data)
+
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so that other processes never
+	// see a partially written file. TempFile creates files with mode 0600.
+	f, err := ioutil.TempFile(s.Dir, key+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Delete removes the file for a key, if it exists
+func (s *FileTokenStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
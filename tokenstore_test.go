@@ -0,0 +1,164 @@
+package backblaze
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileTokenStore(T *testing.T) {
+	dir, err := ioutil.TempDir("", "b2tokens")
+	if err != nil {
+		T.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &FileTokenStore{Dir: filepath.Join(dir, "tokens")}
+
+	if auth, err := store.Load("key"); err != nil || auth != nil {
+		T.Fatalf("Expected no stored authorization, saw %v, %v", auth, err)
+	}
+
+	saved := &StoredAuthorization{
+		AccountID:          "account",
+		APIEndpoint:        "http://api.url",
+		AuthorizationToken: "token",
+		DownloadURL:        "http://download.url",
+		Issued:             time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := store.Save("key", saved); err != nil {
+		T.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(store.Dir, "key.json"))
+	if err != nil {
+		T.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		T.Errorf("Expected token file to have mode 0600, saw %o", mode)
+	}
+
+	loaded, err := store.Load("key")
+	if err != nil {
+		T.Fatal(err)
+	}
+	if loaded == nil || loaded.AuthorizationToken != saved.AuthorizationToken || !loaded.Issued.Equal(saved.Issued) {
+		T.Errorf("Expected to load %+v, saw %+v", saved, loaded)
+	}
+
+	if err := store.Delete("key"); err != nil {
+		T.Fatal(err)
+	}
+	if auth, err := store.Load("key"); err != nil || auth != nil {
+		T.Fatalf("Expected authorization to be deleted, saw %v, %v", auth, err)
+	}
+	if err := store.Delete("key"); err != nil {
+		T.Errorf("Expected deleting a missing authorization to succeed, saw %v", err)
+	}
+}
+
+// A TokenStore which keeps authorizations in memory
+type memoryTokenStore map[string]*StoredAuthorization
+
+func (s memoryTokenStore) Load(key string) (*StoredAuthorization, error) {
+	return s[key], nil
+}
+
+func (s memoryTokenStore) Save(key string, auth *StoredAuthorization) error {
+	s[key] = auth
+	return nil
+}
+
+func (s memoryTokenStore) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func TestTokenStore(T *testing.T) {
+
+	accountID := "test"
+	var authCount int32
+
+	server := authTestServer(accountID, &authCount, "stored")
+	defer server.Close()
+
+	creds := Credentials{
+		AccountID:      accountID,
+		ApplicationKey: "test",
+	}
+	store := memoryTokenStore{}
+
+	// A client with an empty store authorizes the account and saves the token
+	first := &B2{Credentials: creds, TokenStore: store, host: server.URL}
+	if _, err := first.ListBuckets(); err != nil {
+		T.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&authCount); n != 1 {
+		T.Fatalf("Expected one authorization, saw %d", n)
+	}
+	key := first.tokenKey(server.URL)
+	if store[key] == nil || store[key].AuthorizationToken != "token1" {
+		T.Fatalf("Expected token to be saved, saw %+v", store[key])
+	}
+
+	// A second client reuses the saved token
+	var reasons []AuthorizationReason
+	second := &B2{
+		Credentials: creds,
+		TokenStore:  store,
+		host:        server.URL,
+		OnAuthorization: func(event AuthorizationEvent) {
+			reasons = append(reasons, event.Reason)
+		},
+	}
+	if _, err := second.ListBuckets(); err != nil {
+		T.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&authCount); n != 1 {
+		T.Errorf("Expected stored token to be reused, saw %d authorizations", n)
+	}
+
+	// A rejected token is replaced
+	store[key].AuthorizationToken = "stored"
+	third := &B2{
+		Credentials: creds,
+		TokenStore:  store,
+		host:        server.URL,
+		OnAuthorization: func(event AuthorizationEvent) {
+			reasons = append(reasons, event.Reason)
+		},
+	}
+	if _, err := third.ListBuckets(); err != nil {
+		T.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&authCount); n != 2 {
+		T.Errorf("Expected rejected token to be replaced, saw %d authorizations", n)
+	}
+	if store[key].AuthorizationToken != "token2" {
+		T.Errorf("Expected new token to be saved, saw %q", store[key].AuthorizationToken)
+	}
+
+	// An expired token is not used
+	store[key].Issued = time.Now().Add(-tokenLifetime)
+	fourth := &B2{Credentials: creds, TokenStore: store, host: server.URL}
+	if _, err := fourth.ListBuckets(); err != nil {
+		T.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&authCount); n != 3 {
+		T.Errorf("Expected expired token to be replaced, saw %d authorizations", n)
+	}
+
+	expected := []AuthorizationReason{AuthorizationStored, AuthorizationStored, AuthorizationInvalidated}
+	if len(reasons) != len(expected) {
+		T.Fatalf("Expected authorizations %v, saw %v", expected, reasons)
+	}
+	for i := range expected {
+		if reasons[i] != expected[i] {
+			T.Errorf("Expected authorizations %v, saw %v", expected, reasons)
+			break
+		}
+	}
+}
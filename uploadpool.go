@@ -0,0 +1,93 @@
+package backblaze
+
+import (
+	"time"
+)
+
+// Upload URLs returned by b2_get_upload_url are valid for 24 hours
+const uploadTokenLifetime = 24 * time.Hour
+
+// UploadPoolStats describes how the client has obtained upload URLs
+type UploadPoolStats struct {
+	Hits    int64 // Upload URLs reused from the pool
+	Misses  int64 // Upload URLs requested because none were available
+	Expired int64 // Pooled upload URLs discarded because their token was close to expiry
+	Evicted int64 // Upload URLs discarded because an upload using them failed
+	Idle    int   // Upload URLs currently available for reuse
+}
+
+// A pool of upload URLs for each bucket, shared by all Bucket instances
+// created by a client. Guarded by the client's mutex.
+type uploadPool struct {
+	idle  map[string][]*UploadAuth
+	stats UploadPoolStats
+}
+
+// UploadPoolStats returns statistics about the reuse of upload URLs
+func (c *B2) UploadPoolStats() UploadPoolStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.uploads.stats
+}
+
+// Takes an unexpired upload URL for a bucket from the pool, if one is available
+func (c *B2) getPooledUploadAuth(bucketID string) *UploadAuth {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pool := &c.uploads
+	deadline := c.now().Add(c.refreshMargin())
+	idle := pool.idle[bucketID]
+	for len(idle) > 0 {
+		// Prefer the most recently used URL
+		auth := idle[len(idle)-1]
+		idle = idle[:len(idle)-1]
+		pool.stats.Idle--
+
+		if deadline.Before(auth.issued.Add(uploadTokenLifetime)) {
+			pool.idle[bucketID] = idle
+			pool.stats.Hits++
+			return auth
+		}
+		pool.stats.Expired++
+	}
+
+	delete(pool.idle, bucketID)
+	pool.stats.Misses++
+	return nil
+}
+
+// Returns an upload URL to the pool if it is valid and the pool for the
+// bucket is not full
+func (c *B2) returnUploadAuth(bucketID string, auth *UploadAuth) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pool := &c.uploads
+	if !auth.Valid {
+		pool.stats.Evicted++
+		return
+	}
+
+	idle := pool.idle[bucketID]
+	if len(idle) >= c.MaxIdleUploads {
+		return
+	}
+
+	if pool.idle == nil {
+		pool.idle = make(map[string][]*UploadAuth)
+	}
+	pool.idle[bucketID] = append(idle, auth)
+	pool.stats.Idle++
+}
+
+// Discards the upload URLs for a bucket
+func (c *B2) clearUploadAuths(bucketID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pool := &c.uploads
+	pool.stats.Idle -= len(pool.idle[bucketID])
+	delete(pool.idle, bucketID)
+}
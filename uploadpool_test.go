@@ -0,0 +1,117 @@
+package backblaze
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUploadPool(T *testing.T) {
+
+	accountID := "test"
+	var uploadURLCount int32
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case v1 + "b2_authorize_account":
+			fmt.Fprint(w, toJSON(authorizeAccountResponse{
+				AccountID:          accountID,
+				APIEndpoint:        server.URL,
+				AuthorizationToken: "token",
+				DownloadURL:        server.URL,
+			}))
+		case v1 + "b2_get_upload_url":
+			n := atomic.AddInt32(&uploadURLCount, 1)
+			fmt.Fprint(w, toJSON(getUploadURLResponse{
+				BucketID:           "bucketid",
+				UploadURL:          server.URL + "/upload",
+				AuthorizationToken: "upload" + strconv.Itoa(int(n)),
+			}))
+		case "/upload":
+			if r.Header.Get("X-Bz-File-Name") == "fail.txt" {
+				w.WriteHeader(503)
+				fmt.Fprint(w, toJSON(B2Error{
+					Status:  503,
+					Code:    "service_unavailable",
+					Message: "Service unavailable",
+				}))
+				return
+			}
+			fmt.Fprint(w, toJSON(File{
+				ID:          "fileId",
+				Name:        r.Header.Get("X-Bz-File-Name"),
+				ContentSha1: r.Header.Get("X-Bz-Content-Sha1"),
+			}))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+
+	clock := &testClock{t: time.Now()}
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      accountID,
+			ApplicationKey: "test",
+		},
+		Debug:          testing.Verbose(),
+		MaxIdleUploads: 1,
+		host:           server.URL,
+		clock:          clock.now,
+	}
+
+	// Each upload uses a new Bucket instance, as returned by B2.Bucket
+	upload := func(name string) error {
+		bucket := &Bucket{
+			BucketInfo: &BucketInfo{ID: "bucketid", Name: "testbucket"},
+			b2:         b2,
+		}
+		contents := []byte("File contents")
+		hash := sha1.Sum(contents)
+		_, err := bucket.UploadHashedFile(name, nil, bytes.NewReader(contents), hex.EncodeToString(hash[:]), int64(len(contents)))
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := upload("test.txt"); err != nil {
+			T.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&uploadURLCount); n != 1 {
+		T.Errorf("Expected upload URL to be shared by buckets, saw %d requests", n)
+	}
+
+	// A failed upload discards its URL
+	if err := upload("fail.txt"); err == nil {
+		T.Fatal("Expected upload to fail")
+	}
+	if err := upload("test.txt"); err != nil {
+		T.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&uploadURLCount); n != 2 {
+		T.Errorf("Expected failed upload URL to be replaced, saw %d requests", n)
+	}
+
+	// Expired URLs are discarded
+	clock.advance(uploadTokenLifetime)
+	if err := upload("test.txt"); err != nil {
+		T.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&uploadURLCount); n != 3 {
+		T.Errorf("Expected expired upload URL to be replaced, saw %d requests", n)
+	}
+
+	expected := UploadPoolStats{Hits: 3, Misses: 3, Expired: 1, Evicted: 1, Idle: 1}
+	if stats := b2.UploadPoolStats(); stats != expected {
+		T.Errorf("Expected stats %+v, saw %+v", expected, stats)
+	}
+}
@@ -0,0 +1,186 @@
+package backblaze
+
+import (
+	"sync"
+	"time"
+)
+
+// TransactionClass is the billing class of a B2 API call
+type TransactionClass string
+
+// B2 bills API calls in three classes. Class A transactions are free, while
+// class C transactions are the most expensive.
+const (
+	ClassA TransactionClass = "A"
+	ClassB TransactionClass = "B"
+	ClassC TransactionClass = "C"
+)
+
+var transactionClasses = map[string]TransactionClass{
+	"b2_cancel_large_file":     ClassA,
+	"b2_delete_bucket":         ClassA,
+	"b2_delete_file_version":   ClassA,
+	"b2_delete_key":            ClassA,
+	"b2_finish_large_file":     ClassA,
+	"b2_get_upload_part_url":   ClassA,
+	"b2_get_upload_url":        ClassA,
+	"b2_hide_file":             ClassA,
+	"b2_start_large_file":      ClassA,
+	"b2_upload_file":           ClassA,
+	"b2_upload_part":           ClassA,
+	"b2_download_file_by_id":   ClassB,
+	"b2_download_file_by_name": ClassB,
+	"b2_get_file_info":         ClassB,
+
+	// Operations of the S3-compatible API
+	"DeleteObject": ClassA,
+	"PutObject":    ClassA,
+	"GetObject":    ClassB,
+}
+
+// APIClass returns the billing class of the named B2 API or S3 operation. Unrecognised APIs
+// are assumed to be class C.
+func APIClass(api string) TransactionClass {
+	if class, ok := transactionClasses[api]; ok {
+		return class
+	}
+	return ClassC
+}
+
+// APIUsage counts the calls made to a single B2 API
+type APIUsage struct {
+	Calls         int64
+	Transactions  int64
+	BytesSent     int64
+	BytesReceived int64
+}
+
+// Usage summarises the transactions made by a client and the data
+// transferred, to allow the cost of a set of operations to be estimated.
+//
+// Requests are counted once a response has been received, whether or not
+// the call was successful. Requests which fail to connect are not counted.
+type Usage struct {
+	// The time counting started, when the client was first used or Usage was reset
+	Since time.Time
+
+	// Number of transactions in each billing class
+	ClassA int64
+	ClassB int64
+	ClassC int64
+
+	// Total bytes sent and received in request and response bodies
+	BytesSent     int64
+	BytesReceived int64
+
+	// Usage broken down by API name
+	APIs map[string]APIUsage
+}
+
+// Transactions returns the number of transactions counted in the given class
+func (u Usage) Transactions(class TransactionClass) int64 {
+	switch class {
+	case ClassA:
+		return u.ClassA
+	case ClassB:
+		return u.ClassB
+	default:
+		return u.ClassC
+	}
+}
+
+// Usage returns the transactions made by the client since it was created or
+// the usage was last reset.
+func (c *B2) Usage() Usage {
+	return c.usage.get(false)
+}
+
+// ResetUsage starts counting usage again from zero, returning the usage up to this point.
+func (c *B2) ResetUsage() Usage {
+	return c.usage.get(true)
+}
+
+type usageCounter struct {
+	sync.Mutex
+	usage Usage
+}
+
+func (u *usageCounter) record(info *RequestInfo) {
+	if info.Status == 0 {
+		return
+	}
+
+	u.Lock()
+	defer u.Unlock()
+
+	if u.usage.APIs == nil {
+		u.usage.Since = info.Start
+		u.usage.APIs = make(map[string]APIUsage)
+	}
+
+	transactions := int64(info.Transactions)
+	switch APIClass(info.API) {
+	case ClassA:
+		u.usage.ClassA += transactions
+	case ClassB:
+		u.usage.ClassB += transactions
+	default:
+		u.usage.ClassC += transactions
+	}
+	u.usage.BytesSent += info.BytesSent
+	u.usage.BytesReceived += info.BytesReceived
+
+	api := u.usage.APIs[info.API]
+	api.Calls++
+	api.Transactions += transactions
+	api.BytesSent += info.BytesSent
+	api.BytesReceived += info.BytesReceived
+	u.usage.APIs[info.API] = api
+}
+
+// Returns a copy of the current usage, optionally resetting the counters
+func (u *usageCounter) get(reset bool) Usage {
+	u.Lock()
+	defer u.Unlock()
+
+	usage := u.usage
+	usage.APIs = make(map[string]APIUsage, len(u.usage.APIs))
+	for k, v := range u.usage.APIs {
+		usage.APIs[k] = v
+	}
+	if usage.Since.IsZero() {
+		usage.Since = time.Now()
+	}
+
+	if reset {
+		u.usage = Usage{
+			Since: time.Now(),
+			APIs:  make(map[string]APIUsage),
+		}
+	}
+	return usage
+}
+
+// Implemented by API requests which may be billed as more than one transaction
+type transactionCounter interface {
+	transactions() int
+}
+
+// Listing calls are billed in units of 1000 files
+func listTransactions(maxFileCount int) int {
+	if maxFileCount <= 1000 {
+		return 1
+	}
+	return (maxFileCount + 999) / 1000
+}
+
+func (r *listFilesRequest) transactions() int        { return listTransactions(r.MaxFileCount) }
+func (r *listFileVersionsRequest) transactions() int { return listTransactions(r.MaxFileCount) }
+
+// Returns the number of transactions an API request will be billed as
+func requestTransactions(request interface{}) int {
+	if r, ok := request.(transactionCounter); ok {
+		return r.transactions()
+	}
+	return 1
+}
@@ -0,0 +1,79 @@
+package backblaze
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestAPIClass(T *testing.T) {
+	classes := map[string]TransactionClass{
+		"b2_upload_file":           ClassA,
+		"b2_download_file_by_name": ClassB,
+		"b2_list_file_names":       ClassC,
+		"b2_authorize_account":     ClassC,
+	}
+	for api, class := range classes {
+		if c := APIClass(api); c != class {
+			T.Errorf("Expected %s to be class %s, saw %s", api, class, c)
+		}
+	}
+}
+
+func TestUsage(T *testing.T) {
+
+	accountID := "test"
+	testFile := []byte("File contents")
+
+	client, server := prepareResponses([]response{
+		{code: 200, body: authorizeAccountResponse{
+			AccountID:          accountID,
+			APIEndpoint:        "http://api.url",
+			AuthorizationToken: "testToken",
+			DownloadURL:        "http://download.url",
+		}},
+		{code: 200, body: ListFilesResponse{}},
+		{code: 200, body: testFile},
+	})
+	defer server.Close()
+
+	b2 := &B2{
+		Credentials: Credentials{
+			AccountID:      accountID,
+			ApplicationKey: "test",
+		},
+		Debug:      testing.Verbose(),
+		httpClient: *client,
+		host:       server.URL,
+	}
+	bucket := &Bucket{
+		BucketInfo: &BucketInfo{ID: "bucketid", Name: "testbucket"},
+		b2:         b2,
+	}
+
+	if _, err := bucket.ListFileNames("", 2500); err != nil {
+		T.Fatal(err)
+	}
+
+	_, reader, err := b2.DownloadFileByID("fileId")
+	if err != nil {
+		T.Fatal(err)
+	}
+	ioutil.ReadAll(reader)
+	reader.Close()
+
+	usage := b2.ResetUsage()
+	if usage.ClassA != 0 || usage.ClassB != 1 || usage.ClassC != 4 {
+		T.Errorf("Expected 0/1/4 class A/B/C transactions, saw %d/%d/%d", usage.ClassA, usage.ClassB, usage.ClassC)
+	}
+	if usage.APIs["b2_list_file_names"].Transactions != 3 {
+		T.Errorf("Expected listing 2500 files to count as 3 transactions, saw %d", usage.APIs["b2_list_file_names"].Transactions)
+	}
+	if usage.BytesReceived <= int64(len(testFile)) {
+		T.Errorf("Expected more than %d bytes received, saw %d", len(testFile), usage.BytesReceived)
+	}
+
+	usage = b2.Usage()
+	if usage.ClassB != 0 || usage.ClassC != 0 || len(usage.APIs) != 0 {
+		T.Errorf("Expected usage to be reset, saw %+v", usage)
+	}
+}